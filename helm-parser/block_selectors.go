@@ -0,0 +1,200 @@
+package helm_parser
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Targets of a category: what its blocks apply to
+const (
+	TargetPods           = "pods"
+	TargetContainers     = "containers"
+	TargetInitContainers = "initContainers"
+)
+
+// templateActionStripRe matches a Helm template action, e.g. {{ .Release.Name }}
+var templateActionStripRe = regexp.MustCompile(`{{.*?}}`)
+
+// BlockSelector narrows a category of inject-blocks.yaml to matching workloads.
+// Empty fields match everything; names, templates and containers accept globs.
+type BlockSelector struct {
+	Kinds      []string          `yaml:"kinds"`      // Resource kinds, e.g. DaemonSet
+	Names      []string          `yaml:"names"`      // Workload names, e.g. istiod
+	Templates  []string          `yaml:"templates"`  // Template paths relative to the chart, e.g. templates/gateway/*.yaml
+	Containers []string          `yaml:"containers"` // Container names, only for the containers and initContainers targets
+	Labels     map[string]string `yaml:"labels"`     // Labels the workload must carry
+}
+
+// injectorCategory is one category of inject-blocks.yaml. It is either a plain list of
+// blocks, or a map with the blocks, a match clause and the target they apply to:
+//
+//	daemonSetPods:
+//	  match:
+//	    kinds: [DaemonSet]
+//	  target: pods
+//	  blocks:
+//	  - tolerations: [...]
+type injectorCategory struct {
+	Match  *BlockSelector  `yaml:"match"`
+	Target string          `yaml:"target"`
+	Blocks []yaml.MapSlice `yaml:"blocks"`
+}
+
+// UnmarshalYAML accepts both the plain list and the map form of a category
+func (c *injectorCategory) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var blocks []yaml.MapSlice
+	if err := unmarshal(&blocks); err == nil {
+		c.Blocks = blocks
+		return nil
+	}
+	type plain injectorCategory
+	return unmarshal((*plain)(c))
+}
+
+// categorySelector is the match clause of a category, if any, and the target its blocks apply to
+type categorySelector struct {
+	match  *BlockSelector
+	target string
+}
+
+// newCategorySelector validates the match clause and target of a category. ok is false for a
+// category that declares neither.
+func newCategorySelector(category string, c injectorCategory) (sel categorySelector, ok bool, err error) {
+	if c.Match == nil && c.Target == "" {
+		return categorySelector{}, false, nil
+	}
+	target := c.Target
+	if target == "" {
+		target = TargetPods
+		if len(c.Match.Containers) > 0 {
+			target = TargetContainers
+		}
+	}
+	if target != TargetPods && target != TargetContainers && target != TargetInitContainers {
+		return categorySelector{}, false, fmt.Errorf("category %s has an unknown target %q", category, target)
+	}
+	if c.Match != nil {
+		if target == TargetPods && len(c.Match.Containers) > 0 {
+			return categorySelector{}, false, fmt.Errorf("category %s matches containers but targets pods", category)
+		}
+		for _, pattern := range append(append(append([]string{}, c.Match.Names...), c.Match.Templates...), c.Match.Containers...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return categorySelector{}, false, fmt.Errorf("category %s has an invalid pattern %q: %v", category, pattern, err)
+			}
+		}
+	}
+	return categorySelector{match: c.Match, target: target}, true, nil
+}
+
+// Workload describes a templated resource for block selection
+type Workload struct {
+	Kind     string
+	Name     string            // Name with template actions removed
+	Labels   map[string]string // Labels with a literal value
+	Template string            // Template path relative to the chart, e.g. templates/deployment.yaml
+}
+
+// matchesAny reports whether value matches one of the glob patterns; no patterns match everything
+func matchesAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesWorkload reports whether the selector matches a workload
+func (s BlockSelector) matchesWorkload(w Workload) bool {
+	// A templated kind matches when any of the kinds it may render as does
+	if len(s.Kinds) > 0 && !slices.ContainsFunc(strings.Split(w.Kind, "|"), func(kind string) bool { return containsLine(s.Kinds, kind) }) {
+		return false
+	}
+	if !matchesAny(s.Names, w.Name) || !matchesAny(s.Templates, w.Template) {
+		return false
+	}
+	for key, value := range s.Labels {
+		if w.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// selectedCategories returns the categories with a match clause for target that select the
+// workload. A category listed by a profile is only selected when the profile is.
+func (c *InjectorConfig) selectedCategories(w Workload, target string, profiles []string) []string {
+	var categories []string
+	for category := range c.Blocks {
+		sel, ok := c.selectors[category]
+		if !ok || sel.match == nil || sel.target != target || !c.enabledByProfiles(category, profiles) {
+			continue
+		}
+		if sel.match.matchesWorkload(w) {
+			categories = append(categories, category)
+		}
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+// stripTemplateActions removes Helm template actions and quotes from a value
+func stripTemplateActions(value string) string {
+	return strings.Trim(strings.TrimSpace(templateActionStripRe.ReplaceAllString(value, "")), `"'`)
+}
+
+// templateWorkload reads the kind, name and labels of the resource in a template.
+// Only the top-level metadata is read; templated labels are ignored.
+func templateWorkload(chartDir, templatePath, content, kind string) Workload {
+	w := Workload{Kind: kind, Labels: make(map[string]string)}
+	if rel, err := filepath.Rel(chartDir, templatePath); err == nil {
+		w.Template = filepath.ToSlash(rel)
+	}
+
+	lines := strings.Split(content, "\n")
+	inMetadata, inLabels := false, false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "{{") {
+			continue
+		}
+		indent := GetIndentation(line)
+		if indent == 0 {
+			inMetadata, inLabels = trimmed == "metadata:", false
+			continue
+		}
+		if !inMetadata {
+			continue
+		}
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch {
+		case indent == 2:
+			inLabels = key == "labels"
+			if key == "name" {
+				w.Name = stripTemplateActions(value)
+			}
+		case inLabels && indent == 4 && !strings.Contains(line, "{{"):
+			w.Labels[strings.Trim(key, `"'`)] = strings.Trim(value, `"'`)
+		}
+	}
+	return w
+}
+
+// containerName returns the name on a "- name:" container line with template actions removed
+func containerName(line string) string {
+	return stripTemplateActions(strings.TrimPrefix(strings.TrimSpace(line), "- name:"))
+}
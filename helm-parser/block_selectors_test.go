@@ -0,0 +1,204 @@
+package helm_parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const selectorTestBlocks = `allPods:
+- priorityClassName: platform-default
+daemonSetPods:
+  match:
+    kinds: [DaemonSet]
+  blocks:
+  - tolerations:
+    - operator: Exists
+istiodPods:
+  match:
+    names: [istiod]
+    labels:
+      app: istiod
+  blocks:
+  - nodeSelector:
+      pool: control
+proxyContainers:
+  match:
+    templates: [templates/istiod-*.yaml]
+    containers: [proxy]
+  blocks:
+  - resources:
+      limits:
+        memory: 1Gi
+`
+
+const selectorTestIstiod = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: istiod{{ .Values.revision }}
+  labels:
+    app: istiod
+    release: {{ .Release.Name }}
+spec:
+  template:
+    spec:
+      containers:
+      - name: discovery
+        image: pilot:1.0
+      - name: proxy
+        image: proxyv2:1.0
+`
+
+const selectorTestAgent = `apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: agent
+spec:
+  template:
+    spec:
+      {{- with .Values.agent.tolerations }}
+      tolerations:
+        {{- toYaml . | nindent 8 }}
+      {{- end }}
+      containers:
+      - name: proxy
+        image: agent:1.0
+`
+
+func TestTemplateWorkload(t *testing.T) {
+	w := templateWorkload("/chart", "/chart/templates/istiod/deployment.yaml", selectorTestIstiod, "Deployment")
+	if w.Name != "istiod" || w.Template != "templates/istiod/deployment.yaml" {
+		t.Errorf("Unexpected workload %+v", w)
+	}
+	if len(w.Labels) != 1 || w.Labels["app"] != "istiod" {
+		t.Errorf("Expected only the literal label, got %v", w.Labels)
+	}
+	t.Log("✓ Workload metadata read from the template")
+}
+
+func TestProcessTemplates_MatchSelectors(t *testing.T) {
+	chartDir := writeTestChart(t, "agent:\n  tolerations: []\n", map[string]string{
+		"istiod-deployment.yaml": selectorTestIstiod,
+		"agent.yaml":             selectorTestAgent,
+	})
+	useFileStore(t, NewFileStore(false))
+	blocksPath := filepath.Join(t.TempDir(), "inject-blocks.yaml")
+	if err := os.WriteFile(blocksPath, []byte(selectorTestBlocks), 0644); err != nil {
+		t.Fatalf("Failed to write blocks: %v", err)
+	}
+
+	if err := ProcessTemplates(chartDir, nil, blocksPath, nil, "", RenderOptions{}); err != nil {
+		t.Fatalf("ProcessTemplates failed: %v", err)
+	}
+
+	istiod, _ := os.ReadFile(filepath.Join(chartDir, "templates", "istiod-deployment.yaml"))
+	agent, _ := os.ReadFile(filepath.Join(chartDir, "templates", "agent.yaml"))
+	values, _ := os.ReadFile(filepath.Join(chartDir, "values.yaml"))
+
+	checks := []struct {
+		name    string
+		content []byte
+		text    string
+		want    bool
+	}{
+		{"global blocks everywhere", istiod, "priorityClassName: platform-default", true},
+		{"global blocks everywhere", agent, "priorityClassName: platform-default", true},
+		{"name and label selector", istiod, "pool: control", true},
+		{"name and label selector", agent, "pool: control", false},
+		{"kind selector into values", values, "- operator: Exists", true},
+		{"kind selector", istiod, "operator: Exists", false},
+		{"container selector", istiod, "memory: 1Gi", true},
+		{"template selector", agent, "memory: 1Gi", false},
+	}
+	for _, c := range checks {
+		if strings.Contains(string(c.content), c.text) != c.want {
+			t.Errorf("%s: expected %q present=%v in:\n%s", c.name, c.text, c.want, c.content)
+		}
+	}
+	// Only the proxy container gets the resources
+	if idx := strings.Index(string(istiod), "memory: 1Gi"); idx < strings.Index(string(istiod), "- name: proxy") {
+		t.Errorf("Expected resources under the proxy container:\n%s", istiod)
+	}
+	t.Log("✓ Blocks applied to the workloads and containers they match")
+}
+
+func TestProcessTemplates_MatchSelectorsSharedValues(t *testing.T) {
+	// The DaemonSet and the Deployment read the same tolerations, the agent reads its own
+	sharedTolerations := `
+      {{- with .Values.tolerations }}
+      tolerations:
+        {{- toYaml . | nindent 8 }}
+      {{- end }}
+      containers:
+      - name: app
+        image: app:1.0
+`
+	chartDir := writeTestChart(t, "tolerations: []\nagent:\n  tolerations: []\n", map[string]string{
+		"daemonset.yaml":  "apiVersion: apps/v1\nkind: DaemonSet\nmetadata:\n  name: node\nspec:\n  template:\n    spec:" + sharedTolerations,
+		"deployment.yaml": "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\nspec:\n  template:\n    spec:" + sharedTolerations,
+		"agent.yaml":      selectorTestAgent,
+	})
+	useFileStore(t, NewFileStore(false))
+	blocksPath := filepath.Join(t.TempDir(), "inject-blocks.yaml")
+	blocks := "dsPods:\n  match:\n    kinds: [DaemonSet]\n  blocks:\n  - tolerations:\n    - key: ds-only\n      operator: Exists\n"
+	if err := os.WriteFile(blocksPath, []byte(blocks), 0644); err != nil {
+		t.Fatalf("Failed to write blocks: %v", err)
+	}
+
+	if err := ProcessTemplates(chartDir, nil, blocksPath, nil, "", RenderOptions{}); err != nil {
+		t.Fatalf("ProcessTemplates failed: %v", err)
+	}
+	values, _ := os.ReadFile(filepath.Join(chartDir, "values.yaml"))
+	if !strings.HasPrefix(string(values), "tolerations: []\n") || !strings.Contains(string(values), "key: ds-only") {
+		t.Errorf("Expected only the agent tolerations to be injected into values.yaml:\n%s", values)
+	}
+
+	rel, err := renderChartFromValues(chartDir, RenderOptions{})
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	for _, doc := range splitDocuments(rel.Manifest) {
+		want := !strings.Contains(doc, "kind: Deployment")
+		if strings.Contains(doc, "key: ds-only") != want {
+			t.Errorf("Expected the ds-only toleration present=%v in:\n%s", want, doc)
+		}
+	}
+	t.Log("✓ Values shared with unmatched workloads left alone, matched blocks injected inline")
+}
+
+func TestLoadInjectorConfig_SelectorsPerLoad(t *testing.T) {
+	matched := loadTestConfig(t, selectorTestBlocks)
+	plain := loadTestConfig(t, "allPods: []\ndaemonSetPods:\n- tolerations:\n  - operator: Exists\n")
+	if _, ok := plain.selectors["daemonSetPods"]; ok {
+		t.Errorf("Expected no selector for the plain category, got %+v", plain.selectors)
+	}
+	agent := Workload{Kind: "DaemonSet", Name: "agent"}
+	if got := matched.selectedCategories(agent, TargetPods, nil); len(got) != 1 || got[0] != "daemonSetPods" {
+		t.Errorf("Expected the first load to keep its selectors after another load, got %v", got)
+	}
+	if got := plain.selectedCategories(agent, TargetPods, nil); len(got) != 0 {
+		t.Errorf("Expected no selected categories, got %v", got)
+	}
+	t.Log("✓ Selectors carried by each loaded configuration")
+}
+
+func TestLoadInjectorBlocks_InvalidMatch(t *testing.T) {
+	tests := map[string]string{
+		"unknown target":               "x:\n  match: {kinds: [Deployment]}\n  target: jobs\n  blocks: []\n",
+		"containers for pods":          "x:\n  match: {containers: [proxy]}\n  target: pods\n  blocks: []\n",
+		"unknown target without match": "x:\n  target: jobs\n  blocks: []\n",
+		"invalid name pattern":         "x:\n  match: {names: ['[']}\n  blocks: []\n",
+		"unknown match field":          "x:\n  match: {kind: Deployment}\n  blocks: []\n",
+	}
+	for name, content := range tests {
+		path := filepath.Join(t.TempDir(), "inject-blocks.yaml")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write blocks: %v", err)
+		}
+		if _, err := loadInjectorBlocks(path, ""); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	t.Log("✓ Invalid match clauses rejected")
+}
@@ -0,0 +1,166 @@
+package helm_parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// diffOp is a single line of an edit script: ' ' (keep), '-' (delete) or '+' (insert)
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// splitDiffLines splits text into lines, dropping the empty element after a trailing newline
+func splitDiffLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes a line edit script turning a into b using a longest common subsequence.
+// Common prefix and suffix are trimmed first so typical edits stay cheap.
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []diffOp
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+
+	midA := a[prefix : len(a)-suffix]
+	midB := b[prefix : len(b)-suffix]
+
+	// lcs[i][j] holds the LCS length of midA[i:] and midB[j:]
+	lcs := make([][]int32, len(midA)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(midB)+1)
+	}
+	for i := len(midA) - 1; i >= 0; i-- {
+		for j := len(midB) - 1; j >= 0; j-- {
+			if midA[i] == midB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(midA) && j < len(midB) {
+		switch {
+		case midA[i] == midB[j]:
+			ops = append(ops, diffOp{' ', midA[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', midA[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', midB[j]})
+			j++
+		}
+	}
+	for ; i < len(midA); i++ {
+		ops = append(ops, diffOp{'-', midA[i]})
+	}
+	for ; j < len(midB); j++ {
+		ops = append(ops, diffOp{'+', midB[j]})
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// unifiedDiff returns a unified diff between two texts, or "" when they are identical
+func unifiedDiff(from, to, fromName, toName string) string {
+	if from == to {
+		return ""
+	}
+	ops := diffLines(splitDiffLines(from), splitDiffLines(to))
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+
+	// Walk the edit script, emitting one hunk per group of changes that are
+	// within 2*diffContext lines of each other
+	idx := 0
+	for idx < len(ops) {
+		// Find the next change
+		start := idx
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		// Extend the hunk while changes are close together
+		end := start
+		for k := start; k < len(ops); k++ {
+			if ops[k].kind != ' ' {
+				end = k + 1
+				continue
+			}
+			if k-end >= 2*diffContext {
+				break
+			}
+		}
+		hunkStart := max(start-diffContext, 0)
+		hunkEnd := min(end+diffContext, len(ops))
+
+		// Line numbers of the hunk start in both files
+		fromLine, toLine := 1, 1
+		for _, op := range ops[:hunkStart] {
+			if op.kind != '+' {
+				fromLine++
+			}
+			if op.kind != '-' {
+				toLine++
+			}
+		}
+		fromCount, toCount := 0, 0
+		for _, op := range ops[hunkStart:hunkEnd] {
+			if op.kind != '+' {
+				fromCount++
+			}
+			if op.kind != '-' {
+				toCount++
+			}
+		}
+		// Empty ranges point at the line before the hunk, as diff -u does
+		if fromCount == 0 {
+			fromLine--
+		}
+		if toCount == 0 {
+			toLine--
+		}
+
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", fromLine, fromCount, toLine, toCount)
+		for _, op := range ops[hunkStart:hunkEnd] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+			sb.WriteByte('\n')
+		}
+		idx = hunkEnd
+	}
+	return sb.String()
+}
@@ -0,0 +1,198 @@
+package helm_parser
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// Files is the store every chart read and write goes through. In dry-run mode
+	// writes are staged in memory so the working tree is never touched.
+	Files = NewFileStore(false)
+	// ErrPendingChanges is returned by a dry run that would have modified files
+	ErrPendingChanges = errors.New("dry run: changes pending")
+)
+
+// backupDirName is the directory of a chart holding the original of every file a run modified,
+// at the same path relative to the chart
+const backupDirName = ".helm-parser-backup"
+
+// stagedFile holds the on-disk content of a file and the content we would write
+type stagedFile struct {
+	original []byte
+	content  []byte
+	existed  bool
+}
+
+// FileStore reads files from disk and either writes them straight back (normal mode)
+// or keeps the new content in memory (dry-run mode). Reads always see staged content
+// so later phases (rendering, injection) work on the edited chart.
+type FileStore struct {
+	mu     sync.Mutex
+	dryRun bool
+	staged map[string]*stagedFile
+	chart  string // Chart whose files are backed up before they are first written, if any
+}
+
+// NewFileStore creates a file store, staging writes in memory when dryRun is set
+func NewFileStore(dryRun bool) *FileStore {
+	return &FileStore{
+		dryRun: dryRun,
+		staged: make(map[string]*stagedFile),
+	}
+}
+
+// DryRun reports whether writes are staged instead of written to disk
+func (fs *FileStore) DryRun() bool {
+	return fs.dryRun
+}
+
+// storeKey normalises a path so the same file is always staged under one key
+func storeKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// ReadFile returns the staged content of path if any, otherwise the file on disk
+func (fs *FileStore) ReadFile(path string) ([]byte, error) {
+	fs.mu.Lock()
+	sf, ok := fs.staged[storeKey(path)]
+	fs.mu.Unlock()
+	if ok {
+		return append([]byte(nil), sf.content...), nil
+	}
+	return os.ReadFile(path)
+}
+
+// BackupChart makes the store back up every file of the chart at chartPath before it is first
+// written, so RestoreChart can undo every edit of a run. Writes are never staged in dry-run
+// mode, so nothing is backed up.
+func (fs *FileStore) BackupChart(chartPath string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.chart = chartPath
+}
+
+// backup copies path to the backup directory of the chart unless it is already there. A backup
+// left by an earlier run holds the original chart, so it is kept. Files outside the chart and
+// files that do not exist yet are not backed up.
+func (fs *FileStore) backup(path string) error {
+	fs.mu.Lock()
+	chart := fs.chart
+	fs.mu.Unlock()
+	if chart == "" || fs.dryRun {
+		return nil
+	}
+	rel, err := filepath.Rel(storeKey(chart), storeKey(path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || strings.HasPrefix(rel, backupDirName) {
+		return nil
+	}
+	backupPath := filepath.Join(chart, backupDirName, rel)
+	if _, err := os.Stat(backupPath); err == nil {
+		return nil
+	}
+	original, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read %s for backup: %w", path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(backupPath), 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	if err := os.WriteFile(backupPath, original, 0644); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", rel, err)
+	}
+	Logger.Infof("Backed up %s to %s", rel, backupPath)
+	return nil
+}
+
+// WriteFile writes data to path, or stages it in memory in dry-run mode
+func (fs *FileStore) WriteFile(path string, data []byte, perm os.FileMode) error {
+	if !fs.dryRun {
+		if err := fs.backup(path); err != nil {
+			return err
+		}
+		return os.WriteFile(path, data, perm)
+	}
+
+	key := storeKey(path)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	sf, ok := fs.staged[key]
+	if !ok {
+		sf = &stagedFile{}
+		original, err := os.ReadFile(path)
+		if err == nil {
+			sf.original = original
+			sf.existed = true
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read %s before staging: %w", path, err)
+		}
+		fs.staged[key] = sf
+	}
+	sf.content = append([]byte(nil), data...)
+	return nil
+}
+
+// StagedFiles returns the staged content of every file that differs from disk, keyed by absolute path
+func (fs *FileStore) StagedFiles() map[string][]byte {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	result := make(map[string][]byte)
+	for path, sf := range fs.staged {
+		if sf.existed && string(sf.original) == string(sf.content) {
+			continue
+		}
+		result[path] = sf.content
+	}
+	return result
+}
+
+// Pending reports whether any staged file differs from its on-disk content
+func (fs *FileStore) Pending() bool {
+	return len(fs.StagedFiles()) > 0
+}
+
+// WriteDiff writes a unified diff of every staged file to w, sorted by path.
+// Paths are shown relative to baseDir when possible.
+func (fs *FileStore) WriteDiff(w io.Writer, baseDir string) error {
+	fs.mu.Lock()
+	paths := make([]string, 0, len(fs.staged))
+	for path := range fs.staged {
+		paths = append(paths, path)
+	}
+	fs.mu.Unlock()
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		fs.mu.Lock()
+		sf := fs.staged[path]
+		fs.mu.Unlock()
+
+		name := path
+		if rel, err := filepath.Rel(storeKey(baseDir), path); err == nil {
+			name = rel
+		}
+		fromName := "a/" + name
+		if !sf.existed {
+			fromName = "/dev/null"
+		}
+		diff := unifiedDiff(string(sf.original), string(sf.content), fromName, "b/"+name)
+		if diff == "" {
+			continue
+		}
+		if _, err := io.WriteString(w, diff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package helm_parser
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestChart creates a minimal chart in a temp directory with the given values.yaml
+// and templates (keyed by file name under templates/) and returns its path
+func writeTestChart(t *testing.T, values string, templates map[string]string) string {
+	t.Helper()
+	chartDir := t.TempDir()
+	chartYaml := "apiVersion: v2\nname: testchart\nversion: 0.1.0\nappVersion: \"1.0\"\n"
+	if err := os.WriteFile(filepath.Join(chartDir, "Chart.yaml"), []byte(chartYaml), 0644); err != nil {
+		t.Fatalf("Failed to write Chart.yaml: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(chartDir, "values.yaml"), []byte(values), 0644); err != nil {
+		t.Fatalf("Failed to write values.yaml: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(chartDir, "templates"), 0755); err != nil {
+		t.Fatalf("Failed to create templates dir: %v", err)
+	}
+	for name, content := range templates {
+		if err := os.WriteFile(filepath.Join(chartDir, "templates", name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write template %s: %v", name, err)
+		}
+	}
+	return chartDir
+}
+
+// useFileStore swaps the package file store for the duration of a test
+func useFileStore(t *testing.T, fs *FileStore) {
+	t.Helper()
+	previous := Files
+	Files = fs
+	t.Cleanup(func() { Files = previous })
+}
+
+func TestFileStore_DryRunStagesWrites(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "values.yaml")
+	if err := os.WriteFile(path, []byte("a: 1\nb: 2\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	fs := NewFileStore(true)
+	if err := fs.WriteFile(path, []byte("a: 1\nb: 3\n"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	onDisk, _ := os.ReadFile(path)
+	if string(onDisk) != "a: 1\nb: 2\n" {
+		t.Errorf("Expected file on disk to be untouched, got %q", onDisk)
+	}
+
+	staged, err := fs.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if string(staged) != "a: 1\nb: 3\n" {
+		t.Errorf("Expected ReadFile to return staged content, got %q", staged)
+	}
+
+	if !fs.Pending() {
+		t.Error("Expected pending changes")
+	}
+
+	var out bytes.Buffer
+	if err := fs.WriteDiff(&out, tmpDir); err != nil {
+		t.Fatalf("WriteDiff failed: %v", err)
+	}
+	diff := out.String()
+	for _, want := range []string{"--- a/values.yaml", "+++ b/values.yaml", "@@ -1,2 +1,2 @@", "-b: 2", "+b: 3", " a: 1"} {
+		if !strings.Contains(diff, want) {
+			t.Errorf("Expected diff to contain %q, got:\n%s", want, diff)
+		}
+	}
+}
+
+func TestFileStore_UnchangedWriteIsNotPending(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "values.yaml")
+	if err := os.WriteFile(path, []byte("a: 1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	fs := NewFileStore(true)
+	if err := fs.WriteFile(path, []byte("a: 1\n"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if fs.Pending() {
+		t.Error("Expected no pending changes when content is unchanged")
+	}
+}
+
+func TestUnifiedDiff_SeparateHunks(t *testing.T) {
+	var from, to []string
+	for i := 0; i < 30; i++ {
+		from = append(from, "line")
+		to = append(to, "line")
+	}
+	from[2], to[2] = "old-a", "new-a"
+	from[25], to[25] = "old-b", "new-b"
+
+	diff := unifiedDiff(strings.Join(from, "\n")+"\n", strings.Join(to, "\n")+"\n", "a/f", "b/f")
+	if count := strings.Count(diff, "@@ -"); count != 2 {
+		t.Errorf("Expected 2 hunks, got %d:\n%s", count, diff)
+	}
+	if !strings.Contains(diff, "@@ -1,6 +1,6 @@") || !strings.Contains(diff, "@@ -23,7 +23,7 @@") {
+		t.Errorf("Unexpected hunk headers:\n%s", diff)
+	}
+}
+
+func TestDryRun_RegistryUpdateRendersStagedValues(t *testing.T) {
+	chartDir := writeTestChart(t, "image:\n  repository: docker.io/library/nginx\n  tag: \"1.25\"\n", map[string]string{
+		"pod.yaml": `apiVersion: v1
+kind: Pod
+metadata:
+  name: test
+spec:
+  containers:
+  - name: app
+    image: {{ .Values.image.repository }}:{{ .Values.image.tag }}
+`,
+	})
+	useFileStore(t, NewFileStore(true))
+
+	if err := UpdateRegistryInValuesFile(chartDir, "registry.example.com/ext"); err != nil {
+		t.Fatalf("UpdateRegistryInValuesFile failed: %v", err)
+	}
+
+	onDisk, _ := os.ReadFile(filepath.Join(chartDir, "values.yaml"))
+	if strings.Contains(string(onDisk), "registry.example.com") {
+		t.Error("Expected values.yaml on disk to be untouched in dry-run mode")
+	}
+
+	rel, err := renderChartFromValues(chartDir, RenderOptions{})
+	if err != nil {
+		t.Fatalf("renderChartFromValues failed: %v", err)
+	}
+	images, _ := ExtractImagesFromManifest(rel.Manifest)
+	if len(images) != 1 || images[0] != "registry.example.com/ext/docker.io/nginx:1.25" {
+		t.Errorf("Expected rendered image to use staged registry, got %v", images)
+	}
+}
@@ -0,0 +1,147 @@
+package helm_parser
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// helmControlRe matches the Helm actions that open, split or close a control structure,
+	// e.g. {{- with .Values.tolerations }}, {{- else }} or {{- end }}
+	helmControlRe = regexp.MustCompile(`{{-?\s*(if|with|range|define|block|else|end)\b(.*?)-?}}`)
+	// valuesPipelineRe matches a pipeline that is just a .Values path, e.g. .Values.global.tolerations
+	valuesPipelineRe = regexp.MustCompile(`^\(?\s*\.Values\.([\w.]+?)\s*\)?$`)
+	// renderedValueRe matches a value rendered as YAML: toYaml, toJson or the "value" of a
+	// tplvalues include, of a .Values path or of the dot
+	renderedValueRe = regexp.MustCompile(`(?:toYaml|toJson|toPrettyJson|"value")\s+\(?(\.Values\.[\w.]+|\.)(?:[\s|)}]|$)`)
+	// nindentRe matches the indentation a template renders its output at
+	nindentRe = regexp.MustCompile(`\bn?indent\s+(\d+)`)
+)
+
+// helmBlock is a Helm control structure spanning several lines of a template
+type helmBlock struct {
+	action   string // if, with, range, define or block
+	pipeline string // Pipeline of the opening action, e.g. .Values.tolerations
+	start    int    // Line of the opening action
+	elseLine int    // Line of the first {{ else }}, or -1
+	end      int    // Line of the closing {{ end }}
+}
+
+// helmBlocks returns the control structures of a template that span more than one line,
+// innermost first. Unbalanced actions are ignored.
+func helmBlocks(lines []string) []helmBlock {
+	var blocks, stack []helmBlock
+	for i, line := range lines {
+		for _, m := range helmControlRe.FindAllStringSubmatch(line, -1) {
+			switch m[1] {
+			case "else":
+				if len(stack) > 0 && stack[len(stack)-1].elseLine == -1 {
+					stack[len(stack)-1].elseLine = i
+				}
+			case "end":
+				if len(stack) == 0 {
+					continue
+				}
+				b := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if b.start != i {
+					b.end = i
+					blocks = append(blocks, b)
+				}
+			default:
+				stack = append(stack, helmBlock{action: m[1], pipeline: strings.TrimSpace(m[2]), start: i, elseLine: -1})
+			}
+		}
+	}
+	return blocks
+}
+
+// enclosingBlock returns the innermost control structure around a line
+func enclosingBlock(blocks []helmBlock, index int) (helmBlock, bool) {
+	var inner helmBlock
+	found := false
+	for _, b := range blocks {
+		if b.start < index && index < b.end && (!found || b.start > inner.start) {
+			inner, found = b, true
+		}
+	}
+	return inner, found
+}
+
+// valuesPipelinePath returns the .Values path of a pipeline that is only a .Values path
+func valuesPipelinePath(pipeline string) (string, bool) {
+	m := valuesPipelineRe.FindStringSubmatch(pipeline)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// renderedValuesPath returns the .Values path a template line renders as YAML, resolving
+// the dot to the .Values path of the enclosing {{ with }}
+func renderedValuesPath(lines []string, blocks []helmBlock, index int) (string, bool) {
+	m := renderedValueRe.FindStringSubmatch(lines[index])
+	if m == nil {
+		return "", false
+	}
+	if m[1] != "." {
+		return strings.TrimPrefix(m[1], ".Values."), true
+	}
+	for i := index; ; {
+		b, ok := enclosingBlock(blocks, i)
+		if !ok {
+			return "", false
+		}
+		if b.action == "with" && (b.elseLine == -1 || index < b.elseLine) {
+			return valuesPipelinePath(b.pipeline)
+		}
+		i = b.start
+	}
+}
+
+// blockValueKeys maps the .Values paths that a template renders as the whole value of a pod
+// or container key to that key, e.g. .Values.global.tolerations to tolerations for
+//
+//	{{- with .Values.global.tolerations }}
+//	tolerations:
+//	  {{- toYaml . | nindent 8 }}
+//	{{- end }}
+func blockValueKeys(lines []string) map[string]string {
+	blocks := helmBlocks(lines)
+	keys := make(map[string]string)
+	for i, line := range lines {
+		trimmed := strings.TrimPrefix(strings.TrimSpace(line), "- ")
+		if strings.HasPrefix(trimmed, "{{") || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		key, value, ok := ExtractKeyValue(trimmed)
+		if !ok || (!containsLine(podConfigKeys, key) && !containsLine(containerConfigKeys, key)) {
+			continue
+		}
+		// The value is on the key line or in the template lines below it
+		candidates := []int{i}
+		if value == "" {
+			keyIndent := GetIndentation(line)
+			for j := i + 1; j < len(lines); j++ {
+				t := strings.TrimSpace(lines[j])
+				if t == "" || strings.HasPrefix(t, "#") {
+					continue
+				}
+				// The value ends at the next key, or at a control action closing or opening at its level
+				if GetIndentation(lines[j]) <= keyIndent && (!strings.HasPrefix(t, "{{") || helmControlRe.MatchString(t)) {
+					break
+				}
+				if strings.HasPrefix(t, "{{") {
+					candidates = append(candidates, j)
+				}
+			}
+		}
+		for _, j := range candidates {
+			if path, ok := renderedValuesPath(lines, blocks, j); ok {
+				keys[path] = key
+				break
+			}
+		}
+	}
+	return keys
+}
@@ -0,0 +1,115 @@
+package helm_parser
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "Rewrite the golden files in testdata")
+
+// TestProcessTemplates_HelmControlGolden injects testdata/helm_templates/inject-blocks.yaml into
+// templates written in the patterns charts commonly use (nindent includes, {{- with }} toYaml,
+// {{- if }} outdented from the keys) and compares the results with the golden files. They
+// are not copies of upstream charts. Run with -update to rewrite them.
+func TestProcessTemplates_HelmControlGolden(t *testing.T) {
+	root := filepath.Join("testdata", "helm_templates")
+	cases, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", root, err)
+	}
+	blocksPath, _ := filepath.Abs(filepath.Join(root, "inject-blocks.yaml"))
+
+	for _, c := range cases {
+		if !c.IsDir() {
+			continue
+		}
+		t.Run(c.Name(), func(t *testing.T) {
+			dir := filepath.Join(root, c.Name())
+			values, err := os.ReadFile(filepath.Join(dir, "values.yaml"))
+			if err != nil {
+				t.Fatalf("Failed to read values: %v", err)
+			}
+			template, err := os.ReadFile(filepath.Join(dir, "template.yaml"))
+			if err != nil {
+				t.Fatalf("Failed to read template: %v", err)
+			}
+			chartDir := writeTestChart(t, string(values), map[string]string{"template.yaml": string(template)})
+			useFileStore(t, NewFileStore(false))
+
+			// A second run must not change anything
+			var firstRun []byte
+			for run := 0; run < 2; run++ {
+				if err := ProcessTemplates(chartDir, nil, blocksPath, nil, "", RenderOptions{}); err != nil {
+					t.Fatalf("ProcessTemplates failed: %v", err)
+				}
+				content, _ := os.ReadFile(filepath.Join(chartDir, "templates", "template.yaml"))
+				if run == 1 && string(content) != string(firstRun) {
+					t.Errorf("Second run changed the template:\n%s", content)
+				}
+				firstRun = content
+			}
+
+			for golden, result := range map[string]string{
+				"values.golden.yaml":   filepath.Join(chartDir, "values.yaml"),
+				"template.golden.yaml": filepath.Join(chartDir, "templates", "template.yaml"),
+			} {
+				got, err := os.ReadFile(result)
+				if err != nil {
+					t.Fatalf("Failed to read result: %v", err)
+				}
+				goldenPath := filepath.Join(dir, golden)
+				if *updateGolden {
+					if err := os.WriteFile(goldenPath, got, 0644); err != nil {
+						t.Fatalf("Failed to write %s: %v", goldenPath, err)
+					}
+					continue
+				}
+				want, err := os.ReadFile(goldenPath)
+				if err != nil {
+					t.Fatalf("Failed to read %s (run with -update to create it): %v", goldenPath, err)
+				}
+				if string(got) != string(want) {
+					t.Errorf("%s differs from %s:\n%s", result, goldenPath, got)
+				}
+			}
+		})
+	}
+	t.Log("✓ Templates injected as in the golden files")
+}
+
+func TestDetectValueReferences_RenderedKeys(t *testing.T) {
+	template := `spec:
+  template:
+    spec:
+      {{- with .Values.global.defaultTolerations }}
+      tolerations:
+        {{- toYaml . | nindent 8 }}
+      {{- end }}
+      affinity: {{ toYaml .Values.scheduling.affinity | nindent 8 }}
+      containers:
+      - name: app
+        image: {{ .Values.image.repository }}
+        env:
+        {{- include "common.tplvalues.render" (dict "value" .Values.app.extraEnv "context" $) | nindent 8 }}
+`
+	want := map[string]string{
+		"global.defaultTolerations": "tolerations",
+		"scheduling.affinity":       "affinity",
+		"image.repository":          "repository",
+		"app.extraEnv":              "env",
+	}
+	for _, ref := range DetectValueReferences(template) {
+		path := strings.Join(ref.Path, ".")
+		if key, ok := want[path]; ok && ref.Key != key {
+			t.Errorf("Expected .Values.%s to be keyed %s, got %s", path, key, ref.Key)
+		}
+		delete(want, path)
+	}
+	if len(want) > 0 {
+		t.Errorf("Missing references %v", want)
+	}
+	t.Log("✓ Values rendered under a pod or container key keyed by that key")
+}
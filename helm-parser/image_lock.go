@@ -0,0 +1,351 @@
+package helm_parser
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	regauthn "github.com/google/go-containerregistry/pkg/authn"
+	regname "github.com/google/go-containerregistry/pkg/name"
+	regremote "github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// ImageLockFile is the name of the image lock file written to the chart directory
+	ImageLockFile = "images.lock.yaml"
+	// manifestSourcePrefix marks the template a rendered document came from, as in helm template output
+	manifestSourcePrefix = "# Source: "
+)
+
+// ImageSource records where a rendered image is used
+type ImageSource struct {
+	Template  string `yaml:"template"`
+	Kind      string `yaml:"kind,omitempty"`
+	Name      string `yaml:"name,omitempty"`
+	Container string `yaml:"container"`
+}
+
+// ImageUse is an image together with the container that uses it
+type ImageUse struct {
+	Image string
+	ImageSource
+}
+
+// LockedImage is a single entry of the image lock file
+type LockedImage struct {
+	Source    string        `yaml:"source"`              // Upstream image reference
+	Local     string        `yaml:"local,omitempty"`     // Image reference in the local repository
+	Digest    string        `yaml:"digest,omitempty"`    // Manifest or index digest
+	Platforms []string      `yaml:"platforms,omitempty"` // Platforms of a multi-arch image, or the single image platform
+	Exists    bool          `yaml:"exists"`              // Whether the local reference exists in the local registry
+	UsedBy    []ImageSource `yaml:"usedBy"`
+}
+
+// ImageLock is the inventory of every image the chart deploys
+type ImageLock struct {
+	Images []LockedImage `yaml:"images"`
+}
+
+// ExtractImageSources returns every container image in the rendered manifest together with
+// the template, resource and container it belongs to
+func ExtractImageSources(manifest string) ([]ImageUse, error) {
+	var uses []ImageUse
+	for i, p := range splitDocuments(manifest) {
+		template := ""
+		for _, line := range strings.Split(p, "\n") {
+			if strings.HasPrefix(line, manifestSourcePrefix) {
+				template = strings.TrimSpace(strings.TrimPrefix(line, manifestSourcePrefix))
+				break
+			}
+		}
+		var doc interface{}
+		if err := yaml.Unmarshal([]byte(p), &doc); err != nil {
+			Logger.Warnf("skipping document %d due to yaml unmarshal error: %v", i, err)
+			continue
+		}
+		m, ok := convertMapI2MapS(doc).(map[string]interface{})
+		if !ok {
+			continue
+		}
+		source := ImageSource{Template: template}
+		source.Kind, _ = m["kind"].(string)
+		if meta, ok := m["metadata"].(map[string]interface{}); ok {
+			source.Name, _ = meta["name"].(string)
+		}
+		collectImageUses(m, source, &uses)
+		for _, c := range customContainers(m) {
+			if use, ok := containerImageUse(c, source); ok {
+				uses = append(uses, use)
+			}
+		}
+	}
+	return uses, nil
+}
+
+// collectImageUses walks a converted document and records the image of every container
+func collectImageUses(node interface{}, source ImageSource, uses *[]ImageUse) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for k, v := range n {
+			if k != "containers" && k != "initContainers" {
+				collectImageUses(v, source, uses)
+				continue
+			}
+			sl, ok := v.([]interface{})
+			if !ok {
+				continue
+			}
+			for _, item := range sl {
+				c, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if use, ok := containerImageUse(c, source); ok {
+					*uses = append(*uses, use)
+				}
+			}
+		}
+	case []interface{}:
+		for _, e := range n {
+			collectImageUses(e, source, uses)
+		}
+	}
+}
+
+// containerImageUse records the image of a container, if it sets one
+func containerImageUse(c map[string]interface{}, source ImageSource) (ImageUse, bool) {
+	img, _ := c["image"].(string)
+	if img == "" {
+		return ImageUse{}, false
+	}
+	use := ImageUse{Image: img, ImageSource: source}
+	use.Container, _ = c["name"].(string)
+	return use, true
+}
+
+// describeImage returns the digest of an image and the platforms it is built for
+func describeImage(ctx context.Context, image string, keychain regauthn.Keychain) (string, []string, error) {
+	ref, err := regname.ParseReference(image)
+	if err != nil {
+		return "", nil, err
+	}
+	desc, err := regremote.Get(ref, regremote.WithAuthFromKeychain(keychain), regremote.WithContext(ctx))
+	if err != nil {
+		return "", nil, err
+	}
+	var platforms []string
+	if desc.MediaType.IsIndex() {
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return "", nil, err
+		}
+		im, err := idx.IndexManifest()
+		if err != nil {
+			return "", nil, err
+		}
+		for _, m := range im.Manifests {
+			// Skip attestation manifests, which have an unknown platform
+			if m.Platform != nil && m.Platform.OS != "unknown" {
+				platforms = append(platforms, m.Platform.String())
+			}
+		}
+	} else {
+		img, err := desc.Image()
+		if err != nil {
+			return "", nil, err
+		}
+		cf, err := img.ConfigFile()
+		if err != nil {
+			return "", nil, err
+		}
+		if p := cf.Platform(); p != nil {
+			platforms = append(platforms, p.String())
+		}
+	}
+	return desc.Digest.String(), platforms, nil
+}
+
+// BuildImageLock builds the lock entries for every image in the rendered manifest. The digest
+// and platforms are read from the local copy if it exists, otherwise from the upstream image.
+func BuildImageLock(ctx context.Context, manifest string, localRepo string, keychain regauthn.Keychain) (*ImageLock, error) {
+	uses, err := ExtractImageSources(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if keychain == nil {
+		keychain = staticKeychain{}
+	}
+
+	entries := make(map[string]*LockedImage)
+	var locals []string
+	for _, use := range uses {
+		source, local, err := mirrorPair(use.Image, localRepo)
+		if err != nil {
+			Logger.Warnf("cannot map %s to the local repository: %v", use.Image, err)
+			source, local = use.Image, ""
+		}
+		entry, ok := entries[source]
+		if !ok {
+			entry = &LockedImage{Source: source, Local: local}
+			entries[source] = entry
+			if local != "" {
+				locals = append(locals, local)
+			}
+		}
+		// The same container may be rendered by several values overlays
+		if !slices.Contains(entry.UsedBy, use.ImageSource) {
+			entry.UsedBy = append(entry.UsedBy, use.ImageSource)
+		}
+	}
+
+	existMap, err := CheckImagesExist(ctx, locals, keychain)
+	if err != nil {
+		Logger.Errorf("failed to check images existence: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 4)
+	for _, entry := range entries {
+		entry.Exists = existMap[entry.Local]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			image := entry.Source
+			if entry.Exists {
+				image = entry.Local
+			}
+			digest, platforms, err := describeImage(ctx, image, keychain)
+			if err != nil {
+				Logger.Warnf("failed to resolve digest for %s: %v", image, err)
+				return
+			}
+			entry.Digest, entry.Platforms = digest, platforms
+		}()
+	}
+	wg.Wait()
+
+	lock := &ImageLock{}
+	for _, entry := range entries {
+		sort.Slice(entry.UsedBy, func(i, j int) bool {
+			a, b := entry.UsedBy[i], entry.UsedBy[j]
+			if a.Template != b.Template {
+				return a.Template < b.Template
+			}
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+			return a.Container < b.Container
+		})
+		lock.Images = append(lock.Images, *entry)
+	}
+	sort.Slice(lock.Images, func(i, j int) bool { return lock.Images[i].Source < lock.Images[j].Source })
+	return lock, nil
+}
+
+// compareImageLocks returns a description of every difference between the locked images
+// and the currently rendered ones. Digests are only compared when both are known. Whether
+// the local copy exists is registry state and not compared, so verify --lock gives the same
+// result wherever it runs.
+func compareImageLocks(locked, current *ImageLock) []string {
+	var diffs []string
+	lockedBySource := make(map[string]LockedImage)
+	for _, img := range locked.Images {
+		lockedBySource[img.Source] = img
+	}
+	seen := make(map[string]bool)
+	for _, img := range current.Images {
+		seen[img.Source] = true
+		old, ok := lockedBySource[img.Source]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("%s is rendered but not in the lock file", img.Source))
+			continue
+		}
+		if old.Local != img.Local {
+			diffs = append(diffs, fmt.Sprintf("%s: local reference changed from %s to %s", img.Source, old.Local, img.Local))
+		}
+		if old.Digest != "" && img.Digest != "" && old.Digest != img.Digest {
+			diffs = append(diffs, fmt.Sprintf("%s: digest changed from %s to %s", img.Source, old.Digest, img.Digest))
+		}
+	}
+	for _, img := range locked.Images {
+		if !seen[img.Source] {
+			diffs = append(diffs, fmt.Sprintf("%s is in the lock file but no longer rendered", img.Source))
+		}
+	}
+	return diffs
+}
+
+// renderImageLock renders the chart and builds its image lock
+func renderImageLock(opts Options) (*ImageLock, error) {
+	rendered, err := renderOverlays(opts)
+	if err != nil {
+		return nil, err
+	}
+	// The lock covers the images of every values overlay
+	var manifest strings.Builder
+	for _, overlay := range rendered {
+		manifest.WriteString(overlay.manifest)
+	}
+	keychain, err := NewRegistryKeychain(opts.RegistryAuth, opts.LocalRepo)
+	if err != nil {
+		Logger.Errorf("failed to set up registry authentication: %v", err)
+		return nil, err
+	}
+	return BuildImageLock(context.Background(), manifest.String(), opts.LocalRepo, keychain)
+}
+
+// WriteImageLock renders the chart and writes images.lock.yaml to the chart directory
+func WriteImageLock(opts Options) error {
+	Files = NewFileStore(opts.DryRun)
+	lock, err := renderImageLock(opts)
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(lock)
+	if err != nil {
+		return fmt.Errorf("failed to marshal image lock: %v", err)
+	}
+	lockPath := filepath.Join(opts.ChartPath, ImageLockFile)
+	if err := Files.WriteFile(lockPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", lockPath, err)
+	}
+	Logger.Infof("Wrote %d image(s) to %s", len(lock.Images), lockPath)
+	return finishRun(opts)
+}
+
+// VerifyImageLock renders the chart and fails if its images no longer match images.lock.yaml
+func VerifyImageLock(opts Options) error {
+	lockPath := filepath.Join(opts.ChartPath, ImageLockFile)
+	data, err := Files.ReadFile(lockPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", lockPath, err)
+	}
+	var locked ImageLock
+	if err := yaml.Unmarshal(data, &locked); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", lockPath, err)
+	}
+	current, err := renderImageLock(opts)
+	if err != nil {
+		return err
+	}
+	diffs := compareImageLocks(&locked, current)
+	for _, d := range diffs {
+		Logger.Errorf("Image lock mismatch: %s", d)
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("rendered images do not match %s", lockPath)
+	}
+	Logger.Infof("Rendered images match %s", lockPath)
+	return nil
+}
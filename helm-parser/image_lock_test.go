@@ -0,0 +1,154 @@
+package helm_parser
+
+import (
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	regname "github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	regremote "github.com/google/go-containerregistry/pkg/v1/remote"
+	"gopkg.in/yaml.v2"
+)
+
+func TestExtractImageSources(t *testing.T) {
+	job := `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: wait
+        image: busybox:1.36
+      containers:
+      - name: migrate
+        image: {{ .Values.image.repository }}:{{ .Values.image.tag }}
+`
+	chartDir := writeTestChart(t, "image:\n  repository: quay.io/team/app\n  tag: \"1.0\"\n", map[string]string{"pod.yaml": phasesTestPod, "job.yaml": job})
+	useFileStore(t, NewFileStore(false))
+
+	rel, err := renderChartFromValues(chartDir, RenderOptions{})
+	if err != nil {
+		t.Fatalf("Failed to render chart: %v", err)
+	}
+	uses, err := ExtractImageSources(rel.Manifest)
+	if err != nil {
+		t.Fatalf("ExtractImageSources failed: %v", err)
+	}
+
+	expected := map[string]ImageUse{
+		"wait":    {Image: "busybox:1.36", ImageSource: ImageSource{Template: "testchart/templates/job.yaml", Kind: "Job", Name: "migrate", Container: "wait"}},
+		"migrate": {Image: "quay.io/team/app:1.0", ImageSource: ImageSource{Template: "testchart/templates/job.yaml", Kind: "Job", Name: "migrate", Container: "migrate"}},
+		"app":     {Image: "quay.io/team/app:1.0", ImageSource: ImageSource{Template: "testchart/templates/pod.yaml", Kind: "Pod", Name: "test", Container: "app"}},
+	}
+	if len(uses) != len(expected) {
+		t.Fatalf("Expected %d image uses, got %+v", len(expected), uses)
+	}
+	for _, use := range uses {
+		if use != expected[use.Container] {
+			t.Errorf("Unexpected image use %+v, expected %+v", use, expected[use.Container])
+		}
+	}
+	t.Log("✓ Images traced to their template and container")
+}
+
+func TestImageLock_WriteAndVerify(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+	localRepo := host + "/ext"
+
+	img, err := random.Image(512, 1)
+	if err != nil {
+		t.Fatalf("Failed to create random image: %v", err)
+	}
+	for _, tag := range []string{"1.0", "2.0"} {
+		ref, _ := regname.ParseReference(localRepo + "/quay.io/team/app:" + tag)
+		if err := regremote.Write(ref, img); err != nil {
+			t.Fatalf("Failed to push image: %v", err)
+		}
+	}
+	digest, _ := img.Digest()
+
+	values := "image:\n  repository: " + localRepo + "/quay.io/team/app\n  tag: \"1.0\"\n"
+	chartDir := writeTestChart(t, values, map[string]string{"pod.yaml": phasesTestPod})
+	useFileStore(t, NewFileStore(false))
+	opts := Options{ChartPath: chartDir, LocalRepo: localRepo}
+
+	if err := WriteImageLock(opts); err != nil {
+		t.Fatalf("WriteImageLock failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(chartDir, ImageLockFile))
+	if err != nil {
+		t.Fatalf("Failed to read lock file: %v", err)
+	}
+	var lock ImageLock
+	if err := yaml.Unmarshal(data, &lock); err != nil {
+		t.Fatalf("Failed to parse lock file: %v", err)
+	}
+	if len(lock.Images) != 1 {
+		t.Fatalf("Expected 1 locked image, got:\n%s", data)
+	}
+	entry := lock.Images[0]
+	if entry.Source != "quay.io/team/app:1.0" || entry.Local != localRepo+"/quay.io/team/app:1.0" || !entry.Exists || entry.Digest != digest.String() {
+		t.Errorf("Unexpected lock entry:\n%s", data)
+	}
+	if len(entry.UsedBy) != 1 || entry.UsedBy[0].Template != "testchart/templates/pod.yaml" || entry.UsedBy[0].Container != "app" {
+		t.Errorf("Unexpected usedBy:\n%s", data)
+	}
+
+	if err := VerifyImageLock(opts); err != nil {
+		t.Errorf("Expected the unchanged chart to match the lock: %v", err)
+	}
+
+	// Bump the tag: the rendered images no longer match the lock
+	if err := os.WriteFile(filepath.Join(chartDir, "values.yaml"), []byte(strings.Replace(values, "1.0", "2.0", 1)), 0644); err != nil {
+		t.Fatalf("Failed to update values.yaml: %v", err)
+	}
+	if err := VerifyImageLock(opts); err == nil {
+		t.Error("Expected a lock mismatch after changing the image tag")
+	}
+	t.Log("✓ Lock file written and verified")
+}
+
+func TestCompareImageLocks(t *testing.T) {
+	locked := &ImageLock{Images: []LockedImage{
+		{Source: "quay.io/team/app:1.0", Local: "registry.example.com/ext/quay.io/team/app:1.0", Digest: "sha256:aaa"},
+		{Source: "quay.io/team/gone:1.0", Local: "registry.example.com/ext/quay.io/team/gone:1.0"},
+	}}
+	current := &ImageLock{Images: []LockedImage{
+		{Source: "quay.io/team/app:1.0", Local: "registry.example.com/ext/quay.io/team/app:1.0", Digest: "sha256:bbb"},
+		{Source: "quay.io/team/new:1.0", Local: "registry.example.com/ext/quay.io/team/new:1.0"},
+	}}
+	diffs := compareImageLocks(locked, current)
+	if len(diffs) != 3 {
+		t.Fatalf("Expected 3 differences, got %v", diffs)
+	}
+	for i, want := range []string{"digest changed", "not in the lock file", "no longer rendered"} {
+		if !strings.Contains(diffs[i], want) {
+			t.Errorf("Expected difference %d to mention %q, got %q", i, want, diffs[i])
+		}
+	}
+
+	// An unresolved digest does not count as a mismatch
+	unresolved := &ImageLock{Images: []LockedImage{locked.Images[0], locked.Images[1]}}
+	unresolved.Images[0].Digest = ""
+	if diffs := compareImageLocks(locked, unresolved); len(diffs) != 0 {
+		t.Errorf("Expected no differences, got %v", diffs)
+	}
+	// Neither does the local copy appearing in the registry
+	mirrored := &ImageLock{Images: []LockedImage{locked.Images[0], locked.Images[1]}}
+	mirrored.Images[1].Exists = true
+	if diffs := compareImageLocks(locked, mirrored); len(diffs) != 0 {
+		t.Errorf("Expected no differences, got %v", diffs)
+	}
+	t.Log("✓ Lock differences reported")
+}
@@ -0,0 +1,284 @@
+package helm_parser
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// provenanceMarkerRe finds the sentinel markers appended to candidate values
+var provenanceMarkerRe = regexp.MustCompile(`zzprov(\d+)zz`)
+
+// ImageProvenance lists the values.yaml paths that feed a rendered image
+type ImageProvenance struct {
+	Image   string
+	Values  string        // Values the chart was rendered with, e.g. values.yaml + env/prod.yaml
+	Paths   []string      // Values paths such as global.hub, pilot.image, pilot.tag
+	Sources []ImageSource // Containers that use the image
+}
+
+// valueLeaf is a scalar in values.yaml together with its path
+type valueLeaf struct {
+	path  []string
+	value string
+}
+
+// collectValueLeaves returns every non-empty string or numeric scalar in the values tree.
+// List items are addressed by their index.
+func collectValueLeaves(node interface{}, path []string, leaves *[]valueLeaf) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for k, v := range n {
+			collectValueLeaves(v, append(append([]string{}, path...), k), leaves)
+		}
+	case []interface{}:
+		for i, v := range n {
+			collectValueLeaves(v, append(append([]string{}, path...), strconv.Itoa(i)), leaves)
+		}
+	case string:
+		if n != "" {
+			*leaves = append(*leaves, valueLeaf{path: path, value: n})
+		}
+	case int, int64, float64:
+		*leaves = append(*leaves, valueLeaf{path: path, value: fmt.Sprint(n)})
+	}
+}
+
+// copyValues deep copies a converted values tree
+func copyValues(node interface{}) interface{} {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(n))
+		for k, v := range n {
+			m[k] = copyValues(v)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(n))
+		for i, v := range n {
+			l[i] = copyValues(v)
+		}
+		return l
+	}
+	return node
+}
+
+// setValueAtPath replaces the scalar at path in a converted values tree
+func setValueAtPath(node interface{}, path []string, value string) {
+	for i, key := range path {
+		last := i == len(path)-1
+		switch n := node.(type) {
+		case map[string]interface{}:
+			if last {
+				n[key] = value
+				return
+			}
+			node = n[key]
+		case []interface{}:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx >= len(n) {
+				return
+			}
+			if last {
+				n[idx] = value
+				return
+			}
+			node = n[idx]
+		default:
+			return
+		}
+	}
+}
+
+// sentinelValue marks a candidate value so it can be recognised in the rendered images.
+// The original value is kept as a prefix so templates that slice or test it still work.
+func sentinelValue(leaf valueLeaf, index int) string {
+	return fmt.Sprintf("%szzprov%dzz", leaf.value, index)
+}
+
+// useKey identifies a container across renders
+func useKey(use ImageUse) string {
+	return strings.Join([]string{use.Template, use.Kind, use.Name, use.Container}, "|")
+}
+
+// renderImageUses renders the chart with the given values and returns the image uses by container
+func renderImageUses(chartPath string, values map[string]interface{}, render RenderOptions) (map[string]ImageUse, error) {
+	rel, err := renderChartLocal(chartPath, values, render)
+	if err != nil {
+		return nil, err
+	}
+	uses, err := ExtractImageSources(rel.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]ImageUse, len(uses))
+	for _, use := range uses {
+		byKey[useKey(use)] = use
+	}
+	return byKey, nil
+}
+
+// markedCandidates returns the candidate indexes whose markers appear in image
+func markedCandidates(image string) []int {
+	var idx []int
+	for _, m := range provenanceMarkerRe.FindAllStringSubmatch(image, -1) {
+		if i, err := strconv.Atoi(m[1]); err == nil {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// ExplainImages traces each rendered image back to the values paths that produce it, once
+// for every values overlay the chart is checked with.
+// Every scalar whose value appears in a rendered image is a candidate; the chart is rendered
+// with a sentinel substituted for all candidates at once and the sentinels found in each image
+// name its values paths. If that render fails, or changes which containers are rendered,
+// each candidate is rendered on its own instead.
+func ExplainImages(chartPath string, render RenderOptions) ([]ImageProvenance, error) {
+	content, err := Files.ReadFile(filepath.Join(chartPath, "values.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read values.yaml: %v", err)
+	}
+	var valuesMapI map[interface{}]interface{}
+	if err := yaml.Unmarshal(content, &valuesMapI); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal values: %v", err)
+	}
+	chartValues, _ := convertMapI2MapS(valuesMapI).(map[string]interface{})
+	if chartValues == nil {
+		chartValues = map[string]interface{}{}
+	}
+
+	var result []ImageProvenance
+	for _, overlay := range render.overlays() {
+		provenance, err := explainOverlay(chartPath, chartValues, overlay)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, provenance...)
+	}
+	return result, nil
+}
+
+// explainOverlay traces the images rendered with one values overlay. The overlay is merged
+// into the chart values first, so the values files and --set values are candidates too.
+func explainOverlay(chartPath string, chartValues map[string]interface{}, render RenderOptions) ([]ImageProvenance, error) {
+	overlay, err := render.overlayValues()
+	if err != nil {
+		return nil, err
+	}
+	values := chartutil.CoalesceTables(overlay, copyValues(chartValues).(map[string]interface{}))
+	name := render.valuesName()
+	// The overlay is part of the values now, so the sentinels are not overwritten by it
+	render.ValueFiles, render.Set, render.SetString = nil, nil, nil
+
+	baseline, err := renderImageUses(chartPath, values, render)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render chart: %v", err)
+	}
+
+	// Candidate values are the ones that appear in a rendered image
+	var leaves, candidates []valueLeaf
+	collectValueLeaves(values, nil, &leaves)
+	for _, leaf := range leaves {
+		for _, use := range baseline {
+			if strings.Contains(use.Image, leaf.value) {
+				candidates = append(candidates, leaf)
+				break
+			}
+		}
+	}
+
+	paths := make(map[string]map[string]bool) // use key -> values paths
+	record := func(key string, idx int) {
+		if paths[key] == nil {
+			paths[key] = make(map[string]bool)
+		}
+		paths[key][strings.Join(candidates[idx].path, ".")] = true
+	}
+
+	allAtOnce := copyValues(values).(map[string]interface{})
+	for i, leaf := range candidates {
+		setValueAtPath(allAtOnce, leaf.path, sentinelValue(leaf, i))
+	}
+	marked, err := renderImageUses(chartPath, allAtOnce, render)
+	if err == nil && len(marked) == len(baseline) {
+		for key, use := range marked {
+			for _, idx := range markedCandidates(use.Image) {
+				if idx < len(candidates) {
+					record(key, idx)
+				}
+			}
+		}
+	} else {
+		Logger.Infof("Rendering with all sentinel values failed, tracing %d values one at a time", len(candidates))
+		for i, leaf := range candidates {
+			single := copyValues(values).(map[string]interface{})
+			setValueAtPath(single, leaf.path, sentinelValue(leaf, i))
+			marked, err := renderImageUses(chartPath, single, render)
+			if err != nil {
+				Logger.Warnf("Could not render with a sentinel for %s: %v", strings.Join(leaf.path, "."), err)
+				continue
+			}
+			for key, use := range marked {
+				if len(markedCandidates(use.Image)) > 0 {
+					record(key, i)
+				}
+			}
+		}
+	}
+
+	// Group the containers by image
+	byImage := make(map[string]*ImageProvenance)
+	for key, use := range baseline {
+		p, ok := byImage[use.Image]
+		if !ok {
+			p = &ImageProvenance{Image: use.Image, Values: name}
+			byImage[use.Image] = p
+		}
+		p.Sources = append(p.Sources, use.ImageSource)
+		for path := range paths[key] {
+			if !slices.Contains(p.Paths, path) {
+				p.Paths = append(p.Paths, path)
+			}
+		}
+	}
+	var result []ImageProvenance
+	for _, p := range byImage {
+		sort.Strings(p.Paths)
+		sort.Slice(p.Sources, func(i, j int) bool {
+			return useKey(ImageUse{ImageSource: p.Sources[i]}) < useKey(ImageUse{ImageSource: p.Sources[j]})
+		})
+		result = append(result, *p)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Image < result[j].Image })
+	return result, nil
+}
+
+// WriteImageProvenance writes each image followed by the values paths and containers that
+// produce it. With several values overlays the images of each follow a comment naming its values.
+func WriteImageProvenance(w io.Writer, provenance []ImageProvenance) {
+	overlays := len(provenance) > 0 && provenance[0].Values != provenance[len(provenance)-1].Values
+	for i, p := range provenance {
+		if overlays && (i == 0 || p.Values != provenance[i-1].Values) {
+			fmt.Fprintf(w, "# Values: %s\n", p.Values)
+		}
+		fmt.Fprintln(w, p.Image)
+		if len(p.Paths) == 0 {
+			fmt.Fprintln(w, "  values: none (hard-coded in a template or helper)")
+		} else {
+			fmt.Fprintf(w, "  values: %s\n", strings.Join(p.Paths, ", "))
+		}
+		for _, s := range p.Sources {
+			fmt.Fprintf(w, "  used by: %s %s/%s container %s\n", s.Template, s.Kind, s.Name, s.Container)
+		}
+	}
+}
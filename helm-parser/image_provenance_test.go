@@ -0,0 +1,145 @@
+package helm_parser
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const provenanceTestDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: istiod
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: init
+        image: busybox:1.36
+      containers:
+      - name: discovery
+        image: "{{ .Values.global.hub }}/{{ .Values.pilot.image }}:{{ .Values.global.tag }}"
+      - name: metrics
+        image: "{{ .Values.metrics.image.repository }}:{{ .Values.metrics.image.tag }}"
+`
+
+const provenanceTestValues = `global:
+  hub: docker.io/istio
+  tag: 1.26.2
+pilot:
+  image: pilot
+  replicas: 1
+metrics:
+  image:
+    repository: quay.io/prometheus/node-exporter
+    tag: v1.8.0
+`
+
+func TestExplainImages(t *testing.T) {
+	chartDir := writeTestChart(t, provenanceTestValues, map[string]string{"deployment.yaml": provenanceTestDeployment})
+	useFileStore(t, NewFileStore(false))
+
+	provenance, err := ExplainImages(chartDir, RenderOptions{})
+	if err != nil {
+		t.Fatalf("ExplainImages failed: %v", err)
+	}
+
+	expected := map[string][]string{
+		"busybox:1.36":                            nil,
+		"docker.io/istio/pilot:1.26.2":            {"global.hub", "global.tag", "pilot.image"},
+		"quay.io/prometheus/node-exporter:v1.8.0": {"metrics.image.repository", "metrics.image.tag"},
+	}
+	if len(provenance) != len(expected) {
+		t.Fatalf("Expected %d images, got %+v", len(expected), provenance)
+	}
+	for _, p := range provenance {
+		if !reflect.DeepEqual(p.Paths, expected[p.Image]) {
+			t.Errorf("%s: expected paths %v, got %v", p.Image, expected[p.Image], p.Paths)
+		}
+		if len(p.Sources) != 1 || p.Sources[0].Name != "istiod" {
+			t.Errorf("%s: unexpected sources %+v", p.Image, p.Sources)
+		}
+	}
+
+	var out bytes.Buffer
+	WriteImageProvenance(&out, provenance)
+	if !strings.Contains(out.String(), "values: global.hub, global.tag, pilot.image") || !strings.Contains(out.String(), "values: none") {
+		t.Errorf("Unexpected output:\n%s", out.String())
+	}
+	t.Log("✓ Images traced to their values paths")
+}
+
+func TestExplainImages_PerKeyFallback(t *testing.T) {
+	// The template rejects tags that are not plain versions, so the all-at-once render fails
+	deployment := `{{- if not (regexMatch "^[0-9.]+$" (toString .Values.global.tag)) }}{{ fail "invalid tag" }}{{ end }}
+` + provenanceTestDeployment
+	chartDir := writeTestChart(t, provenanceTestValues, map[string]string{"deployment.yaml": deployment})
+	useFileStore(t, NewFileStore(false))
+
+	provenance, err := ExplainImages(chartDir, RenderOptions{})
+	if err != nil {
+		t.Fatalf("ExplainImages failed: %v", err)
+	}
+	for _, p := range provenance {
+		if p.Image != "docker.io/istio/pilot:1.26.2" {
+			continue
+		}
+		// global.tag cannot be traced because any sentinel for it fails the render
+		if !reflect.DeepEqual(p.Paths, []string{"global.hub", "pilot.image"}) {
+			t.Errorf("Expected paths traced one at a time, got %v", p.Paths)
+		}
+		t.Log("✓ Values traced one at a time after the combined render failed")
+		return
+	}
+	t.Fatalf("Pilot image not found in %+v", provenance)
+}
+
+func TestExplainImages_ValuesOverlays(t *testing.T) {
+	chartDir := writeTestChart(t, provenanceTestValues, map[string]string{"deployment.yaml": provenanceTestDeployment})
+	useFileStore(t, NewFileStore(false))
+	envDir := t.TempDir()
+	staging := filepath.Join(envDir, "staging.yaml")
+	prod := filepath.Join(envDir, "prod.yaml")
+	if err := os.WriteFile(staging, []byte("global:\n  tag: 1.27.0\n"), 0644); err != nil {
+		t.Fatalf("Failed to write values file: %v", err)
+	}
+	if err := os.WriteFile(prod, []byte("metrics:\n  image:\n    tag: v1.9.0\n"), 0644); err != nil {
+		t.Fatalf("Failed to write values file: %v", err)
+	}
+
+	render := RenderOptions{ValueFiles: []string{staging, prod}, Set: []string{"pilot.image=pilot-distroless"}, Matrix: true}
+	provenance, err := ExplainImages(chartDir, render)
+	if err != nil {
+		t.Fatalf("ExplainImages failed: %v", err)
+	}
+	stagingName, prodName := "values.yaml + "+staging, "values.yaml + "+prod
+	expected := map[string][]string{
+		stagingName + "|docker.io/istio/pilot-distroless:1.27.0": {"global.hub", "global.tag", "pilot.image"},
+		stagingName + "|quay.io/prometheus/node-exporter:v1.8.0": {"metrics.image.repository", "metrics.image.tag"},
+		prodName + "|docker.io/istio/pilot-distroless:1.26.2":    {"global.hub", "global.tag", "pilot.image"},
+		prodName + "|quay.io/prometheus/node-exporter:v1.9.0":    {"metrics.image.repository", "metrics.image.tag"},
+		stagingName + "|busybox:1.36":                            nil,
+		prodName + "|busybox:1.36":                               nil,
+	}
+	if len(provenance) != len(expected) {
+		t.Fatalf("Expected %d images, got %+v", len(expected), provenance)
+	}
+	for _, p := range provenance {
+		want, ok := expected[p.Values+"|"+p.Image]
+		if !ok || !reflect.DeepEqual(p.Paths, want) {
+			t.Errorf("%s with %s: expected paths %v, got %v", p.Image, p.Values, want, p.Paths)
+		}
+	}
+
+	var out bytes.Buffer
+	WriteImageProvenance(&out, provenance)
+	for _, want := range []string{"# Values: " + stagingName + "\n", "# Values: " + prodName + "\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in the output:\n%s", want, out.String())
+		}
+	}
+	t.Log("✓ Images traced for every values overlay")
+}
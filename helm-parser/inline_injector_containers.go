@@ -1,9 +1,8 @@
 package helm_parser
 
 import (
+	"slices"
 	"strings"
-
-	"gopkg.in/yaml.v2"
 )
 
 // injectInlineContainerSpec injects container-level blocks into Kubernetes resource templates
@@ -15,303 +14,79 @@ func injectInlineContainerSpec(content string, customYaml string, systemCritical
 	return injectInlineContainerSpecWithBlocks(content, blocks)
 }
 
-func injectInlineContainerSpecWithBlocks(content string, blocks InjectorBlocks) (string, error) {
-	lines := strings.Split(content, "\n")
-	var result []string
-	i := 0
-
-	for i < len(lines) {
-		line := lines[i]
-		trimmed := strings.TrimSpace(line)
-
-		// Check if this is a container name definition
-		isContainer := strings.HasPrefix(trimmed, "- name:") && isUnderContainersSection(lines, i)
-
-		if isContainer {
-			// Add the container name line
-			result = append(result, line)
-
-			// Find the indentation level of the container
-			indent := GetIndentation(line)
-
-			// Check which blocks from allContainers are missing
-			containerBlocks := blocks["allContainers"]
-			missingBlocks := findMissingBlocks(lines, i, indent, containerBlocks)
-
-			if len(missingBlocks) > 0 {
-				// Check if we have envFrom blocks to inject and if envFrom already exists
-				envFromBlocks := getBlocksByKey(missingBlocks, "envFrom")
-				existingEnvFromIdx := findExistingKey(lines, i, indent, "envFrom")
-
-				if len(envFromBlocks) > 0 && existingEnvFromIdx != -1 {
-					// envFrom exists, append our items to it
-					insertionPoint := findEnvFromEndPoint(lines, existingEnvFromIdx, indent)
-
-					// Copy lines up to the insertion point
-					for j := i + 1; j < insertionPoint; j++ {
-						result = append(result, lines[j])
-					}
-
-					// Append our envFrom items
-					spaces := strings.Repeat(" ", indent+2)
-					for _, block := range envFromBlocks {
-						blockLines := strings.Split(strings.TrimSpace(block), "\n")
-						// Skip the "envFrom:" line and add the rest
-						for idx := 1; idx < len(blockLines); idx++ {
-							result = append(result, spaces+blockLines[idx])
-						}
-					}
-
-					// Remove envFrom blocks from missing list
-					missingBlocks = removeBlocksByKey(missingBlocks, "envFrom")
-
-					// Now handle remaining blocks
-					if len(missingBlocks) > 0 {
-						injectionPoint := findBlockInjectionPoint(lines, insertionPoint-1, indent)
-						// Copy from insertion point to injection point
-						for j := insertionPoint; j < injectionPoint; j++ {
-							result = append(result, lines[j])
-						}
-						// Inject remaining blocks
-						result = append(result, injectMissingBlocks(missingBlocks, indent+2)...)
-						i = injectionPoint
-					} else {
-						i = insertionPoint
-					}
-				} else {
-					// No existing envFrom or no envFrom blocks to inject
-					injectionPoint := findBlockInjectionPoint(lines, i, indent)
-
-					// Add lines from after container name up to injection point
-					for j := i + 1; j < injectionPoint; j++ {
-						result = append(result, lines[j])
-					}
-
-					// Inject the missing blocks
-					result = append(result, injectMissingBlocks(missingBlocks, indent+2)...)
-
-					// Continue from injection point
-					i = injectionPoint
-				}
-			} else {
-				// All blocks already exist, just copy lines until next container
-				j := i + 1
-				for j < len(lines) {
-					nextLine := lines[j]
-					nextIndent := getIndentation(nextLine)
-					nextTrimmed := strings.TrimSpace(nextLine)
+// ephemeralContainerUnsupportedKeys are container keys Kubernetes rejects on ephemeral containers
+var ephemeralContainerUnsupportedKeys = []string{"resources", "ports", "livenessProbe", "readinessProbe", "startupProbe", "lifecycle"}
 
-					// Stop when we hit another container or exit this container
-					if nextIndent <= indent && nextTrimmed != "" && !strings.HasPrefix(nextTrimmed, "#") {
-						break
-					}
-					result = append(result, nextLine)
-					j++
-				}
-				i = j
-			}
-		} else {
-			// Not a container definition, just add the line
-			result = append(result, line)
-			i++
-		}
+// targetContainerLists returns the pod spec lists holding the containers of a target:
+// init containers, or containers and ephemeral containers
+func targetContainerLists(target string) []string {
+	if target == TargetInitContainers {
+		return []string{"initContainers"}
 	}
+	return []string{"containers", "ephemeralContainers"}
+}
 
-	return strings.Join(result, "\n"), nil
+// injectInlineContainerSpecWithBlocks injects blocks["allContainers"] into every container
+// and blocks["allInitContainers"] into every init container.
+func injectInlineContainerSpecWithBlocks(content string, blocks InjectorBlocks) (string, error) {
+	kind := getK8sResourceKind(content, nil)
+	content, err := injectInlineContainerBlocks(content, kind, blocks["allContainers"], nil, targetContainerLists(TargetContainers))
+	if err != nil {
+		return "", err
+	}
+	return injectInlineContainerBlocks(content, kind, blocks["allInitContainers"], nil, targetContainerLists(TargetInitContainers))
 }
 
-// isUnderContainersSection checks if a line index is under a containers: section
-func isUnderContainersSection(lines []string, index int) bool {
-	// Look backwards to find the immediate parent section
-	// We need to find what section this "- name:" belongs to
-	lineIndent := getIndentation(lines[index])
+// injectInlineContainerBlocks injects blocks into the containers of the pod spec lists (e.g.
+// initContainers) of a resource of kind whose name matches one of names (globs), or into
+// every container of the lists when names is empty. Each key is combined with what the
+// container already has following its merge strategy.
+func injectInlineContainerBlocks(content, kind string, containerBlocks []InjectorBlock, names []string, lists []string) (string, error) {
+	lines := strings.Split(content, "\n")
+	keys := blockKeys(containerBlocks)
 
-	// First, check sibling lines at the same indent level
-	for i := index - 1; i >= 0; i-- {
+	// Walk the containers bottom up so edits do not shift the ones still to be processed
+	for i := len(lines) - 1; i >= 0; i-- {
 		trimmed := strings.TrimSpace(lines[i])
-		indent := getIndentation(lines[i])
 
-		// Skip empty lines and comments
-		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		// Check if this is a container name definition in one of the lists
+		if !strings.HasPrefix(trimmed, "- name:") {
 			continue
 		}
-
-		// Check sibling lines (same indentation)
-		if indent == lineIndent {
-			if strings.HasPrefix(trimmed, "env:") ||
-				strings.HasPrefix(trimmed, "envFrom:") ||
-				strings.HasPrefix(trimmed, "args:") ||
-				strings.HasPrefix(trimmed, "volumeMounts:") ||
-				strings.HasPrefix(trimmed, "ports:") {
-				return false
-			}
+		list := containerListKey(lines, i, kind)
+		if !slices.Contains(lists, list) || !matchesAny(names, containerName(lines[i])) {
+			continue
 		}
 
-		// If we find a line at lower indentation, it's a parent section
-		if indent < lineIndent {
-			// Check if the parent is containers:
-			if strings.HasPrefix(trimmed, "containers:") {
-				return true
-			}
-			// If parent is env:, envFrom:, etc., not a container
-			if strings.HasPrefix(trimmed, "env:") ||
-				strings.HasPrefix(trimmed, "envFrom:") ||
-				strings.HasPrefix(trimmed, "args:") ||
-				strings.HasPrefix(trimmed, "volumeMounts:") ||
-				strings.HasPrefix(trimmed, "ports:") {
-				return false
-			}
-			// If parent is initContainers or volumes, not in containers section
-			if strings.HasPrefix(trimmed, "initContainers:") ||
-				strings.HasPrefix(trimmed, "volumes:") {
-				return false
+		indent := GetIndentation(lines[i])
+		for _, key := range keys {
+			if list == "ephemeralContainers" && slices.Contains(ephemeralContainerUnsupportedKeys, key) {
+				continue
 			}
+			lines = injectInlineKey(lines, i, indent, key, getContainerBlocksByKey(containerBlocks, key), findBlockInjectionPoint)
 		}
 	}
 
-	return false
-}
-
-// getIndentation is a wrapper for GetIndentation for backwards compatibility
-func getIndentation(line string) int {
-	return GetIndentation(line)
+	return strings.Join(lines, "\n"), nil
 }
 
-// findMissingBlocks checks which blocks from the provided list are not already present in the container
-func findMissingBlocks(lines []string, containerNameIndex, containerIndent int, blockStrings []string) []string {
-	var missing []string
-
-	for _, block := range blockStrings {
-		if !containerHasBlock(lines, containerNameIndex, containerIndent, block) {
-			missing = append(missing, block)
-		}
-	}
-
-	return missing
-}
-
-// containerHasBlock checks if the container already has the specified block
-func containerHasBlock(lines []string, containerNameIndex, containerIndent int, block string) bool {
-	// Parse the block to get its structure
-	var blockData map[string]interface{}
-	if err := yaml.Unmarshal([]byte(block), &blockData); err != nil {
-		return false
+// containerListKey returns the pod spec list (containers, initContainers or ephemeralContainers)
+// holding the container whose "- name:" is on a line, or "" if the line is not a container
+func containerListKey(lines []string, index int, resourceKind string) string {
+	kind, ok := lookupWorkloadKind(resourceKind)
+	if !ok {
+		return ""
 	}
-
-	// Get the top-level key of the block (envFrom, resources, etc.)
-	var topKey string
-	for key := range blockData {
-		topKey = key
-		break
+	path := LinePath(lines, index)
+	if len(path) < 3 || path[len(path)-2] != ListItemKey {
+		return ""
 	}
-
-	if topKey == "" {
-		return false
-	}
-
-	// For envFrom, check if the specific configMap exists
-	if topKey == "envFrom" {
-		return containerHasEnvFromBlock(lines, containerNameIndex, containerIndent, blockData)
-	}
-
-	// For resources, check if resources block exists (we don't want to override existing resources)
-	if topKey == "resources" {
-		return containerHasResourcesBlock(lines, containerNameIndex, containerIndent)
-	}
-
-	// For other block types, check if the key exists
-	return containerHasKey(lines, containerNameIndex, containerIndent, topKey)
+	return kind.containerList(path[:len(path)-2])
 }
 
-// containerHasEnvFromBlock checks if the container has the specific envFrom configMap
-func containerHasEnvFromBlock(lines []string, containerNameIndex, containerIndent int, blockData map[string]interface{}) bool {
-	// Extract configMap name from the block
-	configMapName := ""
-	if envFromList, ok := blockData["envFrom"].([]interface{}); ok {
-		for _, item := range envFromList {
-			if itemMap, ok := item.(map[interface{}]interface{}); ok {
-				if configMapRef, ok := itemMap["configMapRef"].(map[interface{}]interface{}); ok {
-					if name, ok := configMapRef["name"].(string); ok {
-						configMapName = name
-						break
-					}
-				}
-			}
-		}
-	}
-
-	if configMapName == "" {
-		return false
-	}
-
-	// Check if this configMap exists in the container's envFrom
-	for i := containerNameIndex + 1; i < len(lines); i++ {
-		line := lines[i]
-		trimmed := strings.TrimSpace(line)
-		indent := getIndentation(line)
-
-		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
-			continue
-		}
-
-		if indent <= containerIndent {
-			break
-		}
-
-		if indent == containerIndent+2 && strings.HasPrefix(trimmed, "envFrom:") {
-			// Found envFrom, check for the configMap
-			for j := i + 1; j < len(lines); j++ {
-				nextLine := lines[j]
-				nextTrimmed := strings.TrimSpace(nextLine)
-				nextIndent := getIndentation(nextLine)
-
-				if nextTrimmed == "" || strings.HasPrefix(nextTrimmed, "#") {
-					continue
-				}
-
-				if nextIndent < containerIndent+2 {
-					break
-				}
-
-				if nextTrimmed == "- configMapRef:" && j+1 < len(lines) {
-					nameLine := strings.TrimSpace(lines[j+1])
-					if nameLine == "name: "+configMapName {
-						return true
-					}
-				}
-			}
-			break
-		}
-	}
-
-	return false
-}
-
-// containerHasResourcesBlock checks if the container already has a resources block
-func containerHasResourcesBlock(lines []string, containerNameIndex, containerIndent int) bool {
-	return containerHasKey(lines, containerNameIndex, containerIndent, "resources")
-}
-
-// containerHasKey checks if the container has a specific top-level key
-func containerHasKey(lines []string, containerNameIndex, containerIndent int, key string) bool {
-	for i := containerNameIndex + 1; i < len(lines); i++ {
-		line := lines[i]
-		trimmed := strings.TrimSpace(line)
-		indent := getIndentation(line)
-
-		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
-			continue
-		}
-
-		if indent <= containerIndent {
-			break
-		}
-
-		if indent == containerIndent+2 && strings.HasPrefix(trimmed, key+":") {
-			return true
-		}
-	}
-	return false
+// getIndentation is a wrapper for GetIndentation for backwards compatibility
+func getIndentation(line string) int {
+	return GetIndentation(line)
 }
 
 // findBlockInjectionPoint finds where to inject blocks in the container
@@ -342,36 +117,6 @@ func findBlockInjectionPoint(lines []string, containerNameIndex, containerIndent
 	return len(lines)
 }
 
-// getBlocksByKey returns blocks that have the specified top-level key
-func getBlocksByKey(blocks []string, key string) []string {
-	var result []string
-	for _, block := range blocks {
-		var blockData map[string]interface{}
-		if err := yaml.Unmarshal([]byte(block), &blockData); err != nil {
-			continue
-		}
-		if _, ok := blockData[key]; ok {
-			result = append(result, block)
-		}
-	}
-	return result
-}
-
-// removeBlocksByKey returns blocks that don't have the specified top-level key
-func removeBlocksByKey(blocks []string, key string) []string {
-	var result []string
-	for _, block := range blocks {
-		var blockData map[string]interface{}
-		if err := yaml.Unmarshal([]byte(block), &blockData); err != nil {
-			continue
-		}
-		if _, ok := blockData[key]; !ok {
-			result = append(result, block)
-		}
-	}
-	return result
-}
-
 // findExistingKey finds the line index of a top-level key in the container, or -1 if not found
 func findExistingKey(lines []string, containerNameIndex, containerIndent int, key string) int {
 	for i := containerNameIndex + 1; i < len(lines); i++ {
@@ -379,7 +124,8 @@ func findExistingKey(lines []string, containerNameIndex, containerIndent int, ke
 		trimmed := strings.TrimSpace(line)
 		indent := getIndentation(line)
 
-		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		// Template actions may sit at any indentation, e.g. {{- if }} at the start of the line
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "{{") {
 			continue
 		}
 
@@ -393,70 +139,3 @@ func findExistingKey(lines []string, containerNameIndex, containerIndent int, ke
 	}
 	return -1
 }
-
-// findEnvFromEndPoint finds where the envFrom block ends (where to append new items)
-func findEnvFromEndPoint(lines []string, envFromLineIdx, containerIndent int) int {
-	for i := envFromLineIdx + 1; i < len(lines); i++ {
-		line := lines[i]
-		trimmed := strings.TrimSpace(line)
-		indent := getIndentation(line)
-
-		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
-			continue
-		}
-
-		// If we hit a property at the container level or lower, insert before it
-		if indent <= containerIndent+2 {
-			return i
-		}
-	}
-	return len(lines)
-}
-
-// injectMissingBlocks injects the missing blocks with proper indentation and grouping
-func injectMissingBlocks(missingBlocks []string, indent int) []string {
-	spaces := strings.Repeat(" ", indent)
-	var result []string
-
-	// Group blocks by their top-level key
-	grouped := make(map[string][]string)
-	for _, block := range missingBlocks {
-		var blockData map[string]interface{}
-		if err := yaml.Unmarshal([]byte(block), &blockData); err != nil {
-			continue
-		}
-
-		for key := range blockData {
-			grouped[key] = append(grouped[key], block)
-			break
-		}
-	}
-
-	// Inject envFrom blocks first (merged under single header)
-	if envFromBlocks, ok := grouped["envFrom"]; ok && len(envFromBlocks) > 0 {
-		result = append(result, spaces+"envFrom:")
-		for _, block := range envFromBlocks {
-			blockLines := strings.Split(strings.TrimSpace(block), "\n")
-			// Skip the "envFrom:" line and add the rest
-			for idx := 1; idx < len(blockLines); idx++ {
-				result = append(result, spaces+blockLines[idx])
-			}
-		}
-	}
-
-	// Inject other blocks (resources, etc.)
-	for key, blocks := range grouped {
-		if key == "envFrom" {
-			continue // Already handled
-		}
-
-		for _, block := range blocks {
-			blockLines := strings.Split(strings.TrimSpace(block), "\n")
-			for _, line := range blockLines {
-				result = append(result, spaces+line)
-			}
-		}
-	}
-
-	return result
-}
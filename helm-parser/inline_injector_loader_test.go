@@ -32,7 +32,7 @@ func TestLoadInjectorBlocks(t *testing.T) {
 	// Verify allContainers contains envFrom configMapRef
 	found := false
 	for _, block := range blocks["allContainers"] {
-		if strings.Contains(block, "envFrom:") && strings.Contains(block, "kubernetes-services-endpoint") {
+		if strings.Contains(block.Block, "envFrom:") && strings.Contains(block.Block, "kubernetes-services-endpoint") {
 			found = true
 			break
 		}
@@ -49,7 +49,7 @@ func TestLoadInjectorBlocks(t *testing.T) {
 	// Verify controlPlanePods contains affinity
 	found = false
 	for _, block := range blocks["controlPlanePods"] {
-		if strings.Contains(block, "affinity:") && strings.Contains(block, "node-role.kubernetes.io/control-plane") {
+		if strings.Contains(block.Block, "affinity:") && strings.Contains(block.Block, "node-role.kubernetes.io/control-plane") {
 			found = true
 			break
 		}
@@ -76,7 +76,7 @@ func TestLoadInjectorBlocks_Structure(t *testing.T) {
 	for category, blockList := range blocks {
 		t.Logf("\nCategory: %s", category)
 		for i, block := range blockList {
-			t.Logf("  Block %d:\n%s", i+1, block)
+			t.Logf("  Block %d (strategy %q):\n%s", i+1, block.Strategy, block.Block)
 		}
 	}
 }
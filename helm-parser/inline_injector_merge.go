@@ -0,0 +1,238 @@
+package helm_parser
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// injectInlineKey combines the blocks of one key with the section (a pod spec or a container)
+// whose first line is at parentIndex. A missing key is added at injectionPoint, outside any
+// Helm control structure of the section.
+// A plain YAML value is merged following the strategy and re-encoded in place, a null removes the key. Items of a
+// templated list (e.g. toYaml inside {{- with }}) are appended before the end of the list;
+// other templated values cannot be merged and are left alone. A key the chart only sets
+// inside {{- if }} or {{- with }} is also set in the {{- else }} branch, which is added if needed.
+func injectInlineKey(lines []string, parentIndex, parentIndent int, key string, keyBlocks []InjectorBlock,
+	injectionPoint func([]string, int, int) int) []string {
+	value := blockValue(keyBlocks, key)
+	if value == nil {
+		return lines
+	}
+	strategy := keyStrategy(keyBlocks, value)
+	keyIndent := parentIndent + 2
+
+	existingIdx := findExistingKey(lines, parentIndex, parentIndent, key)
+	// A null removes the key, e.g. the priorityClassName of --system-critical default
+	if isNullNode(value) {
+		if existingIdx == -1 || strategy == StrategySkipIfPresent {
+			return lines
+		}
+		return slices.Delete(lines, existingIdx, inlineKeyEnd(lines, existingIdx, keyIndent))
+	}
+	if existingIdx == -1 {
+		at := injectionPoint(lines, parentIndex, parentIndent)
+		// Before the blank lines ending the section, e.g. the end of the file
+		for at > parentIndex+1 && strings.TrimSpace(lines[at-1]) == "" {
+			at--
+		}
+		// Keep the key out of the control structures of the section
+		for b, ok := enclosingBlock(helmBlocks(lines), at); ok && b.start > parentIndex; b, ok = enclosingBlock(helmBlocks(lines), at) {
+			at = b.start
+		}
+		return insertInlineKey(lines, at, key, value, keyIndent)
+	}
+	if b, ok := enclosingBlock(helmBlocks(lines), existingIdx); ok && b.start > parentIndex {
+		return injectConditionalKey(lines, b, existingIdx, keyIndent, key, value, strategy)
+	}
+	return mergeInlineKey(lines, existingIdx, keyIndent, key, value, strategy)
+}
+
+// insertInlineKey inserts a key with its value at a line
+func insertInlineKey(lines []string, at int, key string, value *yamlv3.Node, keyIndent int) []string {
+	keyNode := &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: key}
+	encoded, err := encodePair(keyNode, value, strings.Repeat(" ", keyIndent), keyIndent)
+	if err != nil {
+		Logger.Warnf("Could not encode %s: %v", key, err)
+		return lines
+	}
+	return slices.Insert(lines, at, encoded...)
+}
+
+// injectConditionalKey handles a key the chart sets inside a Helm control structure: the
+// chart's value is combined in place, and the other branch of an {{- if }} or {{- with }}
+// gets the injected value unless it sets the key too
+func injectConditionalKey(lines []string, b helmBlock, existingIdx, keyIndent int, key string, value *yamlv3.Node, strategy MergeStrategy) []string {
+	if b.action != "if" && b.action != "with" {
+		Logger.Warnf("Skipping %s: it is set inside a Helm %s", key, b.action)
+		return lines
+	}
+	before := len(lines)
+	lines = mergeInlineKey(lines, existingIdx, keyIndent, key, copyNode(value), strategy)
+	b.end += len(lines) - before
+	if b.elseLine != -1 && b.elseLine > existingIdx {
+		b.elseLine += len(lines) - before
+	}
+
+	switch {
+	case b.elseLine == -1:
+		// Add the else branch
+		lines = slices.Insert(lines, b.end, strings.Repeat(" ", getIndentation(lines[b.start]))+"{{- else }}")
+		return insertInlineKey(lines, b.end+1, key, value, keyIndent)
+	case existingIdx < b.elseLine:
+		if findKeyBetween(lines, b.elseLine, b.end, keyIndent, key) {
+			return lines
+		}
+		return insertInlineKey(lines, b.end, key, value, keyIndent)
+	default:
+		// The chart sets the key in the else branch only
+		return insertInlineKey(lines, b.elseLine, key, value, keyIndent)
+	}
+}
+
+// findKeyBetween reports whether a key is set at keyIndent between two lines
+func findKeyBetween(lines []string, from, to, keyIndent int, key string) bool {
+	for i := from + 1; i < to; i++ {
+		if getIndentation(lines[i]) == keyIndent && strings.HasPrefix(strings.TrimSpace(lines[i]), key+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeInlineKey combines the value of the existing key at existingIdx with the injected one
+func mergeInlineKey(lines []string, existingIdx, keyIndent int, key string, value *yamlv3.Node, strategy MergeStrategy) []string {
+	listStrategy := strategy == StrategyAppend || strategy == StrategyAppendUnique
+	end := inlineKeyEnd(lines, existingIdx, keyIndent)
+	section := lines[existingIdx:end]
+	if !slices.ContainsFunc(section, isTemplateLine) {
+		root, err := parseValuesNode(dedentLines(section, keyIndent))
+		if err != nil || root.Kind != yamlv3.MappingNode {
+			Logger.Warnf("Could not parse the existing %s: %v", key, err)
+			return lines
+		}
+		existing := root.Content[1]
+		existingItems := len(existing.Content)
+		merged, changed := mergeValueNodes(key, existing, value, strategy)
+		if !changed {
+			return lines
+		}
+		// Items appended to a block list are added after it, leaving the existing items as written
+		if merged == existing && existing.Kind == yamlv3.SequenceNode && !isFlowNode(existing) && existingItems > 0 {
+			return appendInlineItems(lines, section, end, keyIndent, key, existing.Content[existingItems:])
+		}
+		encoded, err := encodePair(root.Content[0], merged, strings.Repeat(" ", keyIndent), keyIndent)
+		if err != nil {
+			Logger.Warnf("Could not encode %s: %v", key, err)
+			return lines
+		}
+		return slices.Concat(lines[:existingIdx], encoded, lines[end:])
+	}
+
+	switch {
+	case strategy == StrategySkipIfPresent:
+		return lines
+	case !listStrategy || value.Kind != yamlv3.SequenceNode:
+		Logger.Warnf("Skipping %s: the existing value is templated and cannot be combined with strategy %s", key, strategy)
+		return lines
+	}
+
+	// Only the literal items of a templated list are known
+	items := value.Content
+	if strategy == StrategyAppendUnique {
+		var literal []string
+		for _, line := range section {
+			if !isTemplateLine(line) {
+				literal = append(literal, line)
+			}
+		}
+		if root, err := parseValuesNode(dedentLines(literal, keyIndent)); err == nil && root.Kind == yamlv3.MappingNode {
+			if _, current := mappingValue(root, key); current != nil && current.Kind == yamlv3.SequenceNode {
+				items = slices.DeleteFunc(slices.Clone(items), func(item *yamlv3.Node) bool {
+					return containsListItem(key, current.Content, item)
+				})
+			}
+		}
+	}
+	if len(items) == 0 {
+		return lines
+	}
+	return appendInlineItems(lines, section, findListEndPoint(lines, existingIdx, end), keyIndent, key, items)
+}
+
+// appendInlineItems inserts list items at a line, matching the indentation of the existing
+// items of the list section, or of the template rendering them (e.g. toYaml . | nindent 8),
+// or nested under the key
+func appendInlineItems(lines, section []string, at, keyIndent int, key string, items []*yamlv3.Node) []string {
+	itemIndent := keyIndent + 2
+	for _, line := range section[1:] {
+		if t := strings.TrimSpace(line); strings.HasPrefix(t, "- ") && !isTemplateLine(line) {
+			itemIndent = getIndentation(line)
+			break
+		}
+		if m := nindentRe.FindStringSubmatch(line); m != nil && isTemplateLine(line) {
+			itemIndent, _ = strconv.Atoi(m[1])
+		}
+	}
+	encoded, err := encodeNode(&yamlv3.Node{Kind: yamlv3.SequenceNode, Tag: "!!seq", Content: items}, itemIndent)
+	if err != nil {
+		Logger.Warnf("Could not encode %s: %v", key, err)
+		return lines
+	}
+	return slices.Insert(lines, at, encoded...)
+}
+
+// isTemplateLine reports whether a line contains a Helm template action
+func isTemplateLine(line string) bool {
+	return strings.Contains(line, "{{")
+}
+
+// dedentLines removes up to indent leading spaces from each line and joins them
+func dedentLines(lines []string, indent int) string {
+	result := make([]string, len(lines))
+	for i, line := range lines {
+		result[i] = line[min(indent, getIndentation(line)):]
+	}
+	return strings.Join(result, "\n")
+}
+
+// inlineKeyEnd returns the index after the last line of the key at keyIdx: the next line at
+// or left of keyIndent that is not a list item of the key, less trailing blanks and comments
+func inlineKeyEnd(lines []string, keyIdx, keyIndent int) int {
+	end := len(lines)
+	for i := keyIdx + 1; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := getIndentation(lines[i])
+		if indent < keyIndent || (indent == keyIndent && !strings.HasPrefix(trimmed, "- ")) {
+			end = i
+			break
+		}
+	}
+	return trimFrameEnd(lines, keyIdx+1, end, keyIndent)
+}
+
+// findListEndPoint finds where to append items to a list: before a Helm {{- end }} inside
+// the list that closes a block opened above it, otherwise after its last line
+func findListEndPoint(lines []string, keyIdx, end int) int {
+	depth := 0
+	for i := keyIdx + 1; i < end; i++ {
+		for _, m := range helmControlRe.FindAllStringSubmatch(lines[i], -1) {
+			switch m[1] {
+			case "else":
+			case "end":
+				if depth == 0 {
+					return i
+				}
+				depth--
+			default:
+				depth++
+			}
+		}
+	}
+	return end
+}
@@ -26,7 +26,7 @@ spec:
         - name: test-container
           image: nginx:latest`
 
-	result, err := injectInlinePodSpec(input, blocks, "Deployment", false, false)
+	result, err := injectInlinePodSpec(input, blocks, "Deployment")
 	if err != nil {
 		t.Fatalf("injectInlinePodSpec failed: %v", err)
 	}
@@ -77,7 +77,7 @@ spec:
         - name: test-container
           image: nginx:latest`
 
-	result, err := injectInlinePodSpec(input, blocks, "Deployment", false, false)
+	result, err := injectInlinePodSpec(input, blocks, "Deployment")
 	if err != nil {
 		t.Fatalf("injectInlinePodSpec failed: %v", err)
 	}
@@ -123,13 +123,13 @@ spec:
           image: nginx:latest`
 
 	// First injection
-	result, err := injectInlinePodSpec(input, blocks, "Deployment", false, false)
+	result, err := injectInlinePodSpec(input, blocks, "Deployment")
 	if err != nil {
 		t.Fatalf("First injection failed: %v", err)
 	}
 
 	// Second injection
-	result2, err := injectInlinePodSpec(result, blocks, "Deployment", false, false)
+	result2, err := injectInlinePodSpec(result, blocks, "Deployment")
 	if err != nil {
 		t.Fatalf("Second injection failed: %v", err)
 	}
@@ -177,7 +177,7 @@ spec:
         - name: test-container
           image: nginx:latest`
 
-	result, err := injectInlinePodSpec(input, blocks, "Deployment", false, false)
+	result, err := injectInlinePodSpec(input, blocks, "Deployment")
 	if err != nil {
 		t.Fatalf("injectInlinePodSpec failed: %v", err)
 	}
@@ -245,7 +245,7 @@ spec:
     - name: test-container
       image: nginx:latest`
 
-	result, err := injectInlinePodSpec(input, blocks, "Pod", false, false)
+	result, err := injectInlinePodSpec(input, blocks, "Pod")
 	if err != nil {
 		t.Fatalf("injectInlinePodSpec failed: %v", err)
 	}
@@ -321,7 +321,7 @@ spec:
         - name: test-container
           image: nginx:latest`
 
-	result, err := injectInlinePodSpec(input, blocks, "Deployment", false, false)
+	result, err := injectInlinePodSpec(input, blocks, "Deployment")
 	if err != nil {
 		t.Fatalf("injectInlinePodSpec failed: %v", err)
 	}
@@ -375,3 +375,119 @@ spec:
 		t.Logf("%3d: %s", i+1, line)
 	}
 }
+
+func TestInjectInlinePodSpec_PerKind(t *testing.T) {
+	blocks := loadTestBlocks(t, "allPods:\n- priorityClassName: platform-default\n")
+
+	templateSpec := `spec:
+  selector:
+    matchLabels:
+      app: test
+  template:
+    spec:
+      containers:
+      - name: app`
+	tests := map[string]struct {
+		spec string
+		want string
+	}{
+		"Deployment":  {templateSpec, "      priorityClassName: platform-default"},
+		"StatefulSet": {templateSpec, "      priorityClassName: platform-default"},
+		"DaemonSet":   {templateSpec, "      priorityClassName: platform-default"},
+		"ReplicaSet":  {templateSpec, "      priorityClassName: platform-default"},
+		"Job":         {templateSpec, "      priorityClassName: platform-default"},
+		"CronJob": {`spec:
+  schedule: "0 * * * *"
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: app`, "          priorityClassName: platform-default"},
+		"Pod": {`spec:
+  containers:
+  - name: app`, "  priorityClassName: platform-default"},
+	}
+	for kind, tt := range tests {
+		input := "kind: " + kind + "\nmetadata:\n  name: test\n" + tt.spec
+		result, err := injectInlinePodSpec(input, blocks, kind)
+		if err != nil {
+			t.Fatalf("%s: injectInlinePodSpec failed: %v", kind, err)
+		}
+		if !containsLine(strings.Split(result, "\n"), tt.want) || strings.Count(result, "priorityClassName") != 1 {
+			t.Errorf("%s: expected only %q in:\n%s", kind, tt.want, result)
+		}
+	}
+	t.Log("✓ Pod spec found at the path of every kind")
+}
+
+func TestInjectInlineContainerSpec_ContainerLists(t *testing.T) {
+	blocks := loadTestBlocks(t, `allContainers:
+- resources:
+    limits:
+      memory: 1Gi
+- env:
+  - name: CLUSTER
+    value: prod
+allInitContainers:
+- env:
+  - name: INIT
+    value: "true"
+`)
+
+	input := `apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: backup
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          initContainers:
+          - name: setup
+            image: busybox
+          containers:
+          - name: backup
+            image: backup:1.0
+          ephemeralContainers:
+          - name: debugger
+            image: busybox
+          volumes:
+          - name: data
+            emptyDir: {}`
+
+	result, err := injectInlineContainerSpecWithBlocks(input, blocks)
+	if err != nil {
+		t.Fatalf("injectInlineContainerSpecWithBlocks failed: %v", err)
+	}
+
+	// Split the result by container list to check each container
+	sections := map[string]string{}
+	bounds := []string{"initContainers:", "containers:", "ephemeralContainers:", "volumes:"}
+	for i, name := range []string{"setup", "backup", "debugger"} {
+		start := strings.Index(result, "  "+bounds[i])
+		end := strings.Index(result, "  "+bounds[i+1])
+		sections[name] = result[start:end]
+	}
+	sections["data"] = result[strings.Index(result, "volumes:"):]
+	checks := []struct {
+		container string
+		text      string
+		want      bool
+	}{
+		{"setup", "name: INIT", true},
+		{"setup", "memory: 1Gi", false},
+		{"backup", "memory: 1Gi", true},
+		{"backup", "name: INIT", false},
+		{"debugger", "name: CLUSTER", true},
+		{"debugger", "memory: 1Gi", false},
+		{"data", "name: CLUSTER", false},
+	}
+	for _, c := range checks {
+		if strings.Contains(sections[c.container], c.text) != c.want {
+			t.Errorf("%s: expected %q present=%v in:\n%s", c.container, c.text, c.want, result)
+		}
+	}
+	t.Log("✓ Init, regular and ephemeral containers get their own blocks")
+}
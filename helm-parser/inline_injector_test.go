@@ -49,7 +49,7 @@ func TestProcessTemplates(t *testing.T) {
 		t.Fatalf("Failed to load values.yaml: %v", err)
 	}
 
-	err = ProcessTemplates(chartDir, values, "inject-blocks.yaml", false, false, "")
+	err = ProcessTemplates(chartDir, values, "inject-blocks.yaml", nil, "", RenderOptions{})
 	if err != nil {
 		t.Fatalf("ProcessTemplates failed: %v", err)
 	}
@@ -0,0 +1,141 @@
+package helm_parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"text/template/parse"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+)
+
+// kindTemplate is the name of the template a kind expression is rendered as
+const kindTemplate = "templates/helm-parser-kind.yaml"
+
+// kindResolver evaluates templated kinds, e.g. kind: {{ .Values.workload.kind }} or
+// kind: {{ include "gateway.kind" . }}, against the values of a chart
+type kindResolver struct {
+	chart  *chart.Chart           // The chart with only its helpers as templates
+	opts   RenderOptions          // Release and cluster the kinds are evaluated for
+	schema map[string]interface{} // values.schema.json, for the values a kind may take
+	kinds  map[string][]string    // Resolved kinds, by expression
+}
+
+// newKindResolver loads the chart to evaluate templated kinds with its helpers and values
+func newKindResolver(chartDir string, render RenderOptions) (*kindResolver, error) {
+	c, err := loader.Load(chartDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chart: %v", err)
+	}
+	applyStagedTemplates(c, chartDir)
+
+	helpers := &chart.Chart{Metadata: c.Metadata, Values: c.Values, Files: c.Files}
+	for _, t := range c.Templates {
+		if strings.HasPrefix(path.Base(t.Name), "_") {
+			helpers.Templates = append(helpers.Templates, t)
+		}
+	}
+	r := &kindResolver{chart: helpers, opts: render, kinds: make(map[string][]string)}
+	if len(c.Schema) > 0 {
+		if err := json.Unmarshal(c.Schema, &r.schema); err != nil {
+			Logger.Warnf("Ignoring values.schema.json for templated kinds: %v", err)
+		}
+	}
+	return r, nil
+}
+
+// render renders a kind expression with the chart values and overrides
+func (r *kindResolver) render(expr string, overrides map[string]interface{}) (string, error) {
+	c := *r.chart
+	c.Templates = append(slices.Clone(r.chart.Templates), &chart.File{Name: kindTemplate, Data: []byte(expr)})
+	if overrides != nil {
+		c.Values = chartutil.MergeTables(overrides, r.chart.Values)
+	}
+	values, err := r.opts.renderValues(&c)
+	if err != nil {
+		return "", err
+	}
+	rendered, err := engine.Render(&c, values)
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(strings.TrimSpace(rendered[c.Name()+"/"+kindTemplate]), `"'`), nil
+}
+
+// resolve returns the workload kinds a kind expression renders as: with the chart values,
+// and with each value values.schema.json allows for the .Values paths it reads
+func (r *kindResolver) resolve(expr string) []string {
+	if kinds, ok := r.kinds[expr]; ok {
+		return kinds
+	}
+	variants := []map[string]interface{}{nil}
+	for _, p := range r.expressionPaths(expr) {
+		for _, value := range schemaEnum(r.schema, p) {
+			variants = append(variants, valuesOverride(p, value))
+		}
+	}
+
+	var kinds []string
+	for _, overrides := range variants {
+		kind, err := r.render(expr, overrides)
+		if err != nil {
+			Logger.Warnf("Could not evaluate kind %s: %v", expr, err)
+			continue
+		}
+		if _, ok := lookupWorkloadKind(kind); ok && !slices.Contains(kinds, kind) {
+			kinds = append(kinds, kind)
+		}
+	}
+	r.kinds[expr] = kinds
+	return kinds
+}
+
+// expressionPaths returns the .Values paths a kind expression reads, following its includes
+func (r *kindResolver) expressionPaths(expr string) [][]string {
+	treeSet := make(map[string]*parse.Tree)
+	for _, t := range r.chart.Templates {
+		if err := parseTemplate(t.Name, string(t.Data), treeSet); err != nil {
+			return nil
+		}
+	}
+	if err := parseTemplate(kindTemplate, expr, treeSet); err != nil {
+		return nil
+	}
+	a := &refAnalyzer{defines: treeSet, called: make(map[string]bool)}
+	root := refValue{root: true}
+	a.walk(treeSet[kindTemplate].Root, root, map[string]refValue{"$": root})
+
+	paths := make([][]string, 0, len(a.paths))
+	for _, p := range a.paths {
+		paths = append(paths, strings.Split(p, "."))
+	}
+	return paths
+}
+
+// schemaEnum returns the values a JSON schema allows at a .Values path
+func schemaEnum(schema map[string]interface{}, keys []string) []interface{} {
+	node := schema
+	for _, key := range keys {
+		properties, _ := node["properties"].(map[string]interface{})
+		node, _ = properties[key].(map[string]interface{})
+		if node == nil {
+			return nil
+		}
+	}
+	enum, _ := node["enum"].([]interface{})
+	return enum
+}
+
+// valuesOverride builds the values that set a single .Values path
+func valuesOverride(keys []string, value interface{}) map[string]interface{} {
+	overrides := map[string]interface{}{keys[len(keys)-1]: value}
+	for i := len(keys) - 2; i >= 0; i-- {
+		overrides = map[string]interface{}{keys[i]: overrides}
+	}
+	return overrides
+}
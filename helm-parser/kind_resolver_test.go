@@ -0,0 +1,103 @@
+package helm_parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const kindTestHelpers = `{{- define "chart.kind" -}}
+{{- if .Values.workload.perNode }}DaemonSet{{ else }}{{ .Values.workload.kind }}{{ end }}
+{{- end }}
+`
+
+const kindTestTemplate = `apiVersion: apps/v1
+kind: {{ include "chart.kind" . }}
+metadata:
+  name: agent
+spec:
+  template:
+    spec:
+      containers:
+      - name: agent
+        image: agent:1.0
+`
+
+func TestGetK8sResourceKind_Templated(t *testing.T) {
+	chartDir := writeTestChart(t, "workload:\n  kind: StatefulSet\n  perNode: false\n", map[string]string{
+		"_helpers.tpl": kindTestHelpers,
+		"agent.yaml":   kindTestTemplate,
+	})
+	schema := `{"properties": {"workload": {"properties": {
+  "kind": {"enum": ["Deployment", "StatefulSet", "ConfigMap"]},
+  "perNode": {"enum": [true, false]}
+}}}}`
+	if err := os.WriteFile(filepath.Join(chartDir, "values.schema.json"), []byte(schema), 0644); err != nil {
+		t.Fatalf("Failed to write schema: %v", err)
+	}
+	useFileStore(t, NewFileStore(false))
+
+	if kind := getK8sResourceKind(kindTestTemplate, nil); kind != "" {
+		t.Errorf("Expected no kind without the chart, got %q", kind)
+	}
+	resolver, err := newKindResolver(chartDir, RenderOptions{})
+	if err != nil {
+		t.Fatalf("newKindResolver failed: %v", err)
+	}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{kindTestTemplate, "StatefulSet|DaemonSet|Deployment"},
+		{"kind: {{ .Values.workload.kind | quote }}\n", "StatefulSet|Deployment"},
+		{"kind: {{ .Values.missing | default \"Job\" }}\n", "Job"},
+		{"kind: {{ .Values.workload.kind }}Config\n", ""},
+	}
+	for _, tt := range tests {
+		if kind := getK8sResourceKind(tt.template, resolver); kind != tt.want {
+			t.Errorf("Expected kind %q, got %q for:\n%s", tt.want, kind, tt.template)
+		}
+	}
+	if _, ok := lookupWorkloadKind("StatefulSet|Pod"); !ok {
+		t.Errorf("Expected a union of workload kinds to be a workload kind")
+	}
+	if !(BlockSelector{Kinds: []string{"DaemonSet"}}).matchesWorkload(Workload{Kind: "StatefulSet|DaemonSet"}) {
+		t.Errorf("Expected a selector to match one of the kinds of a templated kind")
+	}
+	t.Log("✓ Templated kinds resolved with the chart values and the values the schema allows")
+}
+
+func TestProcessTemplates_TemplatedKind(t *testing.T) {
+	blocksPath := filepath.Join(t.TempDir(), "inject-blocks.yaml")
+	blocks := `allPods:
+- priorityClassName: platform-default
+allContainers: []
+daemonSetPods:
+  match:
+    kinds: [DaemonSet]
+  blocks:
+  - tolerations:
+    - operator: Exists
+`
+	if err := os.WriteFile(blocksPath, []byte(blocks), 0644); err != nil {
+		t.Fatalf("Failed to write blocks: %v", err)
+	}
+	chartDir := writeTestChart(t, "workload:\n  perNode: true\n", map[string]string{
+		"_helpers.tpl": kindTestHelpers,
+		"agent.yaml":   kindTestTemplate,
+	})
+	useFileStore(t, NewFileStore(false))
+
+	if err := ProcessTemplates(chartDir, nil, blocksPath, nil, "", RenderOptions{}); err != nil {
+		t.Fatalf("ProcessTemplates failed: %v", err)
+	}
+	content, _ := os.ReadFile(filepath.Join(chartDir, "templates", "agent.yaml"))
+	for _, want := range []string{"      priorityClassName: platform-default", "      - operator: Exists"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Expected %q in the templated DaemonSet, got:\n%s", want, content)
+		}
+	}
+	t.Log("✓ Templates with a templated kind injected")
+}
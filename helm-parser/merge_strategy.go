@@ -0,0 +1,176 @@
+package helm_parser
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// MergeStrategy decides how an injected block is combined with a value the chart already sets
+type MergeStrategy string
+
+const (
+	StrategyAppend        MergeStrategy = "append"          // Append the injected list items
+	StrategyAppendUnique  MergeStrategy = "append-unique"   // Append the injected list items that are not already present
+	StrategyMerge         MergeStrategy = "merge"           // Deep merge maps, injected keys win
+	StrategyReplace       MergeStrategy = "replace"         // Replace the existing value
+	StrategySkipIfPresent MergeStrategy = "skip-if-present" // Only set the value when it is missing or empty
+)
+
+// listIdentityFields name the fields that identify an item of well-known lists. Items with the
+// same values for these fields are the same item, whatever their other fields, order or quoting.
+var listIdentityFields = map[string][]string{
+	"tolerations":  {"key", "operator", "effect", "value"},
+	"env":          {"name"},
+	"envFrom":      {"configMapRef.name", "secretRef.name"},
+	"volumeMounts": {"mountPath"},
+}
+
+// parseMergeStrategy validates the strategy declared for a block value
+func parseMergeStrategy(name string, value interface{}) (MergeStrategy, error) {
+	strategy := MergeStrategy(name)
+	switch strategy {
+	case StrategyAppend, StrategyAppendUnique:
+		if _, ok := value.([]interface{}); !ok {
+			return "", fmt.Errorf("strategy %s needs a list value", strategy)
+		}
+	case StrategyMerge:
+		if _, ok := value.(yaml.MapSlice); !ok {
+			return "", fmt.Errorf("strategy %s needs a map value", strategy)
+		}
+	case StrategyReplace, StrategySkipIfPresent:
+	default:
+		return "", fmt.Errorf("unknown strategy %q", name)
+	}
+	return strategy, nil
+}
+
+// defaultStrategy is used for blocks that do not declare one: lists are appended without
+// duplicates, maps are only set when missing and scalars are replaced
+func defaultStrategy(node *yamlv3.Node) MergeStrategy {
+	switch node.Kind {
+	case yamlv3.SequenceNode:
+		return StrategyAppendUnique
+	case yamlv3.MappingNode:
+		return StrategySkipIfPresent
+	}
+	return StrategyReplace
+}
+
+// keyStrategy returns the first strategy declared by the blocks of a key, or the default for its value
+func keyStrategy(blocks []InjectorBlock, value *yamlv3.Node) MergeStrategy {
+	for _, block := range blocks {
+		if block.Strategy != "" {
+			return block.Strategy
+		}
+	}
+	return defaultStrategy(value)
+}
+
+// mergeValueNodes applies the injected value to an existing one following strategy.
+// Missing or empty values are always set, except to null. A strategy that does not fit the existing
+// value (e.g. append to a map) replaces it.
+func mergeValueNodes(key string, existing, injected *yamlv3.Node, strategy MergeStrategy) (*yamlv3.Node, bool) {
+	// A null removes the value, so an empty one is left as it is
+	if isEmptyNode(existing) && isNullNode(injected) {
+		return existing, false
+	}
+	if isEmptyNode(existing) {
+		if existing != nil {
+			injected.LineComment = existing.LineComment
+		}
+		return injected, true
+	}
+	// append adds the items again even when the list already holds them
+	if strategy == StrategySkipIfPresent || (strategy != StrategyAppend && nodesEqual(existing, injected)) {
+		return existing, false
+	}
+
+	switch {
+	case (strategy == StrategyAppend || strategy == StrategyAppendUnique) &&
+		existing.Kind == yamlv3.SequenceNode && injected.Kind == yamlv3.SequenceNode:
+		changed := false
+		for _, item := range injected.Content {
+			if strategy == StrategyAppendUnique && containsListItem(key, existing.Content, item) {
+				continue
+			}
+			existing.Content = append(existing.Content, item)
+			changed = true
+		}
+		return existing, changed
+	case strategy == StrategyMerge && existing.Kind == yamlv3.MappingNode && injected.Kind == yamlv3.MappingNode:
+		merged := copyNode(existing)
+		mergeMappingNodes(merged, copyNode(injected))
+		if nodesEqual(merged, existing) {
+			return existing, false
+		}
+		// Merge into the existing node to keep its comments
+		mergeMappingNodes(existing, injected)
+		return existing, true
+	case existing.Kind == yamlv3.ScalarNode && injected.Kind == yamlv3.ScalarNode:
+		Logger.Infof("Found scalar value %s, replacing with injected value %s", existing.Value, injected.Value)
+		existing.Value, existing.Tag = injected.Value, injected.Tag
+		return existing, true
+	}
+	injected.LineComment = existing.LineComment
+	return injected, true
+}
+
+// blockKeys returns the top-level keys of blocks in the order they first appear
+func blockKeys(blocks []InjectorBlock) []string {
+	var keys []string
+	for _, block := range blocks {
+		root, err := parseValuesNode(block.Block)
+		if err != nil || root.Kind != yamlv3.MappingNode {
+			continue
+		}
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			if !slices.Contains(keys, root.Content[i].Value) {
+				keys = append(keys, root.Content[i].Value)
+			}
+		}
+	}
+	return keys
+}
+
+// itemIdentity returns the values of the identity fields of a list item, or false if it has none
+func itemIdentity(item *yamlv3.Node, fields []string) (string, bool) {
+	values := make([]string, len(fields))
+	found := false
+	for i, field := range fields {
+		node := resolveAlias(item)
+		for _, part := range strings.Split(field, ".") {
+			if node == nil || node.Kind != yamlv3.MappingNode {
+				node = nil
+				break
+			}
+			_, node = mappingValue(node, part)
+		}
+		if node != nil && node.Kind == yamlv3.ScalarNode {
+			values[i] = node.Value
+			found = true
+		}
+	}
+	return strings.Join(values, "\x00"), found
+}
+
+// sameListItem reports whether two items of the list under key are the same item: by their
+// identity fields for well-known lists such as tolerations and env, otherwise by value
+func sameListItem(key string, a, b *yamlv3.Node) bool {
+	if fields, ok := listIdentityFields[key]; ok {
+		idA, okA := itemIdentity(a, fields)
+		idB, okB := itemIdentity(b, fields)
+		if okA && okB {
+			return idA == idB
+		}
+	}
+	return nodesEqual(a, b)
+}
+
+// containsListItem reports whether items already holds item
+func containsListItem(key string, items []*yamlv3.Node, item *yamlv3.Node) bool {
+	return slices.ContainsFunc(items, func(n *yamlv3.Node) bool { return sameListItem(key, n, item) })
+}
@@ -0,0 +1,349 @@
+package helm_parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadTestBlocks writes an inject-blocks.yaml with the given content and loads it
+func loadTestBlocks(t *testing.T, content string) InjectorBlocks {
+	t.Helper()
+	return loadTestConfig(t, content).Blocks
+}
+
+func loadTestConfig(t *testing.T, content string) *InjectorConfig {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "inject-blocks.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write blocks: %v", err)
+	}
+	config, err := loadInjectorConfig(path, "")
+	if err != nil {
+		t.Fatalf("Failed to load blocks: %v", err)
+	}
+	return config
+}
+
+const strategyTestBlocks = `allPods:
+- priorityClassName: platform-critical
+  strategy: skip-if-present
+- tolerations:
+  - key: platform/dedicated
+    operator: Exists
+  strategy: append
+- affinity:
+    nodeAffinity:
+      preferredDuringSchedulingIgnoredDuringExecution:
+      - weight: 50
+        preference:
+          matchExpressions:
+          - key: platform/pool
+            operator: Exists
+  strategy: merge
+- nodeSelector:
+    kubernetes.io/os: linux
+  strategy: replace
+`
+
+func TestMergeStrategies_Values(t *testing.T) {
+	blocks := loadTestBlocks(t, strategyTestBlocks)
+
+	values := `priorityClassName: app-priority
+tolerations:
+- key: platform/dedicated
+  operator: Exists
+affinity:
+  podAntiAffinity: {} # set per release
+nodeSelector:
+  pool: apps
+`
+	for _, key := range []string{"priorityClassName", "tolerations", "affinity", "nodeSelector"} {
+		ref := ValueReference{Path: []string{key}, Key: key}
+		values, _, _ = injectBlockIntoValuesPath(values, ref, getPodBlocksByKey(blocks["allPods"], key))
+	}
+
+	lines := strings.Split(values, "\n")
+	expected := []string{
+		// skip-if-present keeps the chart's value
+		"priorityClassName: app-priority",
+		// append adds the toleration even though it is already there
+		"- key: platform/dedicated",
+		// merge keeps existing keys and adds the injected ones
+		"  podAntiAffinity: {} # set per release",
+		"  nodeAffinity:",
+		// replace drops the existing map
+		"  kubernetes.io/os: linux",
+	}
+	for _, line := range expected {
+		if !containsLine(lines, line) {
+			t.Errorf("Expected line %q in:\n%s", line, values)
+		}
+	}
+	if strings.Count(values, "key: platform/dedicated") != 2 {
+		t.Errorf("Expected the toleration to be appended a second time:\n%s", values)
+	}
+	if strings.Contains(values, "pool: apps") {
+		t.Errorf("Expected nodeSelector to be replaced:\n%s", values)
+	}
+	t.Log("✓ Values merged following the declared strategies")
+}
+
+func TestMergeStrategies_InlinePodSpec(t *testing.T) {
+	blocks := loadTestBlocks(t, strategyTestBlocks)
+
+	input := `apiVersion: v1
+kind: Pod
+metadata:
+  name: test
+spec:
+  priorityClassName: app-priority
+  affinity:
+    podAntiAffinity: {}
+  nodeSelector:
+    pool: apps
+  containers:
+  - name: app
+    image: nginx:latest`
+
+	result, err := injectInlinePodSpec(input, blocks, "Pod")
+	if err != nil {
+		t.Fatalf("injectInlinePodSpec failed: %v", err)
+	}
+
+	lines := strings.Split(result, "\n")
+	expected := []string{
+		"  priorityClassName: app-priority",
+		"  tolerations:",
+		"    - key: platform/dedicated",
+		"    podAntiAffinity: {}",
+		"    nodeAffinity:",
+		"    kubernetes.io/os: linux",
+		"  containers:",
+	}
+	for _, line := range expected {
+		if !containsLine(lines, line) {
+			t.Errorf("Expected line %q in:\n%s", line, result)
+		}
+	}
+	if strings.Contains(result, "pool: apps") {
+		t.Errorf("Expected nodeSelector to be replaced:\n%s", result)
+	}
+	t.Log("✓ Pod spec merged following the declared strategies")
+}
+
+func TestLoadInjectorBlocks_InvalidStrategy(t *testing.T) {
+	tests := map[string]string{
+		"unknown":        "allPods:\n- tolerations: []\n  strategy: prepend\n",
+		"append to map":  "allPods:\n- affinity: {}\n  strategy: append\n",
+		"merge a list":   "allPods:\n- tolerations: []\n  strategy: merge\n",
+		"several values": "allPods:\n- tolerations: []\n  affinity: {}\n  strategy: replace\n",
+	}
+	for name, content := range tests {
+		path := filepath.Join(t.TempDir(), "inject-blocks.yaml")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write blocks: %v", err)
+		}
+		if _, err := loadInjectorBlocks(path, ""); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	t.Log("✓ Invalid strategies rejected")
+}
+
+func TestSameListItem(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		a, b string
+		same bool
+	}{
+		{"toleration reordered and quoted", "tolerations", "{key: a, operator: Exists, effect: NoSchedule}", `{effect: NoSchedule, operator: "Exists", key: a}`, true},
+		{"toleration with another effect", "tolerations", "{key: a, operator: Exists, effect: NoSchedule}", "{key: a, operator: Exists, effect: NoExecute}", false},
+		{"env by name", "env", "{name: FOO, value: '1'}", "{name: FOO, valueFrom: {secretKeyRef: {name: s, key: k}}}", true},
+		{"envFrom configMap and secret", "envFrom", "{configMapRef: {name: common}}", "{secretRef: {name: common}}", false},
+		{"envFrom by name", "envFrom", "{configMapRef: {name: common}}", "{configMapRef: {name: common, optional: true}}", true},
+		{"volumeMounts by mountPath", "volumeMounts", "{name: a, mountPath: /data}", "{name: b, mountPath: /data}", true},
+		{"other lists by value", "args", "--verbose", "--verbose=false", false},
+	}
+	for _, tt := range tests {
+		a, _ := parseValuesNode(tt.a)
+		b, _ := parseValuesNode(tt.b)
+		if got := sameListItem(tt.key, a, b); got != tt.same {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.same, got)
+		}
+	}
+	t.Log("✓ List items compared by identity fields")
+}
+
+func TestMergeStrategies_SemanticDedup(t *testing.T) {
+	blocks := loadTestBlocks(t, `allPods:
+- tolerations:
+  - key: platform/dedicated
+    operator: Exists
+    effect: NoSchedule
+allContainers:
+- env:
+  - name: CLUSTER
+    value: prod
+`)
+
+	values := `tolerations:
+- effect: NoSchedule
+  key: platform/dedicated
+  operator: "Exists"
+- key: platform/dedicated
+  operator: Exists
+  effect: NoExecute
+env:
+- name: CLUSTER
+  value: staging
+`
+	for _, ref := range []ValueReference{{Path: []string{"tolerations"}, Key: "tolerations"}, {Path: []string{"env"}, Key: "env"}} {
+		injected := getPodBlocksByKey(append(blocks["allPods"], blocks["allContainers"]...), ref.Key)
+		if got, modified, _ := injectBlockIntoValuesPath(values, ref, injected); modified {
+			t.Errorf("Expected %s to be left alone, got:\n%s", ref.Key, got)
+		}
+	}
+
+	template := `apiVersion: v1
+kind: Pod
+spec:
+  tolerations:
+  - operator: "Exists"
+    effect: NoSchedule
+    key: platform/dedicated
+  containers:
+  - name: app
+    env:
+    - name: CLUSTER
+      value: staging`
+	result, err := injectInlinePodSpec(template, blocks, "Pod")
+	if err == nil {
+		result, err = injectInlineContainerSpecWithBlocks(result, blocks)
+	}
+	if err != nil {
+		t.Fatalf("Inline injection failed: %v", err)
+	}
+	if result != template {
+		t.Errorf("Expected the template to be left alone, got:\n%s", result)
+	}
+	t.Log("✓ Equivalent list items not injected twice")
+}
+
+func TestLoadInjectorBlocks_StrategyPerBlock(t *testing.T) {
+	blocks := loadTestBlocks(t, `allPods:
+- priorityClassName: platform-critical
+  strategy: skip-if-present
+clusterPods:
+- priorityClassName: platform-critical
+`)
+	values := "priorityClassName: app-priority\n"
+	ref := ValueReference{Path: []string{"priorityClassName"}, Key: "priorityClassName"}
+
+	// The same block text keeps the strategy of its own category
+	if got, modified, _ := injectBlockIntoValuesPath(values, ref, blocks["allPods"]); modified {
+		t.Errorf("Expected skip-if-present to keep the chart's value, got:\n%s", got)
+	}
+	if got, _, _ := injectBlockIntoValuesPath(values, ref, blocks["clusterPods"]); got != "priorityClassName: platform-critical\n" {
+		t.Errorf("Expected the default strategy to replace the chart's value, got:\n%s", got)
+	}
+
+	// A later load without strategies does not inherit the earlier ones
+	blocks = loadTestBlocks(t, "allPods:\n- priorityClassName: platform-critical\n")
+	if got, _, _ := injectBlockIntoValuesPath(values, ref, blocks["allPods"]); got != "priorityClassName: platform-critical\n" {
+		t.Errorf("Expected the strategy of an earlier load to be forgotten, got:\n%s", got)
+	}
+	t.Log("✓ Strategies carried by each block")
+}
+
+func TestMergeStrategies_AppendKeepsExistingItems(t *testing.T) {
+	blocks := loadTestBlocks(t, `allPods:
+- tolerations:
+  - key: platform/dedicated
+    operator: Exists
+allContainers:
+- env:
+  - name: CLUSTER
+    value: prod
+`)
+	template := `apiVersion: v1
+kind: Pod
+metadata:
+  name: test
+spec:
+  tolerations:
+  - key: existing # keep me
+    operator: "Exists"
+  containers:
+  - name: app
+    image: nginx:latest
+    env:
+        - {name: FOO, value: "1"}
+`
+	result, err := injectInlinePodSpec(template, blocks, "Pod")
+	if err == nil {
+		result, err = injectInlineContainerSpecWithBlocks(result, blocks)
+	}
+	if err != nil {
+		t.Fatalf("Inline injection failed: %v", err)
+	}
+
+	// Only the appended items differ from the template
+	expected := `apiVersion: v1
+kind: Pod
+metadata:
+  name: test
+spec:
+  tolerations:
+  - key: existing # keep me
+    operator: "Exists"
+  - key: platform/dedicated
+    operator: Exists
+  containers:
+  - name: app
+    image: nginx:latest
+    env:
+        - {name: FOO, value: "1"}
+        - name: CLUSTER
+          value: prod
+`
+	if result != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, result)
+	}
+	t.Log("✓ Appended list items added without rewriting the existing ones")
+}
+
+func TestMergeStrategies_AppendAfterTemplatedItems(t *testing.T) {
+	blocks := loadTestBlocks(t, "allPods:\n- tolerations:\n  - key: platform/dedicated\n    operator: Exists\n")
+	template := `apiVersion: v1
+kind: Pod
+metadata:
+  name: test
+spec:
+  tolerations:
+    - key: {{ .Values.backend }}
+      operator: Exists
+    {{- with .Values.extendTolerations }}
+    {{- toYaml . | nindent 4 }}
+    {{- end }}
+    {{- include "chart.appendTolerations" . | nindent 4 }}
+  containers:
+  - name: app
+    image: nginx:latest
+`
+	result, err := injectInlinePodSpec(template, blocks, "Pod")
+	if err != nil {
+		t.Fatalf("injectInlinePodSpec failed: %v", err)
+	}
+	// Actions that only mention end, e.g. extendTolerations or append, do not close the list
+	want := `    {{- include "chart.appendTolerations" . | nindent 4 }}
+    - key: platform/dedicated
+      operator: Exists
+  containers:`
+	if !strings.Contains(result, want) {
+		t.Errorf("Expected the items appended after the templated ones, got:\n%s", result)
+	}
+	t.Log("✓ Items appended after the templated items of a list")
+}
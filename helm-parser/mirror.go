@@ -0,0 +1,289 @@
+package helm_parser
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/distribution/reference"
+	regauthn "github.com/google/go-containerregistry/pkg/authn"
+	regname "github.com/google/go-containerregistry/pkg/name"
+	regremote "github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Mirror statuses reported in the summary table
+const (
+	MirrorStatusCopied  = "copied"
+	MirrorStatusPlanned = "planned" // dry run: would be copied
+	MirrorStatusExists  = "exists"
+	MirrorStatusFailed  = "failed"
+)
+
+// MirrorOptions controls how images are copied into the local repository
+type MirrorOptions struct {
+	Concurrency int               // Number of images copied in parallel
+	Retries     int               // Extra attempts per image after a failure
+	RetryDelay  time.Duration     // Delay before the first retry, doubled on each attempt
+	Transport   http.RoundTripper // Transport for registry requests; nil uses the default
+}
+
+// MirrorResult is the outcome of mirroring a single image
+type MirrorResult struct {
+	Source   string // Upstream image reference
+	Target   string // Image reference in the local repository
+	Status   string
+	Attempts int
+	Err      error
+}
+
+// splitImageReference splits an image into its repository and the ":tag" / "@digest" suffix
+func splitImageReference(image string) (string, string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", "", err
+	}
+	suffix := ""
+	if tagged, ok := named.(reference.Tagged); ok {
+		suffix = ":" + tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		suffix += "@" + digested.Digest().String()
+	}
+	return reference.FamiliarName(named), suffix, nil
+}
+
+// localRepoParts parses the local repository into its domain and path
+func localRepoParts(localRepo string) (string, string, error) {
+	named, err := reference.ParseNormalizedNamed(localRepo)
+	if err != nil {
+		return "", "", fmt.Errorf("error parsing local repo reference %s: %v", localRepo, err)
+	}
+	return reference.Domain(named), reference.Path(named), nil
+}
+
+// localImageFor maps an upstream image to its location under the local repository,
+// using the same path scheme as the values.yaml registry rewrite
+func localImageFor(image string, localRepo string) (string, error) {
+	newRegDomain, newRegPath, err := localRepoParts(localRepo)
+	if err != nil {
+		return "", err
+	}
+	repo, suffix, err := splitImageReference(image)
+	if err != nil {
+		return "", err
+	}
+	local, _, err := rewriteRegistryValue(repo, newRegDomain, newRegPath)
+	if err != nil {
+		return "", err
+	}
+	return local + suffix, nil
+}
+
+// upstreamImageFor reverses the registry rewrite: it maps an image under the local
+// repository (<newDomain>/<newPath>/<oldDomain>/<oldPath>) back to <oldDomain>/<oldPath>.
+// Images placed by a target or prefix rewrite rule are mapped back through that rule.
+func upstreamImageFor(image string, localRepo string) (string, error) {
+	// Images placed by a rewrite rule are reversed with that rule
+	if upstream, ok := reverseRegistryRules(image); ok {
+		if _, err := regname.ParseReference(upstream); err != nil {
+			return "", fmt.Errorf("cannot map %s back to an upstream image: %v", image, err)
+		}
+		return upstream, nil
+	}
+	newRegDomain, newRegPath, err := localRepoParts(localRepo)
+	if err != nil {
+		return "", err
+	}
+	prefix := path.Join(newRegDomain, newRegPath) + "/"
+	if !strings.HasPrefix(image, prefix) {
+		return "", fmt.Errorf("image %s is not under local repository %s", image, localRepo)
+	}
+	upstream := strings.TrimPrefix(image, prefix)
+	// The first path component must be the original registry domain
+	domain, _, found := strings.Cut(upstream, "/")
+	if !found || (!strings.ContainsAny(domain, ".:") && domain != "localhost") {
+		return "", fmt.Errorf("cannot map %s back to an upstream image: %s does not start with a registry domain", image, upstream)
+	}
+	if _, err := regname.ParseReference(upstream); err != nil {
+		return "", fmt.Errorf("cannot map %s back to an upstream image: %v", image, err)
+	}
+	return upstream, nil
+}
+
+// mirrorPair returns the upstream source and local target for an image, whether the
+// rendered chart already points at the local repository or still at the upstream registry
+func mirrorPair(image string, localRepo string) (string, string, error) {
+	if upstream, err := upstreamImageFor(image, localRepo); err == nil {
+		return upstream, image, nil
+	}
+	local, err := localImageFor(image, localRepo)
+	if err != nil {
+		return "", "", err
+	}
+	return image, local, nil
+}
+
+// copyImage copies the manifest (or index, for multi-arch images) and blobs of src to dst
+func copyImage(ctx context.Context, src, dst string, keychain regauthn.Keychain, transport http.RoundTripper) error {
+	srcRef, err := regname.ParseReference(src)
+	if err != nil {
+		return fmt.Errorf("failed to parse source %s: %v", src, err)
+	}
+	dstRef, err := regname.ParseReference(dst)
+	if err != nil {
+		return fmt.Errorf("failed to parse target %s: %v", dst, err)
+	}
+	opts := []regremote.Option{regremote.WithAuthFromKeychain(keychain), regremote.WithContext(ctx)}
+	if transport != nil {
+		opts = append(opts, regremote.WithTransport(transport))
+	}
+
+	desc, err := regremote.Get(srcRef, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %v", src, err)
+	}
+	if desc.MediaType.IsIndex() {
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return fmt.Errorf("failed to read index %s: %v", src, err)
+		}
+		return regremote.WriteIndex(dstRef, idx, opts...)
+	}
+	img, err := desc.Image()
+	if err != nil {
+		return fmt.Errorf("failed to read image %s: %v", src, err)
+	}
+	return regremote.Write(dstRef, img, opts...)
+}
+
+// MirrorImages copies each image from its upstream registry into the local repository.
+// Images may be given either as rendered local references or as upstream references.
+// In dry-run mode nothing is copied and every image is reported as planned.
+func MirrorImages(ctx context.Context, images []string, localRepo string, keychain regauthn.Keychain, opts MirrorOptions, dryRun bool) []MirrorResult {
+	if keychain == nil {
+		keychain = staticKeychain{}
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 4
+	}
+
+	results := make([]MirrorResult, len(images))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+
+	for i, img := range images {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			source, target, err := mirrorPair(img, localRepo)
+			if err != nil {
+				results[i] = MirrorResult{Source: img, Status: MirrorStatusFailed, Err: err}
+				return
+			}
+			result := MirrorResult{Source: source, Target: target}
+			if dryRun {
+				result.Status = MirrorStatusPlanned
+				results[i] = result
+				return
+			}
+
+			delay := opts.RetryDelay
+			for attempt := 0; attempt <= opts.Retries; attempt++ {
+				result.Attempts = attempt + 1
+				if result.Err = copyImage(ctx, source, target, keychain, opts.Transport); result.Err == nil {
+					break
+				}
+				Logger.Warnf("attempt %d to mirror %s failed: %v", attempt+1, source, result.Err)
+				if attempt < opts.Retries {
+					select {
+					case <-time.After(delay):
+					case <-ctx.Done():
+					}
+					delay *= 2
+				}
+			}
+			if result.Err != nil {
+				result.Status = MirrorStatusFailed
+			} else {
+				result.Status = MirrorStatusCopied
+			}
+			results[i] = result
+		}()
+	}
+
+	wg.Wait()
+	return results
+}
+
+// WriteMirrorSummary writes a table of mirror results to w
+func WriteMirrorSummary(w io.Writer, results []MirrorResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tTARGET\tSTATUS\tATTEMPTS\tERROR")
+	for _, r := range results {
+		errText := ""
+		if r.Err != nil {
+			errText = r.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", r.Source, r.Target, r.Status, r.Attempts, errText)
+	}
+	return tw.Flush()
+}
+
+// MirrorChart renders the chart, finds the images missing from the local repository and
+// copies them from their upstream registries, writing a summary table to out
+func MirrorChart(opts Options, mirrorOpts MirrorOptions, out io.Writer) error {
+	images, err := ListImages(opts)
+	if err != nil {
+		return err
+	}
+	keychain, err := NewRegistryKeychain(opts.RegistryAuth, opts.LocalRepo)
+	if err != nil {
+		Logger.Errorf("failed to set up registry authentication: %v", err)
+		return err
+	}
+
+	ctx := context.Background()
+	// Check which local images already exist. Images not rewritten yet are checked at their local location.
+	var targets []string
+	for _, img := range images {
+		if _, target, err := mirrorPair(img, opts.LocalRepo); err == nil {
+			targets = append(targets, target)
+		}
+	}
+	existMap, err := CheckImagesExist(ctx, targets, keychain)
+	if err != nil {
+		Logger.Errorf("failed to check images existence: %v", err)
+	}
+
+	var results []MirrorResult
+	var missing []string
+	for _, img := range images {
+		source, target, err := mirrorPair(img, opts.LocalRepo)
+		if err == nil && existMap[target] {
+			results = append(results, MirrorResult{Source: source, Target: target, Status: MirrorStatusExists})
+			continue
+		}
+		missing = append(missing, img)
+	}
+	results = append(results, MirrorImages(ctx, missing, opts.LocalRepo, keychain, mirrorOpts, opts.DryRun)...)
+
+	if err := WriteMirrorSummary(out, results); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Status == MirrorStatusFailed {
+			return fmt.Errorf("one or more images could not be mirrored")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package helm_parser
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	regname "github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	regremote "github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// hostRouter routes requests for fake registry hostnames to in-process registries.
+// Image paths cannot contain a port, so the test registries need plain hostnames.
+type hostRouter map[string]string
+
+func (h hostRouter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if addr, ok := h[req.URL.Hostname()]; ok {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = "http"
+		req.URL.Host = addr
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// newTestRegistry starts an in-process registry reachable as host through the router
+func newTestRegistry(t *testing.T, router hostRouter, host string) {
+	t.Helper()
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(srv.Close)
+	router[host] = strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestUpstreamImageFor(t *testing.T) {
+	tests := []struct {
+		local    string
+		upstream string
+	}{
+		{"registry.example.com/home/ext/docker.io/nginx:1.25", "docker.io/nginx:1.25"},
+		{"registry.example.com/home/ext/quay.io/jetstack/cert-manager-controller:v1.14.3", "quay.io/jetstack/cert-manager-controller:v1.14.3"},
+		{"registry.example.com/home/ext/gcr.io/istio-release/pilot@sha256:" + strings.Repeat("a", 64), "gcr.io/istio-release/pilot@sha256:" + strings.Repeat("a", 64)},
+	}
+	for _, tt := range tests {
+		got, err := upstreamImageFor(tt.local, "registry.example.com/home/ext")
+		if err != nil {
+			t.Errorf("upstreamImageFor(%s) failed: %v", tt.local, err)
+			continue
+		}
+		if got != tt.upstream {
+			t.Errorf("upstreamImageFor(%s) = %s, expected %s", tt.local, got, tt.upstream)
+		}
+		// Round trip through the forward mapping
+		local, err := localImageFor(got, "registry.example.com/home/ext")
+		if err != nil || local != tt.local {
+			t.Errorf("localImageFor(%s) = %s (%v), expected %s", got, local, err, tt.local)
+		}
+	}
+
+	if _, err := upstreamImageFor("registry.example.com/home/ext/nginx:1.25", "registry.example.com/home/ext"); err == nil {
+		t.Error("Expected an error for an image without an upstream registry domain")
+	}
+	if _, err := upstreamImageFor("quay.io/foo/bar:1", "registry.example.com/home/ext"); err == nil {
+		t.Error("Expected an error for an image outside the local repository")
+	}
+}
+
+func TestMirrorImages_CopiesImagesAndIndexes(t *testing.T) {
+	router := hostRouter{}
+	upstream := "upstream.example.com"
+	newTestRegistry(t, router, upstream)
+	newTestRegistry(t, router, "local.example.com")
+	localRepo := "local.example.com/ext"
+
+	img, err := random.Image(512, 1)
+	if err != nil {
+		t.Fatalf("Failed to create random image: %v", err)
+	}
+	imgRef, _ := regname.ParseReference(upstream + "/team/app:1.0")
+	if err := regremote.Write(imgRef, img, regremote.WithTransport(router)); err != nil {
+		t.Fatalf("Failed to push image: %v", err)
+	}
+
+	idx, err := random.Index(512, 1, 2)
+	if err != nil {
+		t.Fatalf("Failed to create random index: %v", err)
+	}
+	idxRef, _ := regname.ParseReference(upstream + "/team/multiarch:2.0")
+	if err := regremote.WriteIndex(idxRef, idx, regremote.WithTransport(router)); err != nil {
+		t.Fatalf("Failed to push index: %v", err)
+	}
+
+	images := []string{
+		// Rendered chart already rewritten to the local repository
+		localRepo + "/" + upstream + "/team/app:1.0",
+		// Rendered chart still pointing upstream
+		upstream + "/team/multiarch:2.0",
+		// Missing upstream
+		localRepo + "/" + upstream + "/team/missing:3.0",
+	}
+	results := MirrorImages(context.Background(), images, localRepo, nil, MirrorOptions{Concurrency: 2, Retries: 1, Transport: router}, false)
+
+	statuses := make(map[string]MirrorResult)
+	for _, r := range results {
+		statuses[r.Source] = r
+	}
+	if r := statuses[upstream+"/team/app:1.0"]; r.Status != MirrorStatusCopied {
+		t.Errorf("Expected app image to be copied, got %+v", r)
+	}
+	if r := statuses[upstream+"/team/multiarch:2.0"]; r.Status != MirrorStatusCopied || r.Target != localRepo+"/"+upstream+"/team/multiarch:2.0" {
+		t.Errorf("Expected multiarch index to be copied, got %+v", r)
+	}
+	if r := statuses[upstream+"/team/missing:3.0"]; r.Status != MirrorStatusFailed || r.Attempts != 2 {
+		t.Errorf("Expected missing image to fail after 2 attempts, got %+v", r)
+	}
+
+	// The copies should now exist in the local registry with the same digests
+	appRef, _ := regname.ParseReference(localRepo + "/" + upstream + "/team/app:1.0")
+	if _, err := regremote.Head(appRef, regremote.WithTransport(router)); err != nil {
+		t.Errorf("Expected app image to exist in the local registry: %v", err)
+	}
+	wantDigest, _ := idx.Digest()
+	copiedRef, _ := regname.ParseReference(localRepo + "/" + upstream + "/team/multiarch:2.0")
+	desc, err := regremote.Head(copiedRef, regremote.WithTransport(router))
+	if err != nil || desc.Digest != wantDigest {
+		t.Errorf("Expected copied index digest %s, got %v (%v)", wantDigest, desc, err)
+	}
+
+	var out bytes.Buffer
+	if err := WriteMirrorSummary(&out, results); err != nil {
+		t.Fatalf("WriteMirrorSummary failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "STATUS") || strings.Count(out.String(), MirrorStatusCopied) != 2 {
+		t.Errorf("Unexpected summary:\n%s", out.String())
+	}
+}
+
+func TestMirrorImages_DryRunCopiesNothing(t *testing.T) {
+	localRepo := "local.example.com/ext"
+
+	results := MirrorImages(context.Background(), []string{"quay.io/team/app:1.0"}, localRepo, nil, MirrorOptions{}, true)
+	if len(results) != 1 || results[0].Status != MirrorStatusPlanned || results[0].Target != localRepo+"/quay.io/team/app:1.0" {
+		t.Errorf("Expected a planned copy, got %+v", results)
+	}
+}
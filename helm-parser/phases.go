@@ -0,0 +1,235 @@
+package helm_parser
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Options holds the settings shared by every phase of chart processing
+type Options struct {
+	ChartPath      string   // Path to the Helm chart directory
+	LocalRepo      string   // Local repository prefix for images
+	CustomYaml     string   // Path to the YAML file with injection blocks
+	Profiles       []string // Profiles of the injection blocks to enable, e.g. controlPlane
+	SystemCritical string   // System critical component (node, cluster or default)
+	DryRun         bool     // Stage changes in memory and print a diff instead of writing
+	Verbose        bool     // Log rendered manifests
+	PinDigests     bool     // Pin every rendered image to its digest in values.yaml or the templates
+
+	Render RenderOptions // Release and cluster the chart is rendered for
+
+	RegistryAuth RegistryAuthOptions // Credentials for registry requests
+}
+
+// startRun prepares the file store for a phase that may modify the chart and backs up every
+// chart file before it is modified. A dry run never touches the chart, so no backup is needed.
+func startRun(opts Options) error {
+	Files = NewFileStore(opts.DryRun)
+	if opts.DryRun {
+		return nil
+	}
+	if err := backupChart(opts.ChartPath); err != nil {
+		Logger.Errorf("failed to back up the chart: %v", err)
+		return err
+	}
+	return nil
+}
+
+// finishRun prints the staged changes of a dry run and fails if there are any,
+// so callers can detect pending edits
+func finishRun(opts Options) error {
+	if !opts.DryRun {
+		return nil
+	}
+	if err := Files.WriteDiff(os.Stdout, opts.ChartPath); err != nil {
+		return fmt.Errorf("failed to write diff: %v", err)
+	}
+	if Files.Pending() {
+		return ErrPendingChanges
+	}
+	Logger.Infof("Dry run: no changes pending")
+	return nil
+}
+
+// checkCustomYaml verifies that the injection blocks file exists
+func checkCustomYaml(customYaml string) error {
+	if _, err := os.Stat(customYaml); os.IsNotExist(err) {
+		Logger.Errorf("Custom YAML file %s does not exist: %v", customYaml, err)
+		return err
+	}
+	return nil
+}
+
+// overlayManifest is the chart rendered with one values overlay
+type overlayManifest struct {
+	name     string // Values of the overlay, e.g. values.yaml + env/prod.yaml
+	manifest string
+}
+
+// renderOverlays renders the chart once per values overlay: with the values files layered,
+// or in matrix mode with each values file on its own
+func renderOverlays(opts Options) ([]overlayManifest, error) {
+	var rendered []overlayManifest
+	for _, render := range opts.Render.overlays() {
+		rel, err := renderChartFromValues(opts.ChartPath, render)
+		if err != nil {
+			Logger.Errorf("failed to render chart with %s: %v", render.valuesName(), err)
+			return nil, err
+		}
+		rendered = append(rendered, overlayManifest{name: render.valuesName(), manifest: rel.Manifest})
+	}
+	return rendered, nil
+}
+
+// ListImages renders the chart and returns every container image it deploys, across every
+// values overlay
+func ListImages(opts Options) ([]string, error) {
+	rendered, err := renderOverlays(opts)
+	if err != nil {
+		return nil, err
+	}
+	var images []string
+	for _, overlay := range rendered {
+		overlayImages, err := ExtractImagesFromManifest(overlay.manifest)
+		if err != nil {
+			Logger.Errorf("failed to extract images from manifest: %v", err)
+			return nil, err
+		}
+		for _, img := range overlayImages {
+			if !slices.Contains(images, img) {
+				images = append(images, img)
+			}
+		}
+	}
+	return images, nil
+}
+
+// UpdateRegistry rewrites the image registries in values.yaml and the templates to the local repository
+func UpdateRegistry(opts Options) error {
+	if err := startRun(opts); err != nil {
+		return err
+	}
+	if err := updateRegistry(opts); err != nil {
+		return err
+	}
+	if opts.PinDigests {
+		if err := pinDigests(opts); err != nil {
+			Logger.Errorf("failed to pin image digests: %v", err)
+			return err
+		}
+	}
+	return finishRun(opts)
+}
+
+// InjectBlocks injects the custom blocks into values.yaml and the chart templates
+func InjectBlocks(opts Options) error {
+	if err := startRun(opts); err != nil {
+		return err
+	}
+	if err := checkCustomYaml(opts.CustomYaml); err != nil {
+		return err
+	}
+	if _, err := injectBlocks(opts); err != nil {
+		return err
+	}
+	return finishRun(opts)
+}
+
+// injectBlocks processes the chart templates to inject the custom blocks and returns the
+// injector configuration it injected
+func injectBlocks(opts Options) (*InjectorConfig, error) {
+	values, err := LoadValues(opts.ChartPath)
+	if err != nil {
+		Logger.Errorf("failed to load values: %v", err)
+		return nil, err
+	}
+	config, err := loadInjectorConfig(opts.CustomYaml, opts.SystemCritical)
+	if err != nil {
+		err = fmt.Errorf("failed to load injector blocks: %v", err)
+		Logger.Errorf("failed to process templates: %v", err)
+		return nil, err
+	}
+	// Process templates to inject inline injector container spec
+	if err := processTemplates(opts.ChartPath, values, config, opts.Profiles, opts.Render); err != nil {
+		Logger.Errorf("failed to process templates: %v", err)
+		return nil, err
+	}
+	return config, nil
+}
+
+// RenderChart renders the chart locally and returns the combined manifest. In matrix mode
+// the manifest of each values overlay follows a comment naming its values.
+func RenderChart(opts Options) (string, error) {
+	rendered, err := renderOverlays(opts)
+	if err != nil {
+		return "", err
+	}
+	if len(rendered) == 1 {
+		return rendered[0].manifest, nil
+	}
+	var sb strings.Builder
+	for _, overlay := range rendered {
+		fmt.Fprintf(&sb, "# Values: %s\n%s", overlay.name, overlay.manifest)
+	}
+	return sb.String(), nil
+}
+
+// VerifyChart renders the chart and checks that every image exists in the registry
+func VerifyChart(opts Options) error {
+	return verifyImages(opts)
+}
+
+// verifyImages renders the chart, extracts the images from the pod specs and checks
+// that each one exists in its registry
+func verifyImages(opts Options) error {
+	// Parse rendered manifest and extract images from pod specs so that we can check if they exist
+	images, err := ListImages(opts)
+	if err != nil {
+		return err
+	}
+	Logger.Infof("rendered images:")
+	for _, img := range images {
+		Logger.Infof("%s", img)
+	}
+	// Check if images exist in our registry
+	keychain, err := NewRegistryKeychain(opts.RegistryAuth, opts.LocalRepo)
+	if err != nil {
+		Logger.Errorf("failed to set up registry authentication: %v", err)
+		return err
+	}
+	imageExistMap, err := CheckImagesExist(context.Background(), images, keychain)
+	if err != nil {
+		Logger.Errorf("failed to check images existence: %v", err)
+	}
+	// Log missing images
+	failFatal := false
+	for _, img := range images {
+		if exists, ok := imageExistMap[img]; ok {
+			if !exists {
+				Logger.Errorf("Image does not exist in registry: %s", img)
+				failFatal = true
+			} else {
+				// DEBUG
+				Logger.Infof("Image exists in registry: %s", img)
+			}
+		}
+	}
+	if failFatal {
+		return fmt.Errorf("one or more images do not exist in registry")
+	}
+	return nil
+}
+
+// RestoreChart restores values.yaml and the templates from the backups taken before they were
+// first modified
+func RestoreChart(opts Options) error {
+	Files = NewFileStore(opts.DryRun)
+	if err := restoreChart(opts.ChartPath); err != nil {
+		Logger.Errorf("failed to restore the chart: %v", err)
+		return err
+	}
+	return finishRun(opts)
+}
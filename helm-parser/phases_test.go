@@ -0,0 +1,116 @@
+package helm_parser
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const phasesTestPod = `apiVersion: v1
+kind: Pod
+metadata:
+  name: test
+spec:
+  containers:
+  - name: app
+    image: {{ .Values.image.repository }}:{{ .Values.image.tag }}
+`
+
+func TestListImages(t *testing.T) {
+	chartDir := writeTestChart(t, "image:\n  repository: docker.io/library/nginx\n  tag: \"1.25\"\n", map[string]string{"pod.yaml": phasesTestPod})
+	useFileStore(t, NewFileStore(false))
+
+	images, err := ListImages(Options{ChartPath: chartDir})
+	if err != nil {
+		t.Fatalf("ListImages failed: %v", err)
+	}
+	if len(images) != 1 || images[0] != "docker.io/library/nginx:1.25" {
+		t.Errorf("Unexpected images: %v", images)
+	}
+}
+
+func TestUpdateRegistry_DryRunReportsPendingChanges(t *testing.T) {
+	values := "image:\n  repository: docker.io/library/nginx\n  tag: \"1.25\"\n"
+	chartDir := writeTestChart(t, values, map[string]string{"pod.yaml": phasesTestPod})
+	useFileStore(t, NewFileStore(false))
+
+	err := UpdateRegistry(Options{ChartPath: chartDir, LocalRepo: "registry.example.com/ext", DryRun: true})
+	if !errors.Is(err, ErrPendingChanges) {
+		t.Fatalf("Expected ErrPendingChanges, got %v", err)
+	}
+
+	onDisk, _ := os.ReadFile(filepath.Join(chartDir, "values.yaml"))
+	if string(onDisk) != values {
+		t.Errorf("Expected values.yaml to be untouched, got:\n%s", onDisk)
+	}
+	if _, err := os.Stat(filepath.Join(chartDir, "values.yaml.backup")); !os.IsNotExist(err) {
+		t.Error("Expected no backup to be written in dry-run mode")
+	}
+	if _, err := os.Stat(filepath.Join(chartDir, backupDirName)); !os.IsNotExist(err) {
+		t.Error("Expected no backup to be written in dry-run mode")
+	}
+}
+
+func TestUpdateRegistryAndRestore(t *testing.T) {
+	values := "image:\n  repository: docker.io/library/nginx\n  tag: \"1.25\"\n"
+	chartDir := writeTestChart(t, values, map[string]string{"pod.yaml": phasesTestPod})
+	useFileStore(t, NewFileStore(false))
+	opts := Options{ChartPath: chartDir, LocalRepo: "registry.example.com/ext"}
+
+	if err := UpdateRegistry(opts); err != nil {
+		t.Fatalf("UpdateRegistry failed: %v", err)
+	}
+	manifest, err := RenderChart(opts)
+	if err != nil {
+		t.Fatalf("RenderChart failed: %v", err)
+	}
+	if !strings.Contains(manifest, "image: registry.example.com/ext/docker.io/nginx:1.25") {
+		t.Errorf("Expected rendered manifest to use the local repository, got:\n%s", manifest)
+	}
+
+	if err := RestoreChart(opts); err != nil {
+		t.Fatalf("RestoreChart failed: %v", err)
+	}
+	restored, _ := os.ReadFile(filepath.Join(chartDir, "values.yaml"))
+	if string(restored) != values {
+		t.Errorf("Expected values.yaml to be restored, got:\n%s", restored)
+	}
+}
+
+func TestInjectBlocksAndRestore(t *testing.T) {
+	values := "image:\n  repository: docker.io/library/nginx\n  tag: \"1.25\"\ntolerations: []\n"
+	template := phasesTestPod + "  {{- with .Values.tolerations }}\n  tolerations:\n    {{- toYaml . | nindent 4 }}\n  {{- end }}\n"
+	chartDir := writeTestChart(t, values, map[string]string{"pod.yaml": template})
+	useFileStore(t, NewFileStore(false))
+	blocksPath := filepath.Join(t.TempDir(), "inject-blocks.yaml")
+	if err := os.WriteFile(blocksPath, []byte("allPods:\n- tolerations:\n  - key: platform/dedicated\n    operator: Exists\n- priorityClassName: platform-default\n"), 0644); err != nil {
+		t.Fatalf("Failed to write blocks: %v", err)
+	}
+	opts := Options{ChartPath: chartDir, CustomYaml: blocksPath}
+
+	if err := InjectBlocks(opts); err != nil {
+		t.Fatalf("InjectBlocks failed: %v", err)
+	}
+	templatePath := filepath.Join(chartDir, "templates", "pod.yaml")
+	for path, original := range map[string]string{filepath.Join(chartDir, "values.yaml"): values, templatePath: template} {
+		if modified, _ := os.ReadFile(path); string(modified) == original {
+			t.Fatalf("Expected %s to be modified by injection", path)
+		}
+	}
+	// A second run keeps the backup of the original chart
+	if err := InjectBlocks(opts); err != nil {
+		t.Fatalf("InjectBlocks failed: %v", err)
+	}
+
+	if err := RestoreChart(opts); err != nil {
+		t.Fatalf("RestoreChart failed: %v", err)
+	}
+	for path, original := range map[string]string{filepath.Join(chartDir, "values.yaml"): values, templatePath: template} {
+		if restored, _ := os.ReadFile(path); string(restored) != original {
+			t.Errorf("Expected %s to be restored, got:\n%s", path, restored)
+		}
+	}
+	t.Log("✓ Values and templates restored from the backup")
+}
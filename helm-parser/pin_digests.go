@@ -0,0 +1,321 @@
+package helm_parser
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/reference"
+	regauthn "github.com/google/go-containerregistry/pkg/authn"
+	regname "github.com/google/go-containerregistry/pkg/name"
+	regremote "github.com/google/go-containerregistry/pkg/v1/remote"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// ResolveImageDigests resolves each image to the sha256 digest of its manifest (or index,
+// for multi-arch images). Images that are already pinned keep their digest; images that
+// cannot be resolved are left out of the result.
+func ResolveImageDigests(ctx context.Context, images []string, keychain regauthn.Keychain) map[string]string {
+	concurrency := 4
+	timeout := 30 * time.Second
+	results := make(map[string]string, len(images))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	sem := make(chan struct{}, concurrency)
+
+	if keychain == nil {
+		keychain = staticKeychain{}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for _, img := range images {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+
+			ref, err := regname.ParseReference(img)
+			if err != nil {
+				Logger.Warnf("failed to parse image reference %s: %v", img, err)
+				return
+			}
+			if digest, ok := ref.(regname.Digest); ok {
+				mu.Lock()
+				results[img] = digest.DigestStr()
+				mu.Unlock()
+				return
+			}
+
+			opts := []regremote.Option{regremote.WithAuthFromKeychain(keychain), regremote.WithContext(ctx)}
+			desc, err := regremote.Head(ref, opts...)
+			if err != nil {
+				Logger.Warnf("failed to resolve digest for %s: %v", img, err)
+				return
+			}
+			mu.Lock()
+			results[img] = desc.Digest.String()
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+	return results
+}
+
+// normalizedRepository returns the fully qualified repository name (docker.io/library/nginx)
+// of an image or repository string, or "" if it cannot be parsed
+func normalizedRepository(value string) string {
+	named, err := reference.ParseNormalizedNamed(value)
+	if err != nil {
+		return ""
+	}
+	return named.Name()
+}
+
+// imageRepoAndTag splits an image into its fully qualified repository and tag.
+// ok is false if the image cannot be parsed, has no tag or is already pinned to a digest.
+func imageRepoAndTag(image string) (string, string, bool) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", "", false
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return "", "", false
+	}
+	tagged, ok := named.(reference.Tagged)
+	if !ok {
+		return "", "", false
+	}
+	return named.Name(), tagged.Tag(), true
+}
+
+// imageMapRepositories returns the repositories a values mapping can produce, as the common
+// chart styles combine them: registry+repository (Bitnami), hub+image (Istio), repository or image
+func imageMapRepositories(mapping *yamlv3.Node) []string {
+	var repos []string
+	join := func(prefix, name string) {
+		p, okP := scalarValue(mapping, prefix)
+		n, okN := scalarValue(mapping, name)
+		if okP && okN && p.Value != "" && n.Value != "" {
+			repos = append(repos, strings.TrimSuffix(p.Value, "/")+"/"+n.Value)
+		}
+	}
+	join("registry", "repository")
+	join("hub", "image")
+	join("hub", "repository")
+	for _, key := range []string{"repository", "image"} {
+		if n, ok := scalarValue(mapping, key); ok && n.Value != "" {
+			repos = append(repos, n.Value)
+		}
+	}
+	return repos
+}
+
+// scalarEdit is a pending in-place replacement of a scalar in values.yaml
+type scalarEdit struct {
+	node  *yamlv3.Node
+	value string
+	image string
+}
+
+// pinDigestsInValues pins the images found in values.yaml to the given digests (keyed by
+// image). A plain "image: repo:tag" string becomes "repo:tag@sha256:..."; an image map
+// gets its digest key set if it has one, otherwise its tag becomes "tag@sha256:...".
+// Returns the new content and the images that were pinned.
+func pinDigestsInValues(content string, digests map[string]string) (string, []string, error) {
+	root, err := parseValuesNode(content)
+	if err != nil {
+		return content, nil, fmt.Errorf("failed to parse values.yaml: %v", err)
+	}
+
+	edits := make(map[*yamlv3.Node]scalarEdit)
+	conflicts := make(map[*yamlv3.Node]bool)
+	addEdit := func(node *yamlv3.Node, value, image string) {
+		if prev, ok := edits[node]; ok && prev.value != value {
+			// A tag shared by images with different digests cannot be pinned
+			Logger.Warnf("Not pinning %s at line %d: shared by %s and %s with different digests", node.Value, node.Line, prev.image, image)
+			conflicts[node] = true
+			return
+		}
+		edits[node] = scalarEdit{node: node, value: value, image: image}
+	}
+
+	walkMappings(root, nil, func(path []string, mapping *yamlv3.Node) {
+		for image, digest := range digests {
+			repo, tag, ok := imageRepoAndTag(image)
+			if !ok {
+				continue
+			}
+			// Plain image string, e.g. image: docker.io/nginx:1.25
+			if imgNode, ok := scalarValue(mapping, "image"); ok {
+				if r, t, ok := imageRepoAndTag(imgNode.Value); ok && r == repo && t == tag {
+					addEdit(imgNode, imgNode.Value+"@"+digest, image)
+					continue
+				}
+			}
+			// Image map with a tag
+			tagNode, ok := scalarValue(mapping, "tag")
+			if !ok || tagNode.Value != tag {
+				continue
+			}
+			for _, candidate := range imageMapRepositories(mapping) {
+				if normalizedRepository(candidate) != repo {
+					continue
+				}
+				if digestNode, ok := scalarValue(mapping, "digest"); ok {
+					addEdit(digestNode, digest, image)
+				} else {
+					addEdit(tagNode, tag+"@"+digest, image)
+				}
+				Logger.Infof("Pinning %s at values path %s", image, strings.Join(path, "."))
+				break
+			}
+		}
+	})
+
+	lines := strings.Split(content, "\n")
+	pinnedSet := make(map[string]bool)
+	for node, edit := range edits {
+		if conflicts[node] {
+			continue
+		}
+		if err := replaceScalarAt(lines, node, edit.value); err != nil {
+			Logger.Warnf("Could not pin %s: %v", edit.image, err)
+			continue
+		}
+		pinnedSet[edit.image] = true
+	}
+	var pinned []string
+	for image := range pinnedSet {
+		pinned = append(pinned, image)
+	}
+	return strings.Join(lines, "\n"), pinned, nil
+}
+
+// pinDigestsInTemplate pins literal image strings (image: repo:tag) in a template
+func pinDigestsInTemplate(content string, digests map[string]string) (string, []string) {
+	lines := strings.Split(content, "\n")
+	var pinned []string
+	for i, line := range lines {
+		trimmed := strings.TrimPrefix(strings.TrimSpace(line), "- ")
+		if !strings.HasPrefix(trimmed, "image:") || strings.Contains(trimmed, "{{") {
+			continue
+		}
+		value := strings.TrimSpace(strings.TrimPrefix(trimmed, "image:"))
+		value = strings.Trim(value, `"'`)
+		digest, ok := digests[value]
+		if !ok {
+			continue
+		}
+		if _, _, ok := imageRepoAndTag(value); !ok {
+			continue
+		}
+		lines[i] = strings.Replace(line, value, value+"@"+digest, 1)
+		pinned = append(pinned, value)
+	}
+	return strings.Join(lines, "\n"), pinned
+}
+
+// PinDigestsInChart rewrites values.yaml and the chart templates so that every image with a
+// known digest is pinned to it. Returns the images that could not be traced to an editable source.
+func PinDigestsInChart(chartPath string, digests map[string]string) ([]string, error) {
+	pinnedSet := make(map[string]bool)
+
+	valuesPath := filepath.Join(chartPath, "values.yaml")
+	content, err := Files.ReadFile(valuesPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read values.yaml: %v", err)
+	}
+	newContent, pinned, err := pinDigestsInValues(string(content), digests)
+	if err != nil {
+		return nil, err
+	}
+	for _, img := range pinned {
+		pinnedSet[img] = true
+	}
+	if newContent != string(content) {
+		if err := Files.WriteFile(valuesPath, []byte(newContent), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write updated values.yaml: %v", err)
+		}
+		Logger.Infof("Pinned %d image(s) to digests in %s", len(pinned), valuesPath)
+	}
+
+	templateFiles, err := GetTemplateFiles(filepath.Join(chartPath, "templates"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range templateFiles {
+		content, err := Files.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template file %s: %v", path, err)
+		}
+		newContent, pinned := pinDigestsInTemplate(string(content), digests)
+		if len(pinned) == 0 {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := Files.WriteFile(path, []byte(newContent), info.Mode()); err != nil {
+			return nil, fmt.Errorf("failed to write modified template file %s: %v", path, err)
+		}
+		Logger.Infof("Pinned %v to digests in %s", pinned, path)
+		for _, img := range pinned {
+			pinnedSet[img] = true
+		}
+	}
+
+	var unpinned []string
+	for img := range digests {
+		if _, _, ok := imageRepoAndTag(img); ok && !pinnedSet[img] {
+			unpinned = append(unpinned, img)
+		}
+	}
+	return unpinned, nil
+}
+
+// pinDigests resolves the digest of every rendered image and pins the chart to them
+func pinDigests(opts Options) error {
+	images, err := ListImages(opts)
+	if err != nil {
+		return err
+	}
+	keychain, err := NewRegistryKeychain(opts.RegistryAuth, opts.LocalRepo)
+	if err != nil {
+		Logger.Errorf("failed to set up registry authentication: %v", err)
+		return err
+	}
+	digests := ResolveImageDigests(context.Background(), images, keychain)
+
+	var unresolved []string
+	for _, img := range images {
+		if _, ok := digests[img]; !ok {
+			unresolved = append(unresolved, img)
+		}
+	}
+
+	unpinned, err := PinDigestsInChart(opts.ChartPath, digests)
+	if err != nil {
+		return err
+	}
+	for _, img := range unpinned {
+		Logger.Warnf("Could not trace %s back to a values.yaml key or template to pin its digest", img)
+	}
+	if len(unresolved) > 0 {
+		return fmt.Errorf("could not resolve digests for images: %v", unresolved)
+	}
+	return nil
+}
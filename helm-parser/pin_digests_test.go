@@ -0,0 +1,147 @@
+package helm_parser
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	regname "github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	regremote "github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestPinDigestsInValues(t *testing.T) {
+	digestA := "sha256:" + strings.Repeat("a", 64)
+	digestB := "sha256:" + strings.Repeat("b", 64)
+	digestC := "sha256:" + strings.Repeat("c", 64)
+	digestD := "sha256:" + strings.Repeat("d", 64)
+
+	values := `# Bitnami style
+image:
+  registry: docker.io
+  repository: bitnami/redis
+  tag: "7.2.4" # pinned by upstream
+  digest: ""
+# Istio style
+global:
+  hub: gcr.io/istio-release
+  tag: 1.26.2
+proxy:
+  image: proxyv2
+sidecar:
+  image: quay.io/team/sidecar:v1
+metrics:
+  image:
+    repository: nginx
+    tag: '1.25'
+`
+	digests := map[string]string{
+		"docker.io/bitnami/redis:7.2.4":       digestA,
+		"quay.io/team/sidecar:v1":             digestB,
+		"nginx:1.25":                          digestC,
+		"gcr.io/istio-release/proxyv2:1.26.2": digestD,
+	}
+
+	got, pinned, err := pinDigestsInValues(values, digests)
+	if err != nil {
+		t.Fatalf("pinDigestsInValues failed: %v", err)
+	}
+
+	expectedLines := []string{
+		`  tag: "7.2.4" # pinned by upstream`,
+		`  digest: "` + digestA + `"`,
+		`  image: quay.io/team/sidecar:v1@` + digestB,
+		`    tag: '1.25@` + digestC + `'`,
+		`# Bitnami style`,
+	}
+	for _, line := range expectedLines {
+		if !containsLine(strings.Split(got, "\n"), line) {
+			t.Errorf("Expected line %q in:\n%s", line, got)
+		}
+	}
+	// The Istio hub/tag pair lives in a different mapping from the image name and cannot be pinned safely
+	if !strings.Contains(got, "  tag: 1.26.2\n") {
+		t.Errorf("Expected the global Istio tag to be left alone:\n%s", got)
+	}
+	if len(pinned) != 3 {
+		t.Errorf("Expected 3 pinned images, got %v", pinned)
+	}
+	t.Log("✓ Digests pinned in place for digest, tag and image string values")
+}
+
+func TestPinDigestsInValues_Idempotent(t *testing.T) {
+	values := `images:
+  repository: quay.io/team/app
+  tag: v1
+`
+	digests := map[string]string{
+		"quay.io/team/app:v1": "sha256:" + strings.Repeat("a", 64),
+	}
+	got, _, err := pinDigestsInValues(values, digests)
+	if err != nil {
+		t.Fatalf("pinDigestsInValues failed: %v", err)
+	}
+	if !strings.Contains(got, "  tag: v1@sha256:") {
+		t.Errorf("Expected the tag to be pinned:\n%s", got)
+	}
+
+	// An already pinned image is not pinned again
+	again, pinned, err := pinDigestsInValues(got, map[string]string{"quay.io/team/app:v1@sha256:" + strings.Repeat("a", 64): "sha256:" + strings.Repeat("a", 64)})
+	if err != nil || again != got || len(pinned) != 0 {
+		t.Errorf("Expected no changes for an already pinned image, got %v:\n%s", pinned, again)
+	}
+	t.Log("✓ Pinning is idempotent")
+}
+
+func TestPinDigestsInTemplate(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("e", 64)
+	content := `spec:
+  containers:
+    - name: app
+      image: "busybox:1.36"
+    - name: other
+      image: {{ .Values.image }}
+`
+	got, pinned := pinDigestsInTemplate(content, map[string]string{"busybox:1.36": digest})
+	if !strings.Contains(got, `image: "busybox:1.36@`+digest+`"`) {
+		t.Errorf("Expected the literal image to be pinned:\n%s", got)
+	}
+	if !strings.Contains(got, "image: {{ .Values.image }}") || len(pinned) != 1 {
+		t.Errorf("Expected only the literal image to be pinned, got %v:\n%s", pinned, got)
+	}
+	t.Log("✓ Literal template image pinned")
+}
+
+func TestResolveImageDigests(t *testing.T) {
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	img, err := random.Image(512, 1)
+	if err != nil {
+		t.Fatalf("Failed to create random image: %v", err)
+	}
+	ref, _ := regname.ParseReference(host + "/team/app:1.0")
+	if err := regremote.Write(ref, img, regremote.WithContext(context.Background())); err != nil {
+		t.Fatalf("Failed to push image: %v", err)
+	}
+	want, _ := img.Digest()
+
+	pinnedImage := host + "/team/app@sha256:" + strings.Repeat("f", 64)
+	digests := ResolveImageDigests(context.Background(), []string{host + "/team/app:1.0", host + "/team/missing:1.0", pinnedImage}, nil)
+
+	if digests[host+"/team/app:1.0"] != want.String() {
+		t.Errorf("Expected digest %s, got %s", want, digests[host+"/team/app:1.0"])
+	}
+	if _, ok := digests[host+"/team/missing:1.0"]; ok {
+		t.Error("Expected no digest for a missing image")
+	}
+	if digests[pinnedImage] != "sha256:"+strings.Repeat("f", 64) {
+		t.Errorf("Expected an already pinned image to keep its digest, got %s", digests[pinnedImage])
+	}
+	t.Log("✓ Digests resolved from the registry")
+}
@@ -0,0 +1,262 @@
+package helm_parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// PolicyGap is an injected key that a rendered workload does not carry, or carries from a
+// category whose match clause excludes it
+type PolicyGap struct {
+	Template  string // Template the workload was rendered from, e.g. testchart/templates/deployment.yaml
+	Kind      string
+	Name      string
+	Container string // Container missing the key, empty for pod keys
+	Key       string // e.g. tolerations
+	Excluded  string // Category excluding the workload whose value it carries, empty for a missing key
+}
+
+// String describes the gap for the run log
+func (g PolicyGap) String() string {
+	target := fmt.Sprintf("%s %s (%s)", g.Kind, g.Name, g.Template)
+	if g.Container != "" {
+		target += " container " + g.Container
+	}
+	if g.Excluded != "" {
+		return fmt.Sprintf("%s carries %s of %s, which excludes it", target, g.Key, g.Excluded)
+	}
+	return fmt.Sprintf("%s is missing %s", target, g.Key)
+}
+
+// blockSatisfied reports whether a rendered value already carries the injected one, i.e.
+// merging the injected value again would change nothing
+func blockSatisfied(key string, rendered, injected *yamlv3.Node, strategy MergeStrategy) bool {
+	if strategy == StrategyAppend {
+		strategy = StrategyAppendUnique
+	}
+	rendered = resolveAlias(rendered)
+	// An empty or null scalar unsets the key, which an absent or empty rendered value carries
+	if injected.Kind == yamlv3.ScalarNode && isEmptyNode(injected) && isEmptyNode(rendered) {
+		return true
+	}
+	if rendered != nil && rendered.Kind == yamlv3.ScalarNode && injected.Kind == yamlv3.ScalarNode && strategy != StrategySkipIfPresent {
+		return !isEmptyNode(rendered) && nodesEqual(rendered, injected)
+	}
+	_, changed := mergeValueNodes(key, copyNode(rendered), copyNode(injected), strategy)
+	return !changed
+}
+
+// missingKeys returns the keys of blocks that a rendered pod spec or container does not carry
+func missingKeys(spec *yamlv3.Node, blocks []InjectorBlock) []string {
+	var missing []string
+	for _, key := range blockKeys(blocks) {
+		keyBlocks := getContainerBlocksByKey(blocks, key)
+		injected := blockValue(keyBlocks, key)
+		if injected == nil {
+			continue
+		}
+		_, rendered := mappingValue(spec, key)
+		if !blockSatisfied(key, rendered, injected, keyStrategy(keyBlocks, injected)) {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// carriesValue reports whether a rendered value holds all of the injected one: its list
+// items, its map keys or its scalar
+func carriesValue(key string, rendered, injected *yamlv3.Node) bool {
+	strategy := defaultStrategy(injected)
+	if strategy == StrategySkipIfPresent {
+		strategy = StrategyMerge
+	}
+	return !isEmptyNode(injected) && blockSatisfied(key, rendered, injected, strategy)
+}
+
+// excludedKeys returns the keys of the blocks of a category that a rendered pod spec or
+// container carries although the category excludes it. Values the blocks applied to it
+// hold too are not reported.
+func excludedKeys(spec *yamlv3.Node, excluded, applied []InjectorBlock) []string {
+	var keys []string
+	for _, key := range blockKeys(excluded) {
+		injected := blockValue(getContainerBlocksByKey(excluded, key), key)
+		if injected == nil {
+			continue
+		}
+		_, rendered := mappingValue(spec, key)
+		if !carriesValue(key, rendered, injected) {
+			continue
+		}
+		if expected := blockValue(getContainerBlocksByKey(applied, key), key); expected != nil && carriesValue(key, expected, injected) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// excludedCategories returns the categories of a target enabled by profiles whose match
+// clause excludes a workload
+func (c *InjectorConfig) excludedCategories(w Workload, target string, profiles []string) []string {
+	var categories []string
+	for category := range c.Blocks {
+		sel, ok := c.selectors[category]
+		if !ok || sel.match == nil || sel.target != target || !c.enabledByProfiles(category, profiles) {
+			continue
+		}
+		if !sel.match.matchesWorkload(w) {
+			categories = append(categories, category)
+		}
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+// nodeAtPath returns the node at a dot path of a rendered document, e.g. spec.template.spec
+func nodeAtPath(root *yamlv3.Node, path string) *yamlv3.Node {
+	node := root
+	for _, key := range strings.Split(path, ".") {
+		if _, node = mappingValue(node, key); node == nil {
+			return nil
+		}
+	}
+	return resolveAlias(node)
+}
+
+// renderedWorkload reads the kind, name, labels and template of a rendered document for block selection
+func renderedWorkload(root *yamlv3.Node, template string) Workload {
+	w := Workload{Labels: make(map[string]string)}
+	if node, ok := scalarValue(root, "kind"); ok {
+		w.Kind = node.Value
+	}
+	if node := nodeAtPath(root, "metadata.name"); node != nil {
+		w.Name = node.Value
+	}
+	if labels := nodeAtPath(root, "metadata.labels"); labels != nil && labels.Kind == yamlv3.MappingNode {
+		for i := 0; i+1 < len(labels.Content); i += 2 {
+			w.Labels[labels.Content[i].Value] = labels.Content[i+1].Value
+		}
+	}
+	// Templates are matched relative to the chart, without the chart name Helm puts first
+	if _, rel, ok := strings.Cut(template, "/"); ok {
+		w.Template = rel
+	}
+	return w
+}
+
+// VerifyInjectedBlocks checks that every pod template of a rendered manifest carries the
+// blocks of the categories enabled by profiles and of the categories that match it, and
+// returns the keys each workload and container is missing. Keys a workload or container
+// carries from a category that excludes it are returned too.
+func VerifyInjectedBlocks(manifest string, config *InjectorConfig, profiles []string) ([]PolicyGap, error) {
+	categories, err := config.enabledCategories(profiles)
+	if err != nil {
+		return nil, err
+	}
+	blocks := config.Blocks
+
+	var gaps []PolicyGap
+	for i, doc := range splitDocuments(manifest) {
+		template := ""
+		for _, line := range strings.Split(doc, "\n") {
+			if strings.HasPrefix(line, manifestSourcePrefix) {
+				template = strings.TrimSpace(strings.TrimPrefix(line, manifestSourcePrefix))
+				break
+			}
+		}
+		root, err := parseValuesNode(doc)
+		if err != nil {
+			Logger.Warnf("skipping document %d due to yaml unmarshal error: %v", i, err)
+			continue
+		}
+		w := renderedWorkload(root, template)
+		kind, ok := lookupWorkloadKind(w.Kind)
+		if !ok {
+			continue
+		}
+		if apiVersion, ok := scalarValue(root, "apiVersion"); ok && kind.APIVersion != "" && apiVersion.Value != kind.APIVersion {
+			continue
+		}
+
+		podBlocks := categoryBlocks(blocks, categories[TargetPods])
+		for _, category := range config.selectedCategories(w, TargetPods, profiles) {
+			podBlocks = append(podBlocks, blocks[category]...)
+		}
+		gap := PolicyGap{Template: template, Kind: w.Kind, Name: w.Name}
+		var containerLists []string
+		for _, p := range kind.PodSpecs {
+			spec := nodeAtPath(root, p)
+			if spec == nil {
+				continue
+			}
+			for _, key := range missingKeys(spec, podBlocks) {
+				gap.Key = key
+				gaps = append(gaps, gap)
+			}
+			for _, category := range config.excludedCategories(w, TargetPods, profiles) {
+				for _, key := range excludedKeys(spec, blocks[category], podBlocks) {
+					gaps = append(gaps, PolicyGap{Template: template, Kind: w.Kind, Name: w.Name, Key: key, Excluded: category})
+				}
+			}
+			containerLists = append(containerLists, p+".containers", p+".initContainers")
+		}
+		containerLists = append(containerLists, kind.Containers...)
+
+		for _, list := range containerLists {
+			target := TargetContainers
+			if strings.HasSuffix(list, ".initContainers") {
+				target = TargetInitContainers
+			}
+			items := nodeAtPath(root, list)
+			if items == nil || items.Kind != yamlv3.SequenceNode {
+				continue
+			}
+			for _, container := range items.Content {
+				name, _ := scalarValue(container, "name")
+				if name == nil {
+					continue
+				}
+				containerBlocks := categoryBlocks(blocks, categories[target])
+				excluded := config.excludedCategories(w, target, profiles)
+				for _, category := range config.selectedCategories(w, target, profiles) {
+					if matchesAny(config.selectors[category].match.Containers, name.Value) {
+						containerBlocks = append(containerBlocks, blocks[category]...)
+					} else {
+						excluded = append(excluded, category)
+					}
+				}
+				for _, key := range missingKeys(container, containerBlocks) {
+					gaps = append(gaps, PolicyGap{Template: template, Kind: w.Kind, Name: w.Name, Container: name.Value, Key: key})
+				}
+				sort.Strings(excluded)
+				for _, category := range excluded {
+					for _, key := range excludedKeys(container, blocks[category], containerBlocks) {
+						gaps = append(gaps, PolicyGap{Template: template, Kind: w.Kind, Name: w.Name, Container: name.Value, Key: key, Excluded: category})
+					}
+				}
+			}
+		}
+	}
+	return gaps, nil
+}
+
+// verifyInjectedBlocks checks the rendered chart against the injection blocks the chart
+// was processed with, and fails when a workload is missing any of them or carries blocks
+// of a category that excludes it
+func verifyInjectedBlocks(manifest string, config *InjectorConfig, profiles []string) error {
+	gaps, err := VerifyInjectedBlocks(manifest, config, profiles)
+	if err != nil {
+		return err
+	}
+	for _, gap := range gaps {
+		Logger.Errorf("Policy check: %s", gap)
+	}
+	if len(gaps) > 0 {
+		return fmt.Errorf("policy check failed: %d injected keys missing from or misplaced on the rendered workloads", len(gaps))
+	}
+	Logger.Infof("Policy check passed: every rendered workload carries the injected blocks")
+	return nil
+}
@@ -0,0 +1,283 @@
+package helm_parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+const policyTestBlocks = `allPods:
+- priorityClassName: platform-default
+- tolerations:
+  - key: platform/dedicated
+    operator: Exists
+allContainers:
+- envFrom:
+  - configMapRef:
+      name: platform-env
+allInitContainers: []
+agentContainers:
+  match:
+    kinds: [DaemonSet]
+    containers: [agent]
+  blocks:
+  - securityContext:
+      privileged: false
+`
+
+const policyTestManifest = `---
+# Source: testchart/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+  - port: 80
+---
+# Source: testchart/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      priorityClassName: platform-default
+      tolerations:
+      - key: other
+        operator: Exists
+      - operator: Exists
+        key: platform/dedicated
+      containers:
+      - name: web
+        envFrom:
+        - configMapRef:
+            name: platform-env
+---
+# Source: testchart/templates/daemonset.yaml
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: agent
+spec:
+  template:
+    spec:
+      priorityClassName: ""
+      tolerations: []
+      initContainers:
+      - name: setup
+      containers:
+      - name: agent
+        envFrom:
+        - configMapRef:
+            name: platform-env
+      - name: exporter
+`
+
+func TestVerifyInjectedBlocks(t *testing.T) {
+	config := loadTestConfig(t, policyTestBlocks)
+	gaps, err := VerifyInjectedBlocks(policyTestManifest, config, nil)
+	if err != nil {
+		t.Fatalf("VerifyInjectedBlocks failed: %v", err)
+	}
+	var got []string
+	for _, gap := range gaps {
+		got = append(got, gap.String())
+	}
+	want := []string{
+		"DaemonSet agent (testchart/templates/daemonset.yaml) is missing priorityClassName",
+		"DaemonSet agent (testchart/templates/daemonset.yaml) is missing tolerations",
+		"DaemonSet agent (testchart/templates/daemonset.yaml) container agent is missing securityContext",
+		"DaemonSet agent (testchart/templates/daemonset.yaml) container exporter is missing envFrom",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected gaps:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+	t.Log("✓ Rendered workloads checked against the injected blocks")
+}
+
+func TestVerifyInjectedBlocks_ExcludedWorkloads(t *testing.T) {
+	config := loadTestConfig(t, policyTestBlocks+`agentPods:
+  match:
+    kinds: [DaemonSet]
+  blocks:
+  - tolerations:
+    - key: platform/dedicated
+      operator: Exists
+  - nodeSelector:
+      pool: agents
+`)
+	manifest := `---
+# Source: testchart/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      priorityClassName: platform-default
+      nodeSelector:
+        pool: agents
+      tolerations:
+      - key: platform/dedicated
+        operator: Exists
+      containers:
+      - name: web
+        envFrom:
+        - configMapRef:
+            name: platform-env
+        securityContext:
+          privileged: false
+---
+# Source: testchart/templates/daemonset.yaml
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: agent
+spec:
+  template:
+    spec:
+      priorityClassName: platform-default
+      nodeSelector:
+        pool: agents
+      tolerations:
+      - key: platform/dedicated
+        operator: Exists
+      containers:
+      - name: agent
+        envFrom:
+        - configMapRef:
+            name: platform-env
+        securityContext:
+          privileged: false
+      - name: exporter
+        envFrom:
+        - configMapRef:
+            name: platform-env
+        securityContext:
+          privileged: false
+          runAsNonRoot: true
+`
+	gaps, err := VerifyInjectedBlocks(manifest, config, nil)
+	if err != nil {
+		t.Fatalf("VerifyInjectedBlocks failed: %v", err)
+	}
+	var got []string
+	for _, gap := range gaps {
+		got = append(got, gap.String())
+	}
+	// The tolerations come from allPods as well, so they are expected on the Deployment
+	want := []string{
+		"Deployment web (testchart/templates/deployment.yaml) carries nodeSelector of agentPods, which excludes it",
+		"Deployment web (testchart/templates/deployment.yaml) container web carries securityContext of agentContainers, which excludes it",
+		"DaemonSet agent (testchart/templates/daemonset.yaml) container exporter carries securityContext of agentContainers, which excludes it",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected gaps:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+	t.Log("✓ Blocks found on workloads their category excludes reported")
+}
+
+func TestBlockSatisfied_EmptyScalar(t *testing.T) {
+	value := func(yaml string) *yamlv3.Node {
+		root, err := parseValuesNode(yaml)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", yaml, err)
+		}
+		_, node := mappingValue(root, "priorityClassName")
+		return node
+	}
+	tests := []struct {
+		injected string
+		rendered string
+		strategy MergeStrategy
+		want     bool
+	}{
+		{`priorityClassName: ""`, "", StrategyReplace, true},
+		{`priorityClassName: ""`, `priorityClassName: ""`, StrategyReplace, true},
+		{`priorityClassName: null`, "", StrategyReplace, true},
+		{`priorityClassName: null`, "priorityClassName:", StrategyReplace, true},
+		{`priorityClassName: ""`, "priorityClassName: app", StrategyReplace, false},
+		{`priorityClassName: null`, "priorityClassName: app", StrategyReplace, false},
+		{`priorityClassName: null`, "priorityClassName: app", StrategySkipIfPresent, true},
+	}
+	for _, tt := range tests {
+		if got := blockSatisfied("priorityClassName", value(tt.rendered), value(tt.injected), tt.strategy); got != tt.want {
+			t.Errorf("%s with %q rendered (%s): expected satisfied=%v", tt.injected, tt.rendered, tt.strategy, tt.want)
+		}
+	}
+	t.Log("✓ Empty injected scalars satisfied by absent or empty rendered values")
+}
+
+func TestVerifyInjectedBlocks_RenderedChart(t *testing.T) {
+	blocksPath := filepath.Join(t.TempDir(), "inject-blocks.yaml")
+	if err := os.WriteFile(blocksPath, []byte(policyTestBlocks), 0644); err != nil {
+		t.Fatalf("Failed to write blocks: %v", err)
+	}
+	deployment := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      {{- with .Values.tolerations }}
+      tolerations:
+        {{- toYaml . | nindent 8 }}
+      {{- end }}
+      containers:
+      - name: web
+        image: nginx:1.27
+`
+	// The template only tests envFrom, so values path injection never reaches the pods
+	unused := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: worker
+spec:
+  template:
+    spec:
+      containers:
+      - name: worker
+        image: busybox:1.36
+        {{- if .Values.envFrom }}
+        args: [--env-from-values]
+        {{- end }}
+`
+	tests := []struct {
+		name      string
+		templates map[string]string
+		want      []string
+	}{
+		{"injected", map[string]string{"deployment.yaml": deployment}, nil},
+		{"missed", map[string]string{"deployment.yaml": deployment, "worker.yaml": unused}, []string{"Deployment worker (testchart/templates/worker.yaml) container worker is missing envFrom"}},
+	}
+	for _, tt := range tests {
+		chartDir := writeTestChart(t, "tolerations: []\nenvFrom: []\n", tt.templates)
+		useFileStore(t, NewFileStore(false))
+		if err := ProcessTemplates(chartDir, nil, blocksPath, nil, "", RenderOptions{}); err != nil {
+			t.Fatalf("%s: ProcessTemplates failed: %v", tt.name, err)
+		}
+		rel, err := renderChartFromValues(chartDir, RenderOptions{})
+		if err != nil {
+			t.Fatalf("%s: render failed: %v", tt.name, err)
+		}
+		gaps, err := VerifyInjectedBlocks(rel.Manifest, loadTestConfig(t, policyTestBlocks), nil)
+		if err != nil {
+			t.Fatalf("%s: VerifyInjectedBlocks failed: %v", tt.name, err)
+		}
+		var got []string
+		for _, gap := range gaps {
+			got = append(got, gap.String())
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: expected gaps %v, got %v\n%s", tt.name, tt.want, got, rel.Manifest)
+		}
+	}
+	t.Log("✓ Keys missed by values path injection reported after rendering")
+}
@@ -2,20 +2,21 @@ package helm_parser
 
 import (
 	"bufio"
-	"context"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/distribution/reference"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
-	_ "helm.sh/helm/v3/pkg/chart"
+	yamlv3 "gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
-	"helm.sh/helm/v3/pkg/chartutil"
 	"helm.sh/helm/v3/pkg/engine"
 	"helm.sh/helm/v3/pkg/release"
 )
@@ -43,7 +44,7 @@ func LoadValues(chartPath string) (map[interface{}]interface{}, error) {
 	}
 	// Load values.yaml
 	valuesFilePath := filepath.Join(chartPath, "values.yaml")
-	valuesFile, err := os.ReadFile(valuesFilePath)
+	valuesFile, err := Files.ReadFile(valuesFilePath)
 	if err != nil {
 		log.Printf("Error reading values.yaml: %v", err)
 		return nil, err
@@ -66,32 +67,32 @@ func checkRegistryAttr(key interface{}) bool {
 			return true
 		}
 	}
+	// Extra keys from the registry rules file
+	for _, attr := range RewriteRules.registryKeys() {
+		if key == attr {
+			return true
+		}
+	}
 	return false
 }
 
 // renderChartLocal renders a chart completely locally using Helm's engine and chartutil
 // This does not contact a Kubernetes API server.
-func renderChartLocal(chartPath string, values map[string]interface{}) (*release.Release, error) {
+func renderChartLocal(chartPath string, values map[string]interface{}, render RenderOptions) (*release.Release, error) {
 	chart, err := loader.Load(chartPath)
 	if err != nil {
 		Logger.Errorf("chart loader.Load failed: %v", err)
 		return nil, err
 	}
+	// In dry-run mode templates may have staged edits that are not on disk yet
+	applyStagedTemplates(chart, chartPath)
 
-	// Prepare release options for templating, use default name and namespace
-	relOpts := chartutil.ReleaseOptions{
-		Name:      "test",
-		Namespace: "default",
+	// Render with the given values in place of the values.yaml on disk
+	if values != nil {
+		chart.Values = values
 	}
-
 	// Create render values (chart values merged with release options and capabilities)
-	//type Capabilities struct {
-	//     KubeVersion KubeVersion      // Kubernetes version info
-	//     APIVersions VersionSet       // Available API versions (e.g., "apps/v1", "batch/v1")
-	//     HelmVersion HelmVersion      // Helm version info
-	// }
-	//
-	renderValues, err := chartutil.ToRenderValues(chart, values, relOpts, chartutil.DefaultCapabilities)
+	renderValues, err := render.renderValues(chart)
 	if err != nil {
 		Logger.Errorf("chartutil.ToRenderValues failed: %v\n values: %s", err, values)
 		return nil, err
@@ -103,17 +104,25 @@ func renderChartLocal(chartPath string, values map[string]interface{}) (*release
 		return nil, err
 	}
 
-	// Combine rendered templates into a single manifest string (similar to Helm install dry-run)
+	// Combine rendered templates into a single manifest string (similar to Helm install dry-run).
+	// Templates are written in a stable order and each document is annotated with the
+	// template it came from, so images can be traced back to their source.
+	names := make([]string, 0, len(rendered))
+	for name := range rendered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 	var sb strings.Builder
-	for _, v := range rendered {
-		sb.WriteString(v)
-		// ensure YAML separator between resources
-		sb.WriteString("\n---\n")
+	for _, name := range names {
+		for _, doc := range splitDocuments(rendered[name]) {
+			fmt.Fprintf(&sb, "---\n%s%s\n%s\n", manifestSourcePrefix, name, doc)
+		}
 	}
 
+	relOpts := render.releaseOptions()
 	rel := &release.Release{
-		Name:      "test",
-		Namespace: "default",
+		Name:      relOpts.Name,
+		Namespace: relOpts.Namespace,
 		Manifest:  sb.String(),
 		Chart:     chart,
 	}
@@ -121,6 +130,21 @@ func renderChartLocal(chartPath string, values map[string]interface{}) (*release
 	return rel, nil
 }
 
+// applyStagedTemplates replaces the loaded template data with any content staged in Files,
+// so a dry run renders the chart as it would look after the edits
+func applyStagedTemplates(ch *chart.Chart, chartPath string) {
+	staged := Files.StagedFiles()
+	if len(staged) == 0 {
+		return
+	}
+	root := storeKey(chartPath)
+	for _, tmpl := range ch.Templates {
+		if content, ok := staged[filepath.Join(root, filepath.FromSlash(tmpl.Name))]; ok {
+			tmpl.Data = content
+		}
+	}
+}
+
 func convertMapI2MapS(i interface{}) interface{} {
 	switch x := i.(type) {
 	case map[interface{}]interface{}:
@@ -146,7 +170,7 @@ func UpdateRegistryInValuesFile(chartPath string, newRepo string) error {
 	valuesPath := filepath.Join(chartPath, "values.yaml")
 
 	// Read the values file
-	content, err := os.ReadFile(valuesPath)
+	content, err := Files.ReadFile(valuesPath)
 	if err != nil {
 		return fmt.Errorf("failed to read values.yaml: %v", err)
 	}
@@ -164,7 +188,7 @@ func UpdateRegistryInValuesFile(chartPath string, newRepo string) error {
 	modifiedContent, modified := replaceRegistryInText(string(content), newRegDomain, newRegPath)
 
 	// Write back to values.yaml file
-	if err := os.WriteFile(valuesPath, []byte(modifiedContent), 0644); err != nil {
+	if err := Files.WriteFile(valuesPath, []byte(modifiedContent), 0644); err != nil {
 		return fmt.Errorf("failed to write updated values.yaml: %v", err)
 	}
 	if modified {
@@ -173,92 +197,154 @@ func UpdateRegistryInValuesFile(chartPath string, newRepo string) error {
 	return nil
 }
 
-// replaceRegistryInText updates registry attribute values in YAML text while preserving format
+// replaceRegistryInText updates registry attribute values in YAML text while preserving format.
+// Image maps are detected structurally: a registry + repository pair (Bitnami style) is
+// rewritten as a unit, hub/repository keys are rewritten as repository prefixes, and an
+// image: repo:tag string is rewritten with its tag kept. Only the edited scalars change,
+// so comments, quoting and key order are preserved.
 func replaceRegistryInText(content string, newRegDomain string, newRegPath string) (string, bool) {
+	root, err := parseValuesNode(content)
+	if err != nil {
+		Logger.Errorf("Could not parse values.yaml to update registries: %v", err)
+		return content, false
+	}
 	lines := strings.Split(content, "\n")
-	// result will hold modified lines to be written back to values.yaml
-	var result []string
-	var regpathKey bool
-
-	for i := 0; i < len(lines); i++ {
-		line := lines[i]
-		trimmed := strings.TrimSpace(line)
-		regpathKey = false
-
-		// Skip empty lines and comments
-		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
-			result = append(result, line)
-			continue
+	modified := false
+
+	// update rewrites a single scalar, reporting what it did
+	update := func(path []string, node *yamlv3.Node, newValue string) {
+		Logger.Infof("Updating %s from %s to %s", strings.Join(path, "."), node.Value, newValue)
+		if err := replaceScalarAt(lines, node, newValue); err != nil {
+			Logger.Warnf("Could not update %s: %v", strings.Join(path, "."), err)
+			return
 		}
-
-		// Check if this line contains a registry attribute
-		if strings.Contains(trimmed, ":") {
-			parts := strings.SplitN(trimmed, ":", 2)
-			if len(parts) == 2 {
-				key := strings.TrimSpace(parts[0])
-				value := strings.TrimSpace(parts[1])
-
-				// Check if key is a known registry attribute
-				if checkRegistryAttr(key) && value != "" && value != `""` {
-					regpathKey = true
-					// Remove quotes if present
-					value = strings.Trim(value, `"`)
-
-					// Parse targget registry value
-					targetPrefix := path.Join(newRegDomain, newRegPath)
-					// Parse existing registry value
-					regNamed, err := reference.ParseNormalizedNamed(value)
-					if err != nil {
-						Logger.Warnf("Could not parse registry value %s: %v", value, err)
-						result = append(result, line)
-						continue
-					}
-					//Check if we are already using the target registry
-					Logger.Infof("Checking existing registry value %s against target prefix %s", value, targetPrefix)
-					if strings.HasPrefix(value, targetPrefix) {
-						Logger.Infof("Skipping %s - already using target registry %s", key, targetPrefix)
-						result = append(result, line)
-						continue
-					}
-					// Extract existing registry components
-					regPath := reference.Path(regNamed)
-					// Remove "library/" prefix for Docker Hub official images
-					regPath = strings.TrimPrefix(regPath, "library/")
-					regDomain := reference.Domain(regNamed)
-
-					// Build new registry value
-					var newRepoJoined string
-					if regDomain != newRegDomain {
-						newRepoJoined = newRegDomain
-					} else {
-						newRepoJoined = regDomain
-					}
-
-					if regPath != newRegPath {
-						// Maintain compatibility with artifactory repo structures
-						newRepoJoined = path.Join(newRepoJoined, newRegPath, regDomain, regPath)
-					} else {
-						newRepoJoined = path.Join(newRepoJoined, regPath)
-					}
-
-					Logger.Infof("Updating %s from %s to %s", key, value, newRepoJoined)
-
-					// Reconstruct the line preserving indentation
-					indent := GetIndentation(line)
-					newLine := strings.Repeat(" ", indent) + key + ": " + newRepoJoined
-					result = append(result, newLine)
-					continue
+		modified = true
+	}
+	targetPrefix := path.Join(newRegDomain, newRegPath)
+
+	walkMappings(root, nil, func(mapPath []string, mapping *yamlv3.Node) {
+		handled := make(map[*yamlv3.Node]bool)
+
+		// Bitnami style image map: registry + repository form one image
+		regNode, okReg := scalarValue(mapping, "registry")
+		repoNode, okRepo := scalarValue(mapping, "repository")
+		if okReg && okRepo && regNode.Value != "" && repoNode.Value != "" {
+			handled[regNode], handled[repoNode] = true, true
+			image := strings.TrimSuffix(regNode.Value, "/") + "/" + repoNode.Value
+			Logger.Infof("Checking existing registry value %s against target prefix %s", image, targetPrefix)
+			newImage, alreadyTarget, err := rewriteImageString(image, newRegDomain, newRegPath)
+			if err != nil {
+				Logger.Warnf("Could not parse image %s at %s: %v", image, strings.Join(mapPath, "."), err)
+			} else if alreadyTarget {
+				Logger.Infof("Skipping %s - already using target registry %s", strings.Join(mapPath, "."), targetPrefix)
+			} else {
+				newRegistry, newRepository, _ := strings.Cut(newImage, "/")
+				if newRegistry != regNode.Value {
+					update(append(mapPath, "registry"), regNode, newRegistry)
+				}
+				if newRepository != repoNode.Value {
+					update(append(mapPath, "repository"), repoNode, newRepository)
 				}
 			}
 		}
 
-		result = append(result, line)
-	}
-	if !regpathKey {
+		for i := 0; i+1 < len(mapping.Content); i += 2 {
+			key := mapping.Content[i].Value
+			valueNode := resolveAlias(mapping.Content[i+1])
+			if handled[valueNode] || valueNode.Kind != yamlv3.ScalarNode || valueNode.Value == "" {
+				continue
+			}
+			keyPath := append(append([]string{}, mapPath...), key)
+			value := valueNode.Value
+
+			switch {
+			case key == "registry" && !strings.Contains(value, "/"):
+				// A bare registry domain without a repository next to it cannot be rewritten on its own
+				Logger.Warnf("Skipping %s - registry %s has no repository in the same map", strings.Join(keyPath, "."), value)
+				continue
+			case checkRegistryAttr(key):
+			case key == "image" && isImageString(value):
+				// Plain image string, e.g. image: docker.io/nginx:1.25
+			default:
+				continue
+			}
+
+			Logger.Infof("Checking existing registry value %s against target prefix %s", value, targetPrefix)
+			newValue, alreadyTarget, err := rewriteImageString(value, newRegDomain, newRegPath)
+			if err != nil {
+				Logger.Warnf("Could not parse registry value %s: %v", value, err)
+				continue
+			}
+			if alreadyTarget {
+				Logger.Infof("Skipping %s - already using target registry %s", key, targetPrefix)
+				continue
+			}
+			update(keyPath, valueNode, newValue)
+		}
+	})
+	if !modified {
 		Logger.Infof("No registry attribute keys found to update in values.yaml")
 	}
 
-	return strings.Join(result, "\n"), regpathKey
+	return strings.Join(lines, "\n"), modified
+}
+
+// isImageString reports whether an image: value is a full image reference rather than just
+// an image name (Istio uses image: pilot together with hub and tag)
+func isImageString(value string) bool {
+	named, err := reference.ParseNormalizedNamed(value)
+	if err != nil {
+		return false
+	}
+	_, tagged := named.(reference.Tagged)
+	_, digested := named.(reference.Digested)
+	return tagged || digested || hasRegistryDomain(value)
+}
+
+// rewriteRegistryValue maps an image repository to its location under the local repository:
+// <newDomain>/<newPath>/<oldDomain>/<oldPath>. This keeps compatibility with artifactory remote
+// repo structures, unless a rule in RewriteRules matches. alreadyTarget is true when the value
+// already points at the local repository or a rule says to keep it as is.
+func rewriteRegistryValue(value string, newRegDomain string, newRegPath string) (string, bool, error) {
+	// Parse targget registry value
+	targetPrefix := path.Join(newRegDomain, newRegPath)
+	// Parse existing registry value
+	regNamed, err := reference.ParseNormalizedNamed(value)
+	if err != nil {
+		return value, false, err
+	}
+	//Check if we are already using the target registry
+	if strings.HasPrefix(value, targetPrefix) || RewriteRules.alreadyRewritten(value) {
+		return value, true, nil
+	}
+	// Rewrite rules take precedence over the default mapping
+	if newRepo, keep, ok, err := applyRegistryRule(regNamed); ok {
+		if err != nil || keep {
+			return value, keep, err
+		}
+		return newRepo, false, nil
+	}
+	// Extract existing registry components
+	regPath := reference.Path(regNamed)
+	// Remove "library/" prefix for Docker Hub official images
+	regPath = strings.TrimPrefix(regPath, "library/")
+	regDomain := reference.Domain(regNamed)
+
+	// Build new registry value
+	var newRepoJoined string
+	if regDomain != newRegDomain {
+		newRepoJoined = newRegDomain
+	} else {
+		newRepoJoined = regDomain
+	}
+
+	if regPath != newRegPath {
+		// Maintain compatibility with artifactory repo structures
+		newRepoJoined = path.Join(newRepoJoined, newRegPath, regDomain, regPath)
+	} else {
+		newRepoJoined = path.Join(newRepoJoined, regPath)
+	}
+	return newRepoJoined, false, nil
 }
 
 // splitDocuments splits a YAML manifest into documents using lines that are exactly
@@ -298,119 +384,105 @@ func splitDocuments(manifest string) []string {
 	return docs
 }
 
-func ProcessChart(chartPath string, localRepo string, customYaml string, criticalDs bool, controlPlane bool, systemCritical string, dryRun bool, verbose bool) error {
-	// Verify if the customYaml file exists
-	if _, err := os.Stat(customYaml); os.IsNotExist(err) {
-		Logger.Errorf("Custom YAML file %s does not exist: %v", customYaml, err)
-		return err
-	}
-
-	// Backup values.yaml before modifying
-	if err := backupValuesFile(chartPath); err != nil {
-		Logger.Errorf("failed to backup values.yaml: %v", err)
+// ProcessChart runs every phase against the chart: backup, registry rewrite, image check,
+// block injection and a final render to validate the result and check the injected blocks
+func ProcessChart(opts Options) error {
+	if err := startRun(opts); err != nil {
 		return err
 	}
-
-	// First load values.yaml from chart
-	values, err := LoadValues(chartPath)
-	if err != nil {
-		Logger.Fatalf("failed to load values: %v", err)
+	// Verify if the customYaml file exists
+	if err := checkCustomYaml(opts.CustomYaml); err != nil {
 		return err
 	}
 	// Next update the registry names in values to our localRepo and render the chart
-	err = UpdateRegistryInValuesFile(chartPath, localRepo)
-	if err != nil {
-		Logger.Fatalf("failed to update registry name: %v", err)
+	if err := updateRegistry(opts); err != nil {
 		return err
 	}
-	// After updating values.yaml, render the chart locally with updated values
-	rel, err := renderChartFromValues(chartPath)
-	if err != nil {
-		Logger.Errorf("failed to render chart from updated values: %v", err)
-		return err
-	}
-	// Parse rendered manifest and extract images from pod specs so that we can check if they exist
-	images, err := ExtractImagesFromManifest(rel.Manifest)
-	if err != nil {
-		Logger.Errorf("failed to extract images from manifest: %v", err)
-		return err
-	}
-	Logger.Infof("rendered images:")
-	for _, img := range images {
-		Logger.Infof("%s", img)
-	}
-	// Check if images exist in our registry
-	imageExistMap, err := CheckImagesExist(context.Background(), images, "", "")
-	if err != nil {
-		Logger.Errorf("failed to check images existence: %v", err)
-	}
-	// Log missing images
-	failFatal := false
-
-	for _, img := range images {
-		if exists, ok := imageExistMap[img]; ok {
-			if !exists {
-				Logger.Errorf("Image does not exist in registry: %s", img)
-				failFatal = true
-			} else {
-				// DEBUG
-				Logger.Infof("Image exists in registry: %s", img)
-			}
+	// After updating values.yaml, render the chart and check the images exist in our registry
+	if err := verifyImages(opts); err != nil {
+		if !opts.DryRun {
+			return err
 		}
+		Logger.Errorf("%v", err)
 	}
-	if failFatal {
-		if !dryRun {
-			return fmt.Errorf("one or more images do not exist in registry")
+	// Pin the verified images to their digests so the imported chart is reproducible
+	if opts.PinDigests {
+		if err := pinDigests(opts); err != nil {
+			Logger.Errorf("failed to pin image digests: %v", err)
+			return err
 		}
-		Logger.Errorf("one or more images do not exist in registry")
 	}
 	// Next we process the chart teamplates to inject other inline injector blocks
-	// Process templates to inject inline injector container spec
-	err = ProcessTemplates(chartPath, values, customYaml, criticalDs, controlPlane, systemCritical)
+	config, err := injectBlocks(opts)
 	if err != nil {
-		Logger.Errorf("failed to process templates: %v", err)
 		return err
 	}
 
 	// Validate by rendering the chart again after injection
-	// Render the chart locally with updated values
-	relUpdated, err := renderChartFromValues(chartPath)
+	// Render the chart locally with updated values, once per values overlay
+	rendered, err := renderOverlays(opts)
 	if err != nil {
 		Logger.Errorf("failed to render chart from updated values: %v", err)
 		return err
 	}
 
-	if verbose {
-		Logger.Infof("Rendered manifest after injection:\n%s", relUpdated.Manifest)
+	for _, overlay := range rendered {
+		if opts.Verbose {
+			Logger.Infof("Rendered manifest with %s after injection:\n%s", overlay.name, overlay.manifest)
+		}
+		// Check that every rendered pod template carries the blocks we injected, values path
+		// injection misses keys the templates never read
+		if err := verifyInjectedBlocks(overlay.manifest, config, opts.Profiles); err != nil {
+			err = fmt.Errorf("%s: %v", overlay.name, err)
+			if !opts.DryRun {
+				return err
+			}
+			Logger.Errorf("%v", err)
+		}
 	}
 
-	return nil
+	return finishRun(opts)
 }
 
-func backupValuesFile(chartPath string) error {
+// backupChart backs up values.yaml and has the file store back up every other chart file
+// before it is first modified, e.g. the templates edited by injection
+func backupChart(chartPath string) error {
 	valuesPath := filepath.Join(chartPath, "values.yaml")
-	backupPath := valuesPath + ".backup"
-
 	if _, err := os.Stat(valuesPath); os.IsNotExist(err) {
 		return fmt.Errorf("values.yaml does not exist at %s", valuesPath)
 	}
+	Files.BackupChart(chartPath)
+	return Files.backup(valuesPath)
+}
 
-	if _, err := os.Stat(backupPath); err == nil {
-		return nil
-	}
-
-	input, err := os.ReadFile(valuesPath)
-	if err != nil {
-		return fmt.Errorf("failed to read values.yaml for backup: %w", err)
-	}
-
-	err = os.WriteFile(backupPath, input, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write values.yaml backup: %w", err)
+// restoreChart writes back every file backed up in the chart's backup directory. Charts
+// backed up by earlier versions only have values.yaml.backup.
+func restoreChart(chartPath string) error {
+	backupDir := filepath.Join(chartPath, backupDirName)
+	if _, err := os.Stat(backupDir); os.IsNotExist(err) {
+		return restoreValuesFile(chartPath)
 	}
-
-	Logger.Infof("Backed up values.yaml to %s", backupPath)
-	return nil
+	return filepath.WalkDir(backupDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(backupDir, path)
+		if err != nil {
+			return err
+		}
+		input, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read backup of %s: %w", rel, err)
+		}
+		if err := Files.WriteFile(filepath.Join(chartPath, rel), input, 0644); err != nil {
+			return fmt.Errorf("failed to restore %s from backup: %w", rel, err)
+		}
+		Logger.Infof("Restored %s from %s", rel, path)
+		return nil
+	})
 }
 
 func restoreValuesFile(chartPath string) error {
@@ -422,7 +494,7 @@ func restoreValuesFile(chartPath string) error {
 		return fmt.Errorf("failed to read values.yaml backup: %w", err)
 	}
 
-	err = os.WriteFile(valuesPath, input, 0644)
+	err = Files.WriteFile(valuesPath, input, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to restore values.yaml from backup: %w", err)
 	}
@@ -0,0 +1,99 @@
+package helm_parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReplaceRegistryInText_ImageStyles(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   string
+		expected []string
+	}{
+		{
+			name: "Bitnami registry and repository",
+			values: `image:
+  registry: docker.io
+  repository: bitnami/redis
+  tag: 7.2.4
+  digest: ""
+`,
+			expected: []string{
+				"  registry: registry.example.com",
+				"  repository: ext/docker.io/bitnami/redis",
+				"  tag: 7.2.4",
+			},
+		},
+		{
+			name: "Istio hub and tag",
+			values: `global:
+  hub: gcr.io/istio-release # upstream hub
+  tag: 1.26.2
+pilot:
+  image: pilot
+`,
+			expected: []string{
+				"  hub: registry.example.com/ext/gcr.io/istio-release # upstream hub",
+				"  tag: 1.26.2",
+				"  image: pilot",
+			},
+		},
+		{
+			name: "plain image string",
+			values: `sidecar:
+  image: "quay.io/team/sidecar:v1"
+busybox:
+  image: busybox@sha256:` + strings.Repeat("a", 64) + `
+`,
+			expected: []string{
+				`  image: "registry.example.com/ext/quay.io/team/sidecar:v1"`,
+				"  image: registry.example.com/ext/docker.io/busybox@sha256:" + strings.Repeat("a", 64),
+			},
+		},
+		{
+			name: "repository only",
+			values: `controller:
+  image:
+    repository: quay.io/jetstack/cert-manager-controller
+    tag: v1.14.3
+`,
+			expected: []string{
+				"    repository: registry.example.com/ext/quay.io/jetstack/cert-manager-controller",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, modified := replaceRegistryInText(tt.values, "registry.example.com", "ext")
+			if !modified {
+				t.Errorf("Expected values to be modified")
+			}
+			lines := strings.Split(got, "\n")
+			for _, line := range tt.expected {
+				if !containsLine(lines, line) {
+					t.Errorf("Expected line %q in:\n%s", line, got)
+				}
+			}
+
+			// A second pass must leave the rewritten values alone
+			again, modified := replaceRegistryInText(got, "registry.example.com", "ext")
+			if modified || again != got {
+				t.Errorf("Expected no changes on the second pass, got:\n%s", again)
+			}
+			t.Logf("✓ %s rewritten as a unit", tt.name)
+		})
+	}
+}
+
+func TestReplaceRegistryInText_BareRegistryIsSkipped(t *testing.T) {
+	values := `global:
+  imageRegistry: ""
+  registry: docker.io
+`
+	got, modified := replaceRegistryInText(values, "registry.example.com", "ext")
+	if modified || got != values {
+		t.Errorf("Expected a bare registry without a repository to be left alone, got:\n%s", got)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 
 	"gopkg.in/yaml.v2"
@@ -14,21 +16,51 @@ import (
 // adds inline injector specs to both the pod and container levels. It reads the template file, parses it as text,
 // and locates where the pod spec and container specs are defined, then adds the appropriate inline injector blocks.
 // If templates reference .Values, it injects into values.yaml instead of directly into templates.
-func ProcessTemplates(chartDir string, values map[any]any, customYaml string, criticalDs bool, controlPlane bool, systemCritical string) error {
+// profiles selects the profiles of the injector blocks whose categories are injected too.
+// Templated kinds are evaluated for the release and cluster of render.
+func ProcessTemplates(chartDir string, values map[any]any, customYaml string, profiles []string, systemCritical string, render RenderOptions) error {
 	// First load custom injector blocks once for all templates
-	blocks, err := loadInjectorBlocks(customYaml, systemCritical)
+	config, err := loadInjectorConfig(customYaml, systemCritical)
 	if err != nil {
 		return fmt.Errorf("failed to load injector blocks: %v", err)
 	}
+	return processTemplates(chartDir, values, config, profiles, render)
+}
+
+// processTemplates injects a loaded injector configuration into the chart, see ProcessTemplates
+func processTemplates(chartDir string, values map[any]any, config *InjectorConfig, profiles []string, render RenderOptions) error {
+	categories, err := config.enabledCategories(profiles)
+	if err != nil {
+		return err
+	}
+	if len(profiles) > 0 {
+		Logger.Infof("Profiles %v enable categories %v", profiles, categories)
+	}
 
 	// Track which .Values paths are referenced across all templates
 	var allValueReferences []ValueReference
 	seenPaths := make(map[string]bool)
+	// Workloads and the .Values paths their templates reference, for categories with a match clause
+	workloads := make(map[string]Workload)
+	workloadRefs := make(map[string][]ValueReference)
+	// Keys of the matching categories injected inline, by workload and category
+	var inline map[string]map[string][]string
 
 	templatesPath := filepath.Join(chartDir, "templates")
 	if !CheckHelmTemplateDir(templatesPath) {
 		return fmt.Errorf("unable to read from templates directory %s", templatesPath)
 	}
+	// Templated kinds are evaluated with the helpers and values of the chart
+	kinds, err := newKindResolver(chartDir, render)
+	if err != nil {
+		Logger.Warnf("Templated kinds are not resolved: %v", err)
+	}
+
+	// References of each template, following includes into the helpers
+	chartRefs, err := AnalyzeValueReferences(templatesPath)
+	if err != nil {
+		return fmt.Errorf("failed to analyze value references: %v", err)
+	}
 
 	// First pass: detect all .Values references
 	err = filepath.Walk(templatesPath, func(path string, info os.FileInfo, err error) error {
@@ -38,18 +70,25 @@ func ProcessTemplates(chartDir string, values map[any]any, customYaml string, cr
 		if info.IsDir() {
 			return nil
 		}
-		content, err := os.ReadFile(path)
+		content, err := Files.ReadFile(path)
 		if err != nil {
 			return nil // Skip files we can't read
 		}
 
-		// Detect value references in this template
-		refs := DetectValueReferences(string(content))
-		for _, ref := range refs {
-			pathKey := strings.Join(ref.Path, ".")
-			if !seenPaths[pathKey] {
-				seenPaths[pathKey] = true
-				allValueReferences = append(allValueReferences, ref)
+		// Detect value references in each document of this template
+		for i, doc := range templateDocuments(string(content)) {
+			refs := documentReferences(chartRefs, path, i, string(content))
+			if kind := getK8sResourceKind(doc, kinds); kind != "" {
+				key := documentKey(path, i)
+				workloads[key] = templateWorkload(chartDir, path, doc, kind)
+				workloadRefs[key] = refs
+			}
+			for _, ref := range refs {
+				pathKey := strings.Join(ref.Path, ".")
+				if !seenPaths[pathKey] {
+					seenPaths[pathKey] = true
+					allValueReferences = append(allValueReferences, ref)
+				}
 			}
 		}
 		return nil
@@ -63,9 +102,12 @@ func ProcessTemplates(chartDir string, values map[any]any, customYaml string, cr
 	if len(allValueReferences) > 0 {
 		//DEBUG
 		//Logger.Infof("Detected .Values references: %v", allValueReferences)
-		if err := InjectIntoValuesFile(chartDir, blocks, allValueReferences, criticalDs, controlPlane, systemCritical); err != nil {
+		if err := InjectIntoValuesFile(chartDir, config.Blocks, allValueReferences, categories); err != nil {
 			Logger.Warnf("Failed to inject into values.yaml: %v", err)
 		}
+		if inline, err = injectSelectedIntoValues(chartDir, config, profiles, workloads, workloadRefs); err != nil {
+			Logger.Warnf("Failed to inject matched blocks into values.yaml: %v", err)
+		}
 	}
 
 	// Second pass: process templates (inject directly only if not using .Values)
@@ -77,134 +119,32 @@ func ProcessTemplates(chartDir string, values map[any]any, customYaml string, cr
 			return nil
 		}
 		// Read the template file
-		content, err := os.ReadFile(path)
+		content, err := Files.ReadFile(path)
 		if err != nil {
 			return fmt.Errorf("failed to read template file %s: %v", path, err)
 		}
 
-		// Check if the file contains a Kubernetes resource kind that needs injection
-		if kind := getK8sResourceKind(string(content)); kind != "" {
+		// Inject each document of the template with the rules of its own kind and its own references
+		docs := templateDocuments(string(content))
+		modified := false
+		for i, doc := range docs {
+			kind := getK8sResourceKind(doc, kinds)
+			if kind == "" {
+				continue
+			}
 			Logger.Infof("Processing template file: %s (kind: %s)", path, kind)
-			// Detect which values this template references
-			valueRefs := DetectValueReferences(string(content))
-
-			modifiedContent := string(content)
-			modified := false
-
-			// Inject pod-level blocks - only inject keys that don't use .Values
-			if len(blocks["allPods"]) > 0 || (criticalDs && len(blocks["criticalDsPods"]) > 0) || (controlPlane && len(blocks["controlPlanePods"]) > 0) {
-				// Combine pod blocks based on flags
-				combinedPodBlocks := blocks["allPods"]
-				if criticalDs {
-					combinedPodBlocks = append(combinedPodBlocks, blocks["criticalDsPods"]...)
-				}
-				if controlPlane {
-					combinedPodBlocks = append(combinedPodBlocks, blocks["controlPlanePods"]...)
-				}
-
-				// Extract pod-level keys dynamically from blocks
-				podKeys := extractContainerBlockKeys(combinedPodBlocks) // reuse same function
-
-				// Build a map of which keys use .Values
-				keysUsingValues := make(map[string]bool)
-				for _, ref := range valueRefs {
-					for _, podKey := range podKeys {
-						if ref.Key == podKey {
-							keysUsingValues[podKey] = true
-						}
-					}
-				}
-
-				// Filter blocks to only include keys that don't use .Values
-				blocksToInject := []string{}
-				keysToInject := []string{}
-				for _, block := range combinedPodBlocks {
-					var blockData map[string]interface{}
-					if err := yaml.Unmarshal([]byte(block), &blockData); err != nil {
-						continue
-					}
-					for key := range blockData {
-						if !keysUsingValues[key] {
-							blocksToInject = append(blocksToInject, block)
-							keysToInject = append(keysToInject, key)
-							break
-						}
-					}
-				}
-
-				if len(blocksToInject) > 0 {
-					// Inject only the blocks that don't use .Values
-					if !modified {
-						Logger.Infof("Processing template file for inline injector: %s", path)
-					}
-					tempBlocks := map[string][]string{"allPods": blocksToInject}
-					modifiedContent, err = injectInlinePodSpec(modifiedContent, tempBlocks, kind, criticalDs, controlPlane)
-					if err != nil {
-						return fmt.Errorf("failed to inject inline pod spec in file %s: %v", path, err)
-					}
-					modified = true
-					Logger.Infof("Injected pod keys %v inline (not using .Values)", keysToInject)
-				}
-
-				if len(keysUsingValues) > 0 {
-					Logger.Infof("Skipping inline injection for pod keys using .Values: %v", getKeysFromMap(keysUsingValues))
-				}
+			valueRefs := documentReferences(chartRefs, path, i, string(content))
+			docs[i], err = injectTemplateDocument(chartDir, path, doc, kind, config, categories, profiles, valueRefs, inline[documentKey(path, i)])
+			if err != nil {
+				return err
 			}
+			modified = modified || docs[i] != doc
+		}
 
-			// Inject container-level blocks - only inject keys that don't use .Values
-			if len(blocks["allContainers"]) > 0 {
-				// Extract container-level keys dynamically from blocks
-				containerKeys := extractContainerBlockKeys(blocks["allContainers"])
-
-				// Build a map of which keys use .Values
-				keysUsingValues := make(map[string]bool)
-				for _, ref := range valueRefs {
-					for _, containerKey := range containerKeys {
-						if ref.Key == containerKey {
-							keysUsingValues[containerKey] = true
-						}
-					}
-				}
-
-				// Filter blocks to only include keys that don't use .Values
-				blocksToInject := []string{}
-				keysToInject := []string{}
-				for _, block := range blocks["allContainers"] {
-					var blockData map[string]interface{}
-					if err := yaml.Unmarshal([]byte(block), &blockData); err != nil {
-						continue
-					}
-					for key := range blockData {
-						if !keysUsingValues[key] {
-							blocksToInject = append(blocksToInject, block)
-							keysToInject = append(keysToInject, key)
-							break
-						}
-					}
-				}
-
-				if len(blocksToInject) > 0 {
-					// Inject only the blocks that don't use .Values
-					if !modified {
-						Logger.Infof("Processing template file for inline injector: %s", path)
-					}
-					tempBlocks := map[string][]string{"allContainers": blocksToInject}
-					modifiedContent, err = injectInlineContainerSpecWithBlocks(modifiedContent, tempBlocks)
-					if err != nil {
-						return fmt.Errorf("failed to inject inline container spec in file %s: %v", path, err)
-					}
-					modified = true
-					Logger.Infof("Injected container keys %v inline (not using .Values)", keysToInject)
-				}
-
-				if len(keysUsingValues) > 0 {
-					Logger.Infof("Skipping inline injection for keys using .Values: %v", getKeysFromMap(keysUsingValues))
-				}
-			} // Write back the modified content if we made changes
-			if modified {
-				if err := os.WriteFile(path, []byte(modifiedContent), info.Mode()); err != nil {
-					return fmt.Errorf("failed to write modified template file %s: %v", path, err)
-				}
+		// Write back the modified content if we made changes
+		if modified {
+			if err := Files.WriteFile(path, []byte(strings.Join(docs, "\n")), info.Mode()); err != nil {
+				return fmt.Errorf("failed to write modified template file %s: %v", path, err)
 			}
 		}
 		return nil
@@ -215,6 +155,101 @@ func ProcessTemplates(chartDir string, values map[any]any, customYaml string, cr
 	return nil
 }
 
+// injectTemplateDocument injects the blocks of the enabled and matching categories inline
+// into one document of a template, skipping the keys it sets from .Values. The keys of
+// matching categories in inline (by category) are injected even though they are set from
+// .Values, since other workloads read the same values.
+func injectTemplateDocument(chartDir, path, content, kind string, config *InjectorConfig, categories map[string][]string, profiles []string, valueRefs []ValueReference, inline map[string][]string) (string, error) {
+	blocks := config.Blocks
+	modifiedContent := content
+	modified := false
+	var err error
+
+	workload := templateWorkload(chartDir, path, content, kind)
+
+	// Combine pod blocks of the enabled categories and the categories that match this workload
+	combinedPodBlocks := categoryBlocks(blocks, categories[TargetPods])
+	blocksToInject, keysToInject, keysUsingValues := blocksNotUsingValues(combinedPodBlocks, valueRefs)
+	for _, category := range config.selectedCategories(workload, TargetPods, profiles) {
+		Logger.Infof("Category %s matches %s %s", category, kind, workload.Name)
+		combinedPodBlocks = append(combinedPodBlocks, blocks[category]...)
+		matchedBlocks, matchedKeys, matchedKeysUsingValues := blocksNotUsingValues(blocks[category], refsWithoutKeys(valueRefs, inline[category]))
+		blocksToInject = append(blocksToInject, matchedBlocks...)
+		for _, key := range matchedKeys {
+			if !slices.Contains(keysToInject, key) {
+				keysToInject = append(keysToInject, key)
+			}
+		}
+		for key := range matchedKeysUsingValues {
+			keysUsingValues[key] = true
+		}
+	}
+
+	// Inject pod-level blocks - only inject keys that don't use .Values
+	if len(combinedPodBlocks) > 0 {
+		if len(blocksToInject) > 0 {
+			// Inject only the blocks that don't use .Values
+			if !modified {
+				Logger.Infof("Processing template file for inline injector: %s", path)
+			}
+			tempBlocks := InjectorBlocks{"allPods": blocksToInject}
+			modifiedContent, err = injectInlinePodSpec(modifiedContent, tempBlocks, kind)
+			if err != nil {
+				return "", fmt.Errorf("failed to inject inline pod spec in file %s: %v", path, err)
+			}
+			modified = true
+			Logger.Infof("Injected pod keys %v inline (not using .Values)", keysToInject)
+		}
+
+		if len(keysUsingValues) > 0 {
+			Logger.Infof("Skipping inline injection for pod keys using .Values: %v", getKeysFromMap(keysUsingValues))
+		}
+	}
+
+	// Inject container-level blocks - only inject keys that don't use .Values.
+	// Init containers get their own categories, and matching categories may
+	// narrow their blocks to some containers by name.
+	type containerGroup struct {
+		blocks []InjectorBlock
+		names  []string         // Container names the blocks apply to, all when empty
+		target string           // TargetContainers or TargetInitContainers
+		refs   []ValueReference // References of the keys injected into values.yaml instead
+	}
+	var containerGroups []containerGroup
+	for _, target := range []string{TargetContainers, TargetInitContainers} {
+		containerGroups = append(containerGroups, containerGroup{blocks: categoryBlocks(blocks, categories[target]), target: target, refs: valueRefs})
+		for _, category := range config.selectedCategories(workload, target, profiles) {
+			Logger.Infof("Category %s matches %s %s", category, kind, workload.Name)
+			containerGroups = append(containerGroups, containerGroup{blocks: blocks[category], names: config.selectors[category].match.Containers, target: target, refs: refsWithoutKeys(valueRefs, inline[category])})
+		}
+	}
+	for _, group := range containerGroups {
+		if len(group.blocks) == 0 {
+			continue
+		}
+		blocksToInject, keysToInject, keysUsingValues := blocksNotUsingValues(group.blocks, group.refs)
+
+		if len(blocksToInject) > 0 {
+			// Inject only the blocks that don't use .Values
+			if !modified {
+				Logger.Infof("Processing template file for inline injector: %s", path)
+			}
+			modifiedContent, err = injectInlineContainerBlocks(modifiedContent, kind, blocksToInject, group.names, targetContainerLists(group.target))
+			if err != nil {
+				return "", fmt.Errorf("failed to inject inline container spec in file %s: %v", path, err)
+			}
+			modified = true
+			Logger.Infof("Injected container keys %v inline (not using .Values)", keysToInject)
+		}
+
+		if len(keysUsingValues) > 0 {
+			Logger.Infof("Skipping inline injection for keys using .Values: %v", getKeysFromMap(keysUsingValues))
+		}
+	}
+
+	return modifiedContent, nil
+}
+
 // formatValueReferences formats ValueReference slice for logging
 // func formatValueReferences(refs []ValueReference) []string {
 // 	result := make([]string, len(refs))
@@ -233,20 +268,175 @@ func getKeysFromMap(m map[string]bool) []string {
 	return keys
 }
 
-func getK8sResourceKind(s string) string {
-	// Check for Kubernetes resource kinds that have pod specs
-	// Use word boundaries to ensure exact matches (e.g., "Pod" but not "PodDisruptionBudget")
-	resourceKinds := []string{
-		"Deployment",
-		"StatefulSet",
-		"DaemonSet",
-		"Job",
-		"CronJob",
-		"ReplicaSet",
-		"Pod",
+// blocksNotUsingValues splits blocks into the ones to inject inline and the keys a template
+// already sets from .Values, which are injected into values.yaml instead
+func blocksNotUsingValues(blocks []InjectorBlock, valueRefs []ValueReference) ([]InjectorBlock, []string, map[string]bool) {
+	// Build a map of which keys use .Values
+	keys := extractContainerBlockKeys(blocks)
+	keysUsingValues := make(map[string]bool)
+	for _, ref := range valueRefs {
+		if slices.Contains(keys, ref.Key) {
+			keysUsingValues[ref.Key] = true
+		}
 	}
 
+	// Filter blocks to only include keys that don't use .Values
+	var blocksToInject []InjectorBlock
+	var keysToInject []string
+	for _, block := range blocks {
+		for _, key := range blockKeys([]InjectorBlock{block}) {
+			if !keysUsingValues[key] {
+				blocksToInject = append(blocksToInject, block)
+				keysToInject = append(keysToInject, key)
+				break
+			}
+		}
+	}
+	return blocksToInject, keysToInject, keysUsingValues
+}
+
+// refsWithoutKeys drops the references to keys, so the blocks setting them are injected inline
+func refsWithoutKeys(refs []ValueReference, keys []string) []ValueReference {
+	if len(keys) == 0 {
+		return refs
+	}
+	return slices.DeleteFunc(slices.Clone(refs), func(ref ValueReference) bool { return slices.Contains(keys, ref.Key) })
+}
+
+// injectSelectedIntoValues injects the blocks of categories with a match clause into the
+// .Values paths referenced by the templates of the workloads they match. Values are shared, so
+// a path is only injected when every workload reading it is matched; the keys a matched
+// workload reads from a shared path are returned by workload and category to be injected
+// inline into its template instead. Categories that select containers by name or target init
+// containers are only injected inline, since a values path cannot be tied to a container.
+func injectSelectedIntoValues(chartDir string, config *InjectorConfig, profiles []string, workloads map[string]Workload, workloadRefs map[string][]ValueReference) (map[string]map[string][]string, error) {
+	blocks := config.Blocks
+	templates := make([]string, 0, len(workloads))
+	for path := range workloads {
+		templates = append(templates, path)
+	}
+	sort.Strings(templates)
+
+	inline := make(map[string]map[string][]string)
+	for _, target := range []string{TargetPods, TargetContainers} {
+		matched := make(map[string][]string)
+		var categories []string
+		for _, path := range templates {
+			for _, category := range config.selectedCategories(workloads[path], target, profiles) {
+				if len(config.selectors[category].match.Containers) > 0 {
+					continue
+				}
+				if _, ok := matched[category]; !ok {
+					categories = append(categories, category)
+				}
+				matched[category] = append(matched[category], path)
+			}
+		}
+
+		blocksKey := "allPods"
+		if target == TargetContainers {
+			blocksKey = "allContainers"
+		}
+		for _, category := range categories {
+			var refs []ValueReference
+			for _, key := range blockKeys(blocks[category]) {
+				keyRefs, shared := exclusiveValueRefs(key, templates, matched[category], workloadRefs)
+				refs = append(refs, keyRefs...)
+				for _, path := range shared {
+					Logger.Warnf("Category %s: workloads it does not match read %s from the same values as %s, injecting it inline", category, key, workloads[path].Template)
+					if inline[path] == nil {
+						inline[path] = make(map[string][]string)
+					}
+					inline[path][category] = append(inline[path][category], key)
+				}
+			}
+			if err := InjectIntoValuesFile(chartDir, InjectorBlocks{blocksKey: blocks[category]}, refs, defaultCategories()); err != nil {
+				return nil, fmt.Errorf("category %s: %v", category, err)
+			}
+		}
+	}
+	return inline, nil
+}
+
+// exclusiveValueRefs returns the .Values paths of key that only matched workloads read, and
+// the matched workloads that read key from a path other workloads read too. Such a workload
+// gets key inline, so none of its paths are injected either: it would get key twice.
+func exclusiveValueRefs(key string, templates, matched []string, workloadRefs map[string][]ValueReference) ([]ValueReference, []string) {
+	// The workloads reading each values path of key
+	readers := make(map[string][]string)
+	paths := make(map[string]ValueReference)
+	var order []string
+	for _, path := range templates {
+		for _, ref := range workloadRefs[path] {
+			if ref.Key != key {
+				continue
+			}
+			p := strings.Join(ref.Path, ".")
+			if _, ok := paths[p]; !ok {
+				paths[p] = ref
+				order = append(order, p)
+			}
+			if !slices.Contains(readers[p], path) {
+				readers[p] = append(readers[p], path)
+			}
+		}
+	}
+
+	// A path read by a workload that is not injected through values moves all its readers
+	// inline, until no more paths are shared
+	throughValues := make(map[string]bool)
+	for _, path := range matched {
+		throughValues[path] = true
+	}
+	shared := func(p string) bool {
+		return slices.ContainsFunc(readers[p], func(path string) bool { return !throughValues[path] })
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, p := range order {
+			if !shared(p) {
+				continue
+			}
+			for _, path := range readers[p] {
+				if throughValues[path] {
+					throughValues[path], changed = false, true
+				}
+			}
+		}
+	}
+
+	var refs []ValueReference
+	for _, p := range order {
+		if !shared(p) {
+			refs = append(refs, paths[p])
+		}
+	}
+	var inline []string
+	for _, path := range matched {
+		if !throughValues[path] {
+			inline = append(inline, path)
+		}
+	}
+	return refs, inline
+}
+
+// getK8sResourceKind returns the kind of the resource in a template if it has a pod template:
+// one of builtinWorkloadKinds or of the kinds registered with --workload-kinds. A templated
+// kind that may render as several kinds returns them all, e.g. Deployment|DaemonSet.
+// Templated kinds are evaluated with kinds, or not resolved when it is nil.
+func getK8sResourceKind(s string, kinds *kindResolver) string {
+	// Check for Kubernetes resource kinds that have pod specs
+	// Match the whole kind (e.g., "Pod" but not "PodDisruptionBudget")
+
 	lines := strings.Split(s, "\n")
+	// A literal apiVersion tells apart registered kinds with the same name
+	var apiVersion string
+	for _, line := range lines {
+		if value, ok := strings.CutPrefix(line, "apiVersion:"); ok && !strings.Contains(value, "{{") {
+			apiVersion = strings.Trim(strings.TrimSpace(value), `"'`)
+			break
+		}
+	}
 	var kindValue string
 	for _, line := range lines {
 		trimmed := strings.TrimSpace(line)
@@ -255,7 +445,13 @@ func getK8sResourceKind(s string) string {
 			// Extract the kind value (everything after "kind:")
 			kindValueRaw := strings.TrimSpace(strings.TrimPrefix(trimmed, "kind:"))
 			if strings.Contains(kindValueRaw, "{") {
-				// We hit a Helm template expression
+				// We hit a Helm template expression: evaluate the kind of the resource with the
+				// chart values, it may render as several kinds
+				if kinds != nil && GetIndentation(line) == 0 {
+					if resolved := kinds.resolve(kindValueRaw); len(resolved) > 0 {
+						return strings.Join(resolved, "|")
+					}
+				}
 				if strings.Contains(kindValueRaw, "default") {
 					kindValueRaw = strings.Split(kindValueRaw, "default")[1]
 					kindValue = strings.Trim(kindValueRaw, `"'} `)
@@ -269,21 +465,44 @@ func getK8sResourceKind(s string) string {
 			}
 			// Check for exact match
 			//Logger.Infof("Found resource kind: %s", kindValue)
-			for _, kind := range resourceKinds {
-				if kindValue == kind {
-					return kind
-				}
+			if kind, ok := lookupWorkloadKind(kindValue); ok && (kind.APIVersion == "" || apiVersion == "" || apiVersion == kind.APIVersion) {
+				return kindValue
 			}
 		}
 	}
 	return ""
 }
 
+// InjectorBlock is one block of inject-blocks.yaml together with the strategy it declares
+type InjectorBlock struct {
+	Block    string        // YAML of the block, e.g. "tolerations:\n- key: dedicated\n"
+	Strategy MergeStrategy // Strategy declared with `strategy:`, empty for the default of the value
+}
+
 // InjectorBlocks stores the injection blocks by category
-// Each category (allPods, allContainers, etc.) contains a list of YAML block strings
-type InjectorBlocks map[string][]string
+// Each category (allPods, allContainers, etc.) contains a list of YAML blocks
+type InjectorBlocks map[string][]InjectorBlock
+
+// InjectorConfig is a loaded inject-blocks.yaml: the blocks of each category together with
+// the match clauses, targets and profiles declared for them
+type InjectorConfig struct {
+	Blocks    InjectorBlocks
+	selectors map[string]categorySelector // Match clause and target, by category
+	profiles  map[string][]string         // Categories each profile enables, by profile
+}
 
+// loadInjectorBlocks loads the blocks of inject-blocks.yaml, see loadInjectorConfig
 func loadInjectorBlocks(customYaml string, systemCritical string) (InjectorBlocks, error) {
+	config, err := loadInjectorConfig(customYaml, systemCritical)
+	if err != nil {
+		return nil, err
+	}
+	return config.Blocks, nil
+}
+
+// loadInjectorConfig loads inject-blocks.yaml with the blocks of --system-critical in place
+// of the allPods priorityClassName
+func loadInjectorConfig(customYaml string, systemCritical string) (*InjectorConfig, error) {
 	// Read yaml file from disk
 	data, err := os.ReadFile(customYaml)
 	if err != nil {
@@ -291,14 +510,35 @@ func loadInjectorBlocks(customYaml string, systemCritical string) (InjectorBlock
 	}
 
 	// Parse the YAML structure
-	// The structure is: top-level keys -> list of YAML blocks
-	var rawBlocks map[string][]interface{}
-	if err := yaml.Unmarshal(data, &rawBlocks); err != nil {
+	// The structure is: top-level keys -> list of YAML blocks, plus the profiles
+	// A MapSlice keeps the keys of each block in the order they are written
+	var document yaml.MapSlice
+	if err := yaml.Unmarshal(data, &document); err != nil {
 		return nil, fmt.Errorf("failed to parse %s: %v", customYaml, err)
 	}
+	rawBlocks := make(map[string]injectorCategory)
+	profiles := make(map[string][]string)
+	for _, item := range document {
+		raw, err := yaml.Marshal(item.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", customYaml, err)
+		}
+		name := fmt.Sprint(item.Key)
+		if name == "profiles" {
+			err = yaml.UnmarshalStrict(raw, &profiles)
+		} else {
+			var c injectorCategory
+			err = yaml.UnmarshalStrict(raw, &c)
+			rawBlocks[name] = c
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s in %s: %v", name, customYaml, err)
+		}
+	}
 
 	// Convert each block to a string representation
 	blocks := make(InjectorBlocks)
+	selectors := make(map[string]categorySelector)
 
 	// Expected structure:
 	//allPods: (catergory)
@@ -310,39 +550,67 @@ func loadInjectorBlocks(customYaml string, systemCritical string) (InjectorBlock
 	// - affinity:
 	//     nodeAffinity:
 	// and so on...
-	for category, blockList := range rawBlocks {
-		blocks[category] = make([]string, 0, len(blockList))
-		for _, block := range blockList {
+	// A category may also select the workloads it applies to:
+	//daemonSetPods:
+	//  match:
+	//    kinds: [DaemonSet]
+	//  blocks:
+	//  - tolerations: ...
+	// Profiles enable categories on demand with --profile:
+	//profiles:
+	//  controlPlane: [controlPlanePods]
+	for category, c := range rawBlocks {
+		sel, ok, err := newCategorySelector(category, c)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			selectors[category] = sel
+		}
+		blocks[category] = make([]InjectorBlock, 0, len(c.Blocks))
+		for _, block := range c.Blocks {
+			// A block may declare how it merges with existing values, e.g. "strategy: merge"
+			var strategy MergeStrategy
+			for i, item := range block {
+				if item.Key != "strategy" {
+					continue
+				}
+				block = slices.Delete(slices.Clone(block), i, i+1)
+				if len(block) != 1 {
+					return nil, fmt.Errorf("block with a strategy in category %s must have exactly one key", category)
+				}
+				strategy, err = parseMergeStrategy(fmt.Sprint(item.Value), block[0].Value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid strategy for %v in category %s: %v", block[0].Key, category, err)
+				}
+				break
+			}
+
 			// Marshal each block back to YAML string
 			blockYAML, err := yaml.Marshal(block)
 			if err != nil {
 				return nil, fmt.Errorf("failed to marshal block in category %s: %v", category, err)
 			}
-			blocks[category] = append(blocks[category], string(blockYAML))
-		}
-	}
-
-	// COMMENT OUT TO DEBUG
-	// switch systemCritical {
-	// case "node":
-	// 	if critBlocks, ok := blocks["systemCriticalNodePods"]; ok {
-	// 		blocks["allPods"] = append(blocks["allPods"], critBlocks...)
-	// 	}
-	// case "cluster":
-	// 	if critBlocks, ok := blocks["systemCriticalClusterPods"]; ok {
-	// 		blocks["allPods"] = append(blocks["allPods"], critBlocks...)
-	// 	}
-	// default:
-	// 	if critBlocks, ok := blocks["systemCriticalDefaultPods"]; ok {
-	// 		blocks["allPods"] = append(blocks["allPods"], critBlocks...)
-	// 	}
-	// }
+			blocks[category] = append(blocks[category], InjectorBlock{Block: string(blockYAML), Strategy: strategy})
+		}
+	}
+
+	resolved, err := resolveProfiles(profiles, blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	// --system-critical picks the priorityClassName of every pod
+	if err := applySystemCritical(blocks, systemCritical); err != nil {
+		return nil, err
+	}
+
 	//DEBUG
 	// fmt.Printf("Loaded injector blocks: %+v\n", blocks)
 	// fmt.Println("Press Enter to continue...")
 	// bufio.NewReader(os.Stdin).ReadBytes('\n')
 
-	return blocks, nil
+	return &InjectorConfig{Blocks: blocks, selectors: selectors, profiles: resolved}, nil
 }
 
 func CheckHelmTemplateDir(templatePath string) bool {
@@ -0,0 +1,172 @@
+package helm_parser
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// legacyProfiles maps the profiles of the deprecated --critical-ds and --control-plane flags
+// to the category they enabled before inject-blocks.yaml had profiles
+var legacyProfiles = map[string]string{
+	"criticalDs":   "criticalDsPods",
+	"controlPlane": "controlPlanePods",
+}
+
+// resolveProfiles validates the profiles of inject-blocks.yaml and returns them, each with
+// the categories it enables. A legacy profile it does not declare falls back to its category,
+// or to nothing without one, so the deprecated flags keep working with blocks written before
+// profiles.
+func resolveProfiles(profiles map[string][]string, blocks InjectorBlocks) (map[string][]string, error) {
+	for name, categories := range profiles {
+		for _, category := range categories {
+			if _, ok := blocks[category]; !ok {
+				return nil, fmt.Errorf("profile %s uses unknown category %s", name, category)
+			}
+		}
+	}
+	if profiles == nil {
+		profiles = make(map[string][]string)
+	}
+	for name, category := range legacyProfiles {
+		if _, ok := profiles[name]; ok {
+			continue
+		}
+		profiles[name] = []string{}
+		if _, ok := blocks[category]; ok {
+			profiles[name] = []string{category}
+		}
+	}
+	return profiles, nil
+}
+
+// profileCategory reports whether a category is enabled through a profile rather than always
+func (c *InjectorConfig) profileCategory(category string) bool {
+	for _, categories := range c.profiles {
+		if slices.Contains(categories, category) {
+			return true
+		}
+	}
+	return false
+}
+
+// enabledByProfiles reports whether a category is always enabled or enabled by one of profiles
+func (c *InjectorConfig) enabledByProfiles(category string, profiles []string) bool {
+	if !c.profileCategory(category) {
+		return true
+	}
+	for _, profile := range profiles {
+		if slices.Contains(c.profiles[profile], category) {
+			return true
+		}
+	}
+	return false
+}
+
+// categoryTarget returns what the blocks of a category apply to: pods unless it sets target
+func (c *InjectorConfig) categoryTarget(category string) string {
+	switch category {
+	case "allContainers":
+		return TargetContainers
+	case "allInitContainers":
+		return TargetInitContainers
+	}
+	if sel, ok := c.selectors[category]; ok {
+		return sel.target
+	}
+	return TargetPods
+}
+
+// defaultCategories returns the categories that are always enabled, by target
+func defaultCategories() map[string][]string {
+	return map[string][]string{
+		TargetPods:           {"allPods"},
+		TargetContainers:     {"allContainers"},
+		TargetInitContainers: {"allInitContainers"},
+	}
+}
+
+// enabledCategories returns the categories enabled by the selected profiles, by target.
+// allPods, allContainers and allInitContainers are always enabled.
+func (c *InjectorConfig) enabledCategories(profiles []string) (map[string][]string, error) {
+	enabled := defaultCategories()
+	for _, profile := range profiles {
+		categories, ok := c.profiles[profile]
+		if !ok {
+			known := make([]string, 0, len(c.profiles))
+			for name := range c.profiles {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown profile %q (available: %s)", profile, strings.Join(known, ", "))
+		}
+		for _, category := range categories {
+			// Categories with a match clause are applied where they match, see selectedCategories
+			if sel, ok := c.selectors[category]; ok && sel.match != nil {
+				continue
+			}
+			target := c.categoryTarget(category)
+			if !slices.Contains(enabled[target], category) {
+				enabled[target] = append(enabled[target], category)
+			}
+		}
+	}
+	return enabled, nil
+}
+
+// categoryBlocks returns the blocks of categories in order
+func categoryBlocks(blocks InjectorBlocks, categories []string) []InjectorBlock {
+	var result []InjectorBlock
+	for _, category := range categories {
+		result = append(result, blocks[category]...)
+	}
+	return result
+}
+
+// systemCriticalCategories maps the --system-critical values to the category holding their priorityClassName
+var systemCriticalCategories = map[string]string{
+	"node":    "systemCriticalNodePods",
+	"cluster": "systemCriticalClusterPods",
+	"default": "systemCriticalDefaultPods",
+}
+
+// applySystemCritical adds the blocks of the system critical category to allPods in place of
+// any priorityClassName allPods sets, so the selected priority class always wins
+func applySystemCritical(blocks InjectorBlocks, systemCritical string) error {
+	if systemCritical == "" {
+		return nil
+	}
+	category, ok := systemCriticalCategories[systemCritical]
+	if !ok {
+		return fmt.Errorf("unknown system critical component %q (expected node, cluster or default)", systemCritical)
+	}
+	critBlocks, ok := blocks[category]
+	if !ok {
+		return fmt.Errorf("system critical component %s needs the %s category in the injector blocks", systemCritical, category)
+	}
+
+	allPods := make([]InjectorBlock, 0, len(blocks["allPods"])+len(critBlocks))
+	for _, block := range blocks["allPods"] {
+		var data yaml.MapSlice
+		if err := yaml.Unmarshal([]byte(block.Block), &data); err != nil {
+			return fmt.Errorf("failed to parse allPods block: %v", err)
+		}
+		kept := slices.DeleteFunc(slices.Clone(data), func(item yaml.MapItem) bool { return item.Key == "priorityClassName" })
+		switch {
+		case len(kept) == len(data):
+			allPods = append(allPods, block)
+		case len(kept) > 0:
+			blockYAML, err := yaml.Marshal(kept)
+			if err != nil {
+				return fmt.Errorf("failed to marshal allPods block: %v", err)
+			}
+			allPods = append(allPods, InjectorBlock{Block: string(blockYAML), Strategy: block.Strategy})
+		}
+	}
+	blocks["allPods"] = append(allPods, critBlocks...)
+	Logger.Infof("Using %s for system critical component %s", category, systemCritical)
+	return nil
+}
@@ -0,0 +1,253 @@
+package helm_parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const profileTestBlocks = `profiles:
+  gpuNodes: [gpuPods, gpuContainers]
+  istio: [istiodPods]
+allPods:
+- priorityClassName: platform-default
+allContainers: []
+gpuPods:
+- tolerations:
+  - key: nvidia.com/gpu
+    operator: Exists
+gpuContainers:
+  target: containers
+  blocks:
+  - resources:
+      limits:
+        nvidia.com/gpu: 1
+istiodPods:
+  match:
+    names: [istiod]
+  blocks:
+  - nodeSelector:
+      pool: control
+`
+
+const profileTestDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: istiod
+spec:
+  template:
+    spec:
+      containers:
+      - name: discovery
+        image: pilot:1.0
+`
+
+func TestProcessTemplates_Profiles(t *testing.T) {
+	blocksPath := filepath.Join(t.TempDir(), "inject-blocks.yaml")
+	if err := os.WriteFile(blocksPath, []byte(profileTestBlocks), 0644); err != nil {
+		t.Fatalf("Failed to write blocks: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		profiles []string
+		want     map[string]bool
+	}{
+		{"no profile", nil, map[string]bool{"platform-default": true, "nvidia.com/gpu: 1": false, "key: nvidia.com/gpu": false, "pool: control": false}},
+		{"gpuNodes", []string{"gpuNodes"}, map[string]bool{"platform-default": true, "nvidia.com/gpu: 1": true, "key: nvidia.com/gpu": true, "pool: control": false}},
+		{"istio", []string{"istio"}, map[string]bool{"nvidia.com/gpu: 1": false, "pool: control": true}},
+	}
+	for _, tt := range tests {
+		chartDir := writeTestChart(t, "", map[string]string{"deployment.yaml": profileTestDeployment})
+		useFileStore(t, NewFileStore(false))
+		if err := ProcessTemplates(chartDir, nil, blocksPath, tt.profiles, "", RenderOptions{}); err != nil {
+			t.Fatalf("%s: ProcessTemplates failed: %v", tt.name, err)
+		}
+		content, _ := os.ReadFile(filepath.Join(chartDir, "templates", "deployment.yaml"))
+		for text, present := range tt.want {
+			if strings.Contains(string(content), text) != present {
+				t.Errorf("%s: expected %q present=%v in:\n%s", tt.name, text, present, content)
+			}
+		}
+	}
+
+	chartDir := writeTestChart(t, "", map[string]string{"deployment.yaml": profileTestDeployment})
+	err := ProcessTemplates(chartDir, nil, blocksPath, []string{"ingress"}, "", RenderOptions{})
+	if err == nil || !strings.Contains(err.Error(), "available: controlPlane, criticalDs, gpuNodes, istio") {
+		t.Errorf("Expected an unknown profile error listing the profiles, got %v", err)
+	}
+	t.Log("✓ Categories injected only when their profile is enabled")
+}
+
+func TestProcessTemplates_LegacyProfiles(t *testing.T) {
+	// Blocks written before profiles: --critical-ds and --control-plane enable their categories
+	legacyBlocks := `allPods:
+- priorityClassName: platform-default
+criticalDsPods:
+- tolerations:
+  - operator: Exists
+`
+	blocksPath := filepath.Join(t.TempDir(), "inject-blocks.yaml")
+	if err := os.WriteFile(blocksPath, []byte(legacyBlocks), 0644); err != nil {
+		t.Fatalf("Failed to write blocks: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		blocksPath string
+		profiles   []string
+		want       map[string]bool
+	}{
+		{"no flag", blocksPath, nil, map[string]bool{"platform-default": true, "operator: Exists": false}},
+		{"--critical-ds", blocksPath, []string{"criticalDs"}, map[string]bool{"platform-default": true, "operator: Exists": true}},
+		{"--control-plane without its category", blocksPath, []string{"controlPlane"}, map[string]bool{"platform-default": true, "operator: Exists": false}},
+		{"testdata blocks", filepath.Join("testdata", "helm_templates", "inject-blocks.yaml"), []string{"criticalDs", "controlPlane"}, map[string]bool{"platform/dedicated": true}},
+	}
+	for _, tt := range tests {
+		chartDir := writeTestChart(t, "", map[string]string{"deployment.yaml": profileTestDeployment})
+		useFileStore(t, NewFileStore(false))
+		if err := ProcessTemplates(chartDir, nil, tt.blocksPath, tt.profiles, "", RenderOptions{}); err != nil {
+			t.Fatalf("%s: ProcessTemplates failed: %v", tt.name, err)
+		}
+		content, _ := os.ReadFile(filepath.Join(chartDir, "templates", "deployment.yaml"))
+		for text, present := range tt.want {
+			if strings.Contains(string(content), text) != present {
+				t.Errorf("%s: expected %q present=%v in:\n%s", tt.name, text, present, content)
+			}
+		}
+	}
+	t.Log("✓ Deprecated flags fall back to their categories without profiles")
+}
+
+func TestLoadInjectorConfig_ProfilesPerLoad(t *testing.T) {
+	withProfiles := loadTestConfig(t, profileTestBlocks)
+	withoutProfiles := loadTestConfig(t, "allPods: []\ngpuPods:\n- nodeSelector:\n    gpu: \"true\"\n")
+	if _, err := withProfiles.enabledCategories([]string{"gpuNodes"}); err != nil {
+		t.Errorf("Expected the first load to keep its profiles after another load: %v", err)
+	}
+	if _, err := withoutProfiles.enabledCategories([]string{"gpuNodes"}); err == nil {
+		t.Errorf("Expected an unknown profile without a profiles section")
+	}
+	if !withProfiles.profileCategory("gpuPods") || withoutProfiles.profileCategory("gpuPods") {
+		t.Errorf("Expected gpuPods to be a profile category of the first load only")
+	}
+	t.Log("✓ Profiles carried by each loaded configuration")
+}
+
+func TestLoadInjectorBlocks_InvalidProfile(t *testing.T) {
+	tests := map[string]string{
+		"unknown category": "profiles:\n  gpu: [gpuPods]\nallPods: []\n",
+		"not a list":       "profiles:\n  gpu: gpuPods\nallPods: []\n",
+	}
+	for name, content := range tests {
+		path := filepath.Join(t.TempDir(), "inject-blocks.yaml")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write blocks: %v", err)
+		}
+		if _, err := loadInjectorBlocks(path, ""); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	t.Log("✓ Invalid profiles rejected")
+}
+
+const systemCriticalTestBlocks = `allPods:
+- priorityClassName: platform-default
+  strategy: skip-if-present
+- priorityClassName: platform-default
+  nodeSelector:
+    kubernetes.io/os: linux
+systemCriticalNodePods:
+- priorityClassName: system-node-critical
+systemCriticalDefaultPods:
+- priorityClassName: null
+  strategy: replace
+`
+
+func TestLoadInjectorBlocks_SystemCritical(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inject-blocks.yaml")
+	if err := os.WriteFile(path, []byte(systemCriticalTestBlocks), 0644); err != nil {
+		t.Fatalf("Failed to write blocks: %v", err)
+	}
+
+	blocks, err := loadInjectorBlocks(path, "node")
+	if err != nil {
+		t.Fatalf("Failed to load blocks: %v", err)
+	}
+	template := `apiVersion: v1
+kind: Pod
+spec:
+  priorityClassName: app-priority
+  containers:
+  - name: app`
+	result, err := injectInlinePodSpec(template, blocks, "Pod")
+	if err != nil {
+		t.Fatalf("injectInlinePodSpec failed: %v", err)
+	}
+	lines := strings.Split(result, "\n")
+	for _, line := range []string{"  priorityClassName: system-node-critical", "    kubernetes.io/os: linux"} {
+		if !containsLine(lines, line) {
+			t.Errorf("Expected line %q in:\n%s", line, result)
+		}
+	}
+	if strings.Contains(result, "platform-default") || strings.Contains(result, "app-priority") {
+		t.Errorf("Expected the system critical priority class to win:\n%s", result)
+	}
+
+	for _, systemCritical := range []string{"cluster", "critical"} {
+		if _, err := loadInjectorBlocks(path, systemCritical); err == nil {
+			t.Errorf("%s: expected an error", systemCritical)
+		}
+	}
+
+	withoutDefault := filepath.Join(t.TempDir(), "inject-blocks.yaml")
+	if err := os.WriteFile(withoutDefault, []byte(strings.Split(systemCriticalTestBlocks, "systemCriticalDefaultPods")[0]), 0644); err != nil {
+		t.Fatalf("Failed to write blocks: %v", err)
+	}
+	if _, err := loadInjectorBlocks(withoutDefault, "default"); err == nil {
+		t.Errorf("default: expected an error without systemCriticalDefaultPods")
+	}
+
+	// The default block removes the chart's priorityClassName and injects none
+	blocks, err = loadInjectorBlocks(path, "default")
+	if err != nil {
+		t.Fatalf("Failed to load blocks: %v", err)
+	}
+	for _, podSpec := range []string{template, strings.Replace(template, "  priorityClassName: app-priority\n", "", 1)} {
+		result, err = injectInlinePodSpec(podSpec, blocks, "Pod")
+		if err != nil {
+			t.Fatalf("injectInlinePodSpec failed: %v", err)
+		}
+		if strings.Contains(result, "priorityClassName") {
+			t.Errorf("Expected no priorityClassName:\n%s", result)
+		}
+		if !containsLine(strings.Split(result, "\n"), "    kubernetes.io/os: linux") {
+			t.Errorf("Expected the other allPods blocks:\n%s", result)
+		}
+	}
+
+	// Also when the chart reads it from values.yaml
+	chartDir := writeTestChart(t, "priorityClassName: app-priority\n", map[string]string{"pod.yaml": `apiVersion: v1
+kind: Pod
+metadata:
+  name: app
+spec:
+  priorityClassName: {{ .Values.priorityClassName }}
+  containers:
+  - name: app
+    image: app:1.0
+`})
+	useFileStore(t, NewFileStore(false))
+	if err := ProcessTemplates(chartDir, nil, path, nil, "default", RenderOptions{}); err != nil {
+		t.Fatalf("ProcessTemplates failed: %v", err)
+	}
+	rel, err := renderChartFromValues(chartDir, RenderOptions{})
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	if strings.Contains(rel.Manifest, "app-priority") {
+		t.Errorf("Expected the values.yaml priorityClassName removed:\n%s", rel.Manifest)
+	}
+	t.Log("✓ System critical priority class resolved from its category")
+}
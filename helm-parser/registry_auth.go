@@ -0,0 +1,138 @@
+package helm_parser
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	regauthn "github.com/google/go-containerregistry/pkg/authn"
+	regname "github.com/google/go-containerregistry/pkg/name"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// RegistryUsernameEnv and RegistryPasswordEnv provide credentials for the local repository
+	// registry when the corresponding flags are not set
+	RegistryUsernameEnv = "HELM_PARSER_REGISTRY_USERNAME"
+	RegistryPasswordEnv = "HELM_PARSER_REGISTRY_PASSWORD"
+)
+
+// RegistryAuthOptions configures how registries are authenticated when checking images.
+// Credentials are resolved in this order:
+//  1. Username/Password (flags, or the HELM_PARSER_REGISTRY_* env vars) for the local repository registry
+//  2. Per-registry entries in CredentialsFile
+//  3. ~/.docker/config.json, including credential helpers (authn.DefaultKeychain)
+//  4. Anonymous
+type RegistryAuthOptions struct {
+	Username        string // Username for the local repository registry
+	Password        string // Password for the local repository registry
+	CredentialsFile string // YAML file with per-registry credentials
+}
+
+// RegistryCredential holds the credentials for a single registry in the credentials file
+type RegistryCredential struct {
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	PasswordEnv string `yaml:"passwordEnv"` // Read the password from this env var instead
+}
+
+// registryCredentialsFile is the structure of the per-registry credentials file:
+//
+//	registries:
+//	  registry.omegaworld.net:
+//	    username: robot
+//	    passwordEnv: OMEGAWORLD_TOKEN
+type registryCredentialsFile struct {
+	Registries map[string]RegistryCredential `yaml:"registries"`
+}
+
+// staticKeychain resolves registries to fixed credentials, keyed by registry host
+type staticKeychain struct {
+	creds map[string]regauthn.AuthConfig
+}
+
+// Resolve returns the credentials for the target registry or Anonymous if there are none
+func (k staticKeychain) Resolve(target regauthn.Resource) (regauthn.Authenticator, error) {
+	if cfg, ok := k.creds[target.RegistryStr()]; ok {
+		return regauthn.FromConfig(cfg), nil
+	}
+	return regauthn.Anonymous, nil
+}
+
+// registryHost normalises a registry name (e.g. docker.io -> index.docker.io) so it matches
+// the host go-containerregistry resolves image references to
+func registryHost(registry string) (string, error) {
+	reg, err := regname.NewRegistry(strings.TrimSuffix(registry, "/"))
+	if err != nil {
+		return "", err
+	}
+	return reg.RegistryStr(), nil
+}
+
+// loadRegistryCredentials reads the per-registry credentials file
+func loadRegistryCredentials(path string) (map[string]regauthn.AuthConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry credentials file: %v", err)
+	}
+	var file registryCredentialsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	creds := make(map[string]regauthn.AuthConfig, len(file.Registries))
+	for registry, cred := range file.Registries {
+		host, err := registryHost(registry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid registry %q in %s: %v", registry, path, err)
+		}
+		password := cred.Password
+		if cred.PasswordEnv != "" {
+			password = os.Getenv(cred.PasswordEnv)
+			if password == "" {
+				Logger.Warnf("Password env var %s for registry %s is empty", cred.PasswordEnv, registry)
+			}
+		}
+		creds[host] = regauthn.AuthConfig{Username: cred.Username, Password: password}
+	}
+	return creds, nil
+}
+
+// NewRegistryKeychain builds the keychain used to authenticate registry requests.
+// localRepo selects the registry the username/password credentials apply to.
+func NewRegistryKeychain(opts RegistryAuthOptions, localRepo string) (regauthn.Keychain, error) {
+	var keychains []regauthn.Keychain
+
+	username := opts.Username
+	if username == "" {
+		username = os.Getenv(RegistryUsernameEnv)
+	}
+	password := opts.Password
+	if password == "" {
+		password = os.Getenv(RegistryPasswordEnv)
+	}
+	if username != "" || password != "" {
+		if localRepo == "" {
+			return nil, fmt.Errorf("registry username/password require a local repository to apply to")
+		}
+		ref, err := regname.NewRepository(localRepo)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing local repo %s: %v", localRepo, err)
+		}
+		keychains = append(keychains, staticKeychain{creds: map[string]regauthn.AuthConfig{
+			ref.RegistryStr(): {Username: username, Password: password},
+		}})
+	}
+
+	if opts.CredentialsFile != "" {
+		creds, err := loadRegistryCredentials(opts.CredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		keychains = append(keychains, staticKeychain{creds: creds})
+	}
+
+	// Docker config and credential helpers come last
+	keychains = append(keychains, regauthn.DefaultKeychain)
+	return regauthn.NewMultiKeychain(keychains...), nil
+}
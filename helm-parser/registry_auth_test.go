@@ -0,0 +1,105 @@
+package helm_parser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	regauthn "github.com/google/go-containerregistry/pkg/authn"
+	regname "github.com/google/go-containerregistry/pkg/name"
+)
+
+// resolveAuth resolves the credentials the keychain returns for a registry
+func resolveAuth(t *testing.T, kc regauthn.Keychain, registry string) *regauthn.AuthConfig {
+	t.Helper()
+	reg, err := regname.NewRegistry(registry)
+	if err != nil {
+		t.Fatalf("Failed to parse registry %s: %v", registry, err)
+	}
+	auth, err := kc.Resolve(reg)
+	if err != nil {
+		t.Fatalf("Resolve failed for %s: %v", registry, err)
+	}
+	cfg, err := auth.Authorization()
+	if err != nil {
+		t.Fatalf("Authorization failed for %s: %v", registry, err)
+	}
+	return cfg
+}
+
+func TestNewRegistryKeychain_Precedence(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Docker config with credentials for two registries
+	dockerConfig := `{"auths": {
+  "registry.example.com": {"username": "docker-user", "password": "docker-pass"},
+  "quay.io": {"username": "quay-user", "password": "quay-pass"}
+}}`
+	if err := os.WriteFile(filepath.Join(tmpDir, "config.json"), []byte(dockerConfig), 0600); err != nil {
+		t.Fatalf("Failed to write docker config: %v", err)
+	}
+	t.Setenv("DOCKER_CONFIG", tmpDir)
+	t.Setenv(RegistryUsernameEnv, "")
+	t.Setenv(RegistryPasswordEnv, "")
+	t.Setenv("GHCR_TOKEN", "ghcr-secret")
+
+	credsFile := filepath.Join(tmpDir, "registries.yaml")
+	creds := `registries:
+  ghcr.io:
+    username: ghcr-user
+    passwordEnv: GHCR_TOKEN
+  docker.io:
+    username: hub-user
+    password: hub-pass
+`
+	if err := os.WriteFile(credsFile, []byte(creds), 0600); err != nil {
+		t.Fatalf("Failed to write credentials file: %v", err)
+	}
+
+	kc, err := NewRegistryKeychain(RegistryAuthOptions{
+		Username:        "flag-user",
+		Password:        "flag-pass",
+		CredentialsFile: credsFile,
+	}, "registry.example.com/home/ext")
+	if err != nil {
+		t.Fatalf("NewRegistryKeychain failed: %v", err)
+	}
+
+	tests := []struct {
+		registry string
+		username string
+		password string
+	}{
+		// Flags win for the local repository registry
+		{"registry.example.com", "flag-user", "flag-pass"},
+		// Credentials file, with the password read from an env var
+		{"ghcr.io", "ghcr-user", "ghcr-secret"},
+		// docker.io in the credentials file matches index.docker.io
+		{"index.docker.io", "hub-user", "hub-pass"},
+		// Falls back to the docker config
+		{"quay.io", "quay-user", "quay-pass"},
+		// Anonymous
+		{"gcr.io", "", ""},
+	}
+	for _, tt := range tests {
+		cfg := resolveAuth(t, kc, tt.registry)
+		if cfg.Username != tt.username || cfg.Password != tt.password {
+			t.Errorf("%s: expected %s/%s, got %s/%s", tt.registry, tt.username, tt.password, cfg.Username, cfg.Password)
+		}
+	}
+}
+
+func TestNewRegistryKeychain_EnvCredentials(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	t.Setenv(RegistryUsernameEnv, "env-user")
+	t.Setenv(RegistryPasswordEnv, "env-pass")
+
+	kc, err := NewRegistryKeychain(RegistryAuthOptions{}, "registry.example.com/home/ext")
+	if err != nil {
+		t.Fatalf("NewRegistryKeychain failed: %v", err)
+	}
+	cfg := resolveAuth(t, kc, "registry.example.com")
+	if cfg.Username != "env-user" || cfg.Password != "env-pass" {
+		t.Errorf("Expected env credentials, got %s/%s", cfg.Username, cfg.Password)
+	}
+}
@@ -0,0 +1,212 @@
+package helm_parser
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/distribution/reference"
+	"gopkg.in/yaml.v2"
+)
+
+// RewriteRules holds the registry rewrite rules loaded from --registry-rules. When nil every
+// image is mapped to <localRepo>/<oldDomain>/<oldPath>.
+var RewriteRules *RegistryRules
+
+// RegistryRule maps the images it matches to a new location. A rule has exactly one matcher
+// (registry, prefix or regex) and exactly one action (keep, target or replace). Matchers are
+// applied to the image repository without tag, e.g. quay.io/jetstack/cert-manager-controller
+// or docker.io/nginx (the library/ prefix of official images is dropped).
+//
+//	rules:
+//	  - registry: registry.internal.example.com  # already internal, leave alone
+//	    keep: true
+//	  - prefix: quay.io/                           # quay.io/jetstack/x -> art.example.com/quay-remote/jetstack/x
+//	    replace: art.example.com/quay-remote/
+//	  - registry: ghcr.io                          # ghcr.io/org/x -> art.example.com/ghcr/ghcr.io/org/x
+//	    target: art.example.com/ghcr
+//	  - regex: ^docker\.io/bitnami/(.*)$
+//	    replace: art.example.com/bitnami/$1
+type RegistryRule struct {
+	Registry string `yaml:"registry,omitempty"` // Source registry domain
+	Prefix   string `yaml:"prefix,omitempty"`   // Source repository prefix
+	Regex    string `yaml:"regex,omitempty"`    // Regular expression matched against the source repository
+
+	Keep    bool   `yaml:"keep,omitempty"`    // Leave matching images untouched
+	Target  string `yaml:"target,omitempty"`  // Local repository to use instead of --local-repo
+	Replace string `yaml:"replace,omitempty"` // Replaces the matched prefix, or the regex match ($1 expands groups)
+
+	re *regexp.Regexp
+}
+
+// RegistryRules is the registry rewrite rules file. Rules are tried in order and the first
+// match wins; images matching no rule use the default --local-repo mapping.
+type RegistryRules struct {
+	Keys  []string       `yaml:"keys,omitempty"` // values.yaml keys holding repositories, in addition to RegistryAttrs
+	Rules []RegistryRule `yaml:"rules"`
+}
+
+// LoadRegistryRules reads and validates a registry rules file. An empty path returns nil.
+func LoadRegistryRules(rulesPath string) (*RegistryRules, error) {
+	if rulesPath == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(rulesPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry rules file: %v", err)
+	}
+	var rules RegistryRules
+	if err := yaml.UnmarshalStrict(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", rulesPath, err)
+	}
+	for i := range rules.Rules {
+		if err := rules.Rules[i].compile(); err != nil {
+			return nil, fmt.Errorf("invalid rule %d in %s: %v", i+1, rulesPath, err)
+		}
+	}
+	return &rules, nil
+}
+
+// compile validates the rule and compiles its regular expression
+func (r *RegistryRule) compile() error {
+	matchers := 0
+	for _, m := range []string{r.Registry, r.Prefix, r.Regex} {
+		if m != "" {
+			matchers++
+		}
+	}
+	if matchers != 1 {
+		return fmt.Errorf("exactly one of registry, prefix or regex is required")
+	}
+	actions := 0
+	if r.Keep {
+		actions++
+	}
+	if r.Target != "" {
+		actions++
+		if _, _, err := localRepoParts(r.Target); err != nil {
+			return err
+		}
+	}
+	if r.Replace != "" {
+		actions++
+		if r.Registry != "" {
+			return fmt.Errorf("replace cannot be used with registry, use prefix instead")
+		}
+	}
+	if actions != 1 {
+		return fmt.Errorf("exactly one of keep, target or replace is required")
+	}
+	if r.Regex != "" {
+		re, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return fmt.Errorf("invalid regex %q: %v", r.Regex, err)
+		}
+		r.re = re
+	}
+	return nil
+}
+
+// matches reports whether the rule applies to the repository on domain
+func (r *RegistryRule) matches(domain, repo string) bool {
+	switch {
+	case r.Registry != "":
+		return domain == r.Registry
+	case r.Prefix != "":
+		return strings.HasPrefix(repo, r.Prefix)
+	case r.re != nil:
+		return r.re.MatchString(repo)
+	}
+	return false
+}
+
+// outputPrefix is the fixed prefix of every repository the rule produces, used to recognise
+// values that were already rewritten by it
+func (r *RegistryRule) outputPrefix() string {
+	switch {
+	case r.Target != "":
+		return strings.TrimSuffix(r.Target, "/") + "/"
+	case r.Replace != "":
+		prefix, _, _ := strings.Cut(r.Replace, "$")
+		return prefix
+	}
+	return ""
+}
+
+// findRule returns the first rule matching the repository, or nil
+func (rules *RegistryRules) findRule(domain, repo string) *RegistryRule {
+	if rules == nil {
+		return nil
+	}
+	for i := range rules.Rules {
+		if rules.Rules[i].matches(domain, repo) {
+			return &rules.Rules[i]
+		}
+	}
+	return nil
+}
+
+// alreadyRewritten reports whether value was produced by one of the rules
+func (rules *RegistryRules) alreadyRewritten(value string) bool {
+	if rules == nil {
+		return false
+	}
+	for i := range rules.Rules {
+		if prefix := rules.Rules[i].outputPrefix(); prefix != "" && strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// registryKeys returns the extra values.yaml keys from the rules file
+func (rules *RegistryRules) registryKeys() []string {
+	if rules == nil {
+		return nil
+	}
+	return rules.Keys
+}
+
+// applyRegistryRule rewrites a parsed repository with the first matching rule. ok is false
+// when no rule matches; keep is true when the matching rule leaves the image as is.
+func applyRegistryRule(regNamed reference.Named) (newRepo string, keep bool, ok bool, err error) {
+	regDomain := reference.Domain(regNamed)
+	repo := path.Join(regDomain, strings.TrimPrefix(reference.Path(regNamed), "library/"))
+	rule := RewriteRules.findRule(regDomain, repo)
+	if rule == nil {
+		return "", false, false, nil
+	}
+	switch {
+	case rule.Keep:
+		return "", true, true, nil
+	case rule.Target != "":
+		newRegDomain, newRegPath, err := localRepoParts(rule.Target)
+		if err != nil {
+			return "", false, true, err
+		}
+		return path.Join(newRegDomain, newRegPath, repo), false, true, nil
+	case rule.re != nil:
+		return rule.re.ReplaceAllString(repo, rule.Replace), false, true, nil
+	default:
+		return rule.Replace + strings.TrimPrefix(repo, rule.Prefix), false, true, nil
+	}
+}
+
+// reverseRegistryRules maps an image produced by a target or prefix rule back to its
+// upstream image. Regex rules cannot be reversed.
+func reverseRegistryRules(image string) (string, bool) {
+	if RewriteRules == nil {
+		return "", false
+	}
+	for _, rule := range RewriteRules.Rules {
+		switch {
+		case rule.Target != "" && strings.HasPrefix(image, rule.outputPrefix()):
+			return strings.TrimPrefix(image, rule.outputPrefix()), true
+		case rule.Prefix != "" && rule.Replace != "" && strings.HasPrefix(image, rule.Replace):
+			return rule.Prefix + strings.TrimPrefix(image, rule.Replace), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,124 @@
+package helm_parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testRegistryRules = `keys:
+  - image
+rules:
+  - registry: registry.internal.example.com
+    keep: true
+  - prefix: quay.io/
+    replace: art.example.com/quay-remote/
+  - registry: ghcr.io
+    target: art.example.com/ghcr
+  - regex: ^docker\.io/bitnami/(.*)$
+    replace: art.example.com/bitnami/$1
+`
+
+// useRegistryRules loads rules into RewriteRules for the duration of a test
+func useRegistryRules(t *testing.T, content string) {
+	t.Helper()
+	rulesPath := filepath.Join(t.TempDir(), "registry-rules.yaml")
+	if err := os.WriteFile(rulesPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write rules file: %v", err)
+	}
+	rules, err := LoadRegistryRules(rulesPath)
+	if err != nil {
+		t.Fatalf("LoadRegistryRules failed: %v", err)
+	}
+	previous := RewriteRules
+	RewriteRules = rules
+	t.Cleanup(func() { RewriteRules = previous })
+}
+
+func TestReplaceRegistryInText_Rules(t *testing.T) {
+	useRegistryRules(t, testRegistryRules)
+
+	values := `internal:
+  repository: registry.internal.example.com/platform/agent
+certManager:
+  repository: quay.io/jetstack/cert-manager-controller
+runner:
+  image: ghcr.io/actions/runner
+redis:
+  repository: docker.io/bitnami/redis
+nginx:
+  repository: nginx
+`
+	got, _ := replaceRegistryInText(values, "registry.example.com", "home/ext")
+
+	expected := []string{
+		"  repository: registry.internal.example.com/platform/agent",
+		"  repository: art.example.com/quay-remote/jetstack/cert-manager-controller",
+		"  image: art.example.com/ghcr/ghcr.io/actions/runner",
+		"  repository: art.example.com/bitnami/redis",
+		// No rule matches: default mapping
+		"  repository: registry.example.com/home/ext/docker.io/nginx",
+	}
+	lines := strings.Split(got, "\n")
+	for _, line := range expected {
+		if !containsLine(lines, line) {
+			t.Errorf("Expected line %q in:\n%s", line, got)
+		}
+	}
+
+	// Running the rewrite again must not change anything
+	again, _ := replaceRegistryInText(got, "registry.example.com", "home/ext")
+	if again != got {
+		t.Errorf("Expected the rewrite to be idempotent, got:\n%s", again)
+	}
+	t.Log("✓ Rules applied in order with keep, prefix, target and regex actions")
+}
+
+func TestUpstreamImageFor_Rules(t *testing.T) {
+	useRegistryRules(t, testRegistryRules)
+
+	tests := []struct {
+		local    string
+		upstream string
+	}{
+		{"art.example.com/quay-remote/jetstack/cert-manager-controller:v1.14.3", "quay.io/jetstack/cert-manager-controller:v1.14.3"},
+		{"art.example.com/ghcr/ghcr.io/actions/runner:2.0", "ghcr.io/actions/runner:2.0"},
+		{"registry.example.com/home/ext/docker.io/nginx:1.25", "docker.io/nginx:1.25"},
+	}
+	for _, tt := range tests {
+		source, target, err := mirrorPair(tt.local, "registry.example.com/home/ext")
+		if err != nil || source != tt.upstream || target != tt.local {
+			t.Errorf("mirrorPair(%s) = %s, %s (%v), expected %s", tt.local, source, target, err, tt.upstream)
+		}
+	}
+
+	// Kept images map to themselves
+	source, target, err := mirrorPair("registry.internal.example.com/platform/agent:1.0", "registry.example.com/home/ext")
+	if err != nil || source != target {
+		t.Errorf("Expected a kept image to map to itself, got %s -> %s (%v)", source, target, err)
+	}
+	t.Log("✓ Rule targets mapped back to their upstream images")
+}
+
+func TestLoadRegistryRules_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"no matcher":    "rules:\n  - keep: true\n",
+		"two actions":   "rules:\n  - registry: quay.io\n    keep: true\n    target: art.example.com/quay\n",
+		"bad regex":     "rules:\n  - regex: '('\n    replace: x\n",
+		"registry swap": "rules:\n  - registry: quay.io\n    replace: art.example.com/quay\n",
+		"unknown field": "rules:\n  - registry: quay.io\n    keeep: true\n",
+	}
+	for name, content := range tests {
+		rulesPath := filepath.Join(t.TempDir(), "rules.yaml")
+		if err := os.WriteFile(rulesPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write rules file: %v", err)
+		}
+		if _, err := LoadRegistryRules(rulesPath); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if rules, err := LoadRegistryRules(""); rules != nil || err != nil {
+		t.Errorf("Expected no rules without a path, got %v (%v)", rules, err)
+	}
+}
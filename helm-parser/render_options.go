@@ -0,0 +1,110 @@
+package helm_parser
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/getter"
+)
+
+// RenderOptions is the release, cluster and values a chart is rendered for, like the
+// --release-name, --namespace, --kube-version, --api-versions, --is-upgrade, -f, --set and
+// --set-string flags of helm template. The zero value renders release test in namespace
+// default with Helm's default capabilities and the chart's values.yaml.
+type RenderOptions struct {
+	ReleaseName string   // .Release.Name, test when empty
+	Namespace   string   // .Release.Namespace, default when empty
+	KubeVersion string   // .Capabilities.KubeVersion, e.g. v1.31.0
+	APIVersions []string // Added to .Capabilities.APIVersions, e.g. monitoring.coreos.com/v1/ServiceMonitor
+	IsInstall   bool     // .Release.IsInstall
+	IsUpgrade   bool     // .Release.IsUpgrade
+
+	ValueFiles []string // Values files layered over values.yaml in order, e.g. env/prod.yaml
+	Set        []string // Values set on the command line, e.g. image.tag=1.2
+	SetString  []string // Values set on the command line as strings
+	Matrix     bool     // Render each values file on its own instead of layering them
+}
+
+// releaseOptions returns the release the chart is rendered as
+func (r RenderOptions) releaseOptions() chartutil.ReleaseOptions {
+	opts := chartutil.ReleaseOptions{
+		Name:      r.ReleaseName,
+		Namespace: r.Namespace,
+		IsInstall: r.IsInstall,
+		IsUpgrade: r.IsUpgrade,
+	}
+	if opts.Name == "" {
+		opts.Name = "test"
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = "default"
+	}
+	return opts
+}
+
+// capabilities returns the cluster capabilities the chart is rendered with
+func (r RenderOptions) capabilities() (*chartutil.Capabilities, error) {
+	caps := chartutil.DefaultCapabilities.Copy()
+	if r.KubeVersion != "" {
+		kubeVersion, err := chartutil.ParseKubeVersion(r.KubeVersion)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kube version %q: %v", r.KubeVersion, err)
+		}
+		caps.KubeVersion = *kubeVersion
+	}
+	// Clone so the default capabilities are never appended to
+	caps.APIVersions = append(slices.Clone(caps.APIVersions), r.APIVersions...)
+	return caps, nil
+}
+
+// overlayValues reads the values files, which may be URLs, and the --set and --set-string
+// values the way helm template does, later ones winning
+func (r RenderOptions) overlayValues() (map[string]interface{}, error) {
+	opts := values.Options{ValueFiles: r.ValueFiles, Values: r.Set, StringValues: r.SetString}
+	overlay, err := opts.MergeValues(getter.All(cli.New()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read values files and --set values: %v", err)
+	}
+	return overlay, nil
+}
+
+// renderValues builds the values a chart is rendered with: the values overlay coalesced
+// over the chart's values like Helm's user-supplied values, so a null deletes a key and
+// globals reach the subcharts, then the release and the capabilities
+func (r RenderOptions) renderValues(ch *chart.Chart) (chartutil.Values, error) {
+	caps, err := r.capabilities()
+	if err != nil {
+		return nil, err
+	}
+	overlay, err := r.overlayValues()
+	if err != nil {
+		return nil, err
+	}
+	return chartutil.ToRenderValues(ch, overlay, r.releaseOptions(), caps)
+}
+
+// overlays returns the render options of every values overlay the chart is checked with:
+// one layering all the values files, or in matrix mode one per values file, each with the
+// --set and --set-string values
+func (r RenderOptions) overlays() []RenderOptions {
+	if !r.Matrix || len(r.ValueFiles) == 0 {
+		return []RenderOptions{r}
+	}
+	overlays := make([]RenderOptions, 0, len(r.ValueFiles))
+	for _, file := range r.ValueFiles {
+		overlay := r
+		overlay.ValueFiles, overlay.Matrix = []string{file}, false
+		overlays = append(overlays, overlay)
+	}
+	return overlays
+}
+
+// valuesName names the values of an overlay for logs, e.g. values.yaml + env/prod.yaml
+func (r RenderOptions) valuesName() string {
+	return strings.Join(append([]string{"values.yaml"}, r.ValueFiles...), " + ")
+}
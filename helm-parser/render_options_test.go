@@ -0,0 +1,204 @@
+package helm_parser
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+const renderOptionsTestPod = `apiVersion: v1
+kind: Pod
+metadata:
+  name: {{ .Release.Name }}
+  namespace: {{ .Release.Namespace }}
+spec:
+  containers:
+  - name: app
+    {{- if semverCompare ">=1.31-0" .Capabilities.KubeVersion.GitVersion }}
+    image: app:new
+    {{- else }}
+    image: app:legacy
+    {{- end }}
+  {{- if .Capabilities.APIVersions.Has "monitoring.coreos.com/v1/ServiceMonitor" }}
+  - name: exporter
+    image: exporter:1.0
+  {{- end }}
+  {{- if .Release.IsUpgrade }}
+  - name: migrate
+    image: migrate:1.0
+  {{- end }}
+`
+
+func TestRenderOptions(t *testing.T) {
+	chartDir := writeTestChart(t, "", map[string]string{"pod.yaml": renderOptionsTestPod})
+	useFileStore(t, NewFileStore(false))
+	defaultAPIVersions := len(chartutil.DefaultCapabilities.APIVersions)
+
+	tests := []struct {
+		name   string
+		render RenderOptions
+		want   []string
+	}{
+		{"defaults", RenderOptions{}, []string{"name: test", "namespace: default", "image: app:legacy"}},
+		{"cluster", RenderOptions{
+			ReleaseName: "edge",
+			Namespace:   "gateways",
+			KubeVersion: "v1.31.2",
+			APIVersions: []string{"monitoring.coreos.com/v1/ServiceMonitor"},
+			IsUpgrade:   true,
+		}, []string{"name: edge", "namespace: gateways", "image: app:new", "image: exporter:1.0", "image: migrate:1.0"}},
+	}
+	for _, tt := range tests {
+		rel, err := renderChartFromValues(chartDir, tt.render)
+		if err != nil {
+			t.Fatalf("%s: render failed: %v", tt.name, err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(rel.Manifest, want) {
+				t.Errorf("%s: expected %q in:\n%s", tt.name, want, rel.Manifest)
+			}
+		}
+		images, err := ExtractImagesFromManifest(rel.Manifest)
+		if err != nil {
+			t.Fatalf("%s: ExtractImagesFromManifest failed: %v", tt.name, err)
+		}
+		if len(images) != len(tt.want)-2 {
+			t.Errorf("%s: expected %d images, got %v", tt.name, len(tt.want)-2, images)
+		}
+	}
+	if len(chartutil.DefaultCapabilities.APIVersions) != defaultAPIVersions {
+		t.Errorf("Expected Helm's default capabilities to be left alone")
+	}
+
+	_, err := renderChartFromValues(chartDir, RenderOptions{KubeVersion: "latest"})
+	if err == nil || !strings.Contains(err.Error(), `invalid kube version "latest"`) {
+		t.Errorf("Expected an invalid kube version error, got %v", err)
+	}
+	t.Log("✓ Charts rendered for the configured release and cluster")
+}
+
+const overlayTestPod = `apiVersion: v1
+kind: Pod
+metadata:
+  name: app
+spec:
+  containers:
+  - name: app
+    image: app:{{ .Values.image.tag }}
+  {{- if .Values.metrics.enabled }}
+  - name: exporter
+    image: exporter:{{ .Values.metrics.tag }}
+  {{- end }}
+`
+
+func TestRenderOptions_ValuesOverlays(t *testing.T) {
+	chartDir := writeTestChart(t, "image:\n  tag: \"1.0\"\nmetrics:\n  enabled: false\n  tag: \"0.9\"\n", map[string]string{"pod.yaml": overlayTestPod})
+	useFileStore(t, NewFileStore(false))
+	envDir := t.TempDir()
+	staging := filepath.Join(envDir, "staging.yaml")
+	prod := filepath.Join(envDir, "prod.yaml")
+	if err := os.WriteFile(staging, []byte("image:\n  tag: \"1.1\"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write values file: %v", err)
+	}
+	if err := os.WriteFile(prod, []byte("metrics:\n  enabled: true\n"), 0644); err != nil {
+		t.Fatalf("Failed to write values file: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		render RenderOptions
+		want   []string
+	}{
+		{"values.yaml", RenderOptions{}, []string{"app:1.0"}},
+		{"layered", RenderOptions{ValueFiles: []string{staging, prod}}, []string{"app:1.1", "exporter:0.9"}},
+		{"set", RenderOptions{ValueFiles: []string{prod}, Set: []string{"image.tag=2.0"}, SetString: []string{"metrics.tag=1.10"}}, []string{"app:2.0", "exporter:1.10"}},
+		{"matrix", RenderOptions{ValueFiles: []string{staging, prod}, Matrix: true}, []string{"app:1.1", "app:1.0", "exporter:0.9"}},
+	}
+	for _, tt := range tests {
+		images, err := ListImages(Options{ChartPath: chartDir, Render: tt.render})
+		if err != nil {
+			t.Fatalf("%s: ListImages failed: %v", tt.name, err)
+		}
+		slices.Sort(images)
+		want := slices.Sorted(slices.Values(tt.want))
+		if !slices.Equal(images, want) {
+			t.Errorf("%s: expected images %v, got %v", tt.name, want, images)
+		}
+	}
+
+	manifest, err := RenderChart(Options{ChartPath: chartDir, Render: RenderOptions{ValueFiles: []string{staging, prod}, Matrix: true}})
+	if err != nil {
+		t.Fatalf("RenderChart failed: %v", err)
+	}
+	for _, want := range []string{"# Values: values.yaml + " + staging + "\n", "# Values: values.yaml + " + prod + "\n"} {
+		if !strings.Contains(manifest, want) {
+			t.Errorf("Expected %q in the matrix manifest:\n%s", want, manifest)
+		}
+	}
+
+	_, err = ListImages(Options{ChartPath: chartDir, Render: RenderOptions{ValueFiles: []string{filepath.Join(envDir, "missing.yaml")}}})
+	if err == nil || !strings.Contains(err.Error(), "failed to read values file") {
+		t.Errorf("Expected a missing values file error, got %v", err)
+	}
+	t.Log("✓ Images extracted with layered values files, --set overrides and a values matrix")
+}
+
+func TestRenderOptions_ValuesOverlaySemantics(t *testing.T) {
+	pod := `apiVersion: v1
+kind: Pod
+metadata:
+  name: app
+spec:
+  {{- with .Values.nodeSelector }}
+  nodeSelector:
+    {{- toYaml . | nindent 4 }}
+  {{- end }}
+  containers:
+  - name: app
+    image: app:1.0
+`
+	subPod := `apiVersion: v1
+kind: Pod
+metadata:
+  name: sub
+spec:
+  containers:
+  - name: sub
+    image: {{ .Values.global.registry }}/sub:1.0
+`
+	chartDir := writeTestChart(t, "nodeSelector:\n  pool: default\nglobal:\n  registry: docker.io\n", map[string]string{"pod.yaml": pod})
+	subDir := filepath.Join(chartDir, "charts", "sub")
+	if err := os.MkdirAll(filepath.Join(subDir, "templates"), 0755); err != nil {
+		t.Fatalf("Failed to create subchart: %v", err)
+	}
+	for name, content := range map[string]string{"Chart.yaml": "apiVersion: v2\nname: sub\nversion: 0.1.0\n", "values.yaml": "", "templates/pod.yaml": subPod} {
+		if err := os.WriteFile(filepath.Join(subDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write subchart %s: %v", name, err)
+		}
+	}
+	useFileStore(t, NewFileStore(false))
+	// Values files may be URLs, like with helm template -f
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "nodeSelector: null\nglobal:\n  registry: mirror.example.com\n")
+	}))
+	defer srv.Close()
+
+	rel, err := renderChartFromValues(chartDir, RenderOptions{ValueFiles: []string{srv.URL + "/prod.yaml"}})
+	if err != nil {
+		t.Fatalf("Failed to render chart: %v", err)
+	}
+	if strings.Contains(rel.Manifest, "pool: default") {
+		t.Errorf("Expected the null in the values file to delete nodeSelector:\n%s", rel.Manifest)
+	}
+	if !strings.Contains(rel.Manifest, "image: mirror.example.com/sub:1.0") {
+		t.Errorf("Expected the overlay global registry in the subchart:\n%s", rel.Manifest)
+	}
+	t.Log("✓ Values overlays merged with Helm's semantics")
+}
@@ -0,0 +1,73 @@
+package helm_parser
+
+import (
+	"strings"
+)
+
+// isTemplateGuard reports whether a line only opens a control structure, e.g. {{- if .Values.webhook.enabled }}
+func isTemplateGuard(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	m := helmControlRe.FindStringSubmatch(trimmed)
+	if m == nil || m[0] != trimmed {
+		return false
+	}
+	return m[1] == "if" || m[1] == "with" || m[1] == "range"
+}
+
+// templateDocuments splits a template into its YAML documents at the --- separators, so
+// strings.Join(docs, "\n") gives back the template. The control actions just above a
+// separator that open the guard of the next document, e.g. {{- if .Values.webhook.enabled }},
+// go with that document so each document keeps its if and with blocks balanced. Separators
+// inside a control structure, e.g. a range emitting a document per item, do not split.
+func templateDocuments(content string) []string {
+	lines := strings.Split(content, "\n")
+	depths := controlDepths(lines)
+	starts := []int{0}
+	for i, line := range lines {
+		if i == 0 || strings.TrimSpace(line) != "---" || GetIndentation(line) != 0 {
+			continue
+		}
+		start := i
+		for start > starts[len(starts)-1]+1 && (isTemplateGuard(lines[start-1]) || strings.TrimSpace(lines[start-1]) == "") {
+			start--
+		}
+		// Leave blank lines at the end of the previous document
+		for start < i && strings.TrimSpace(lines[start]) == "" {
+			start++
+		}
+		if start > starts[len(starts)-1] && depths[start] == 0 {
+			starts = append(starts, start)
+		}
+	}
+
+	docs := make([]string, len(starts))
+	for i, start := range starts {
+		end := len(lines)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		docs[i] = strings.Join(lines[start:end], "\n")
+	}
+	return docs
+}
+
+// controlDepths returns the number of control structures open at the start of each line
+func controlDepths(lines []string) []int {
+	depths := make([]int, len(lines))
+	depth := 0
+	for i, line := range lines {
+		depths[i] = depth
+		for _, m := range helmControlRe.FindAllStringSubmatch(line, -1) {
+			switch m[1] {
+			case "else":
+			case "end":
+				if depth > 0 {
+					depth--
+				}
+			default:
+				depth++
+			}
+		}
+	}
+	return depths
+}
@@ -0,0 +1,247 @@
+package helm_parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const multiDocumentTemplate = `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+  - port: 80
+
+{{- if .Values.deployment.enabled }}
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: nginx:1.27
+{{- end }}
+{{- if .Values.debug.enabled }}
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: debug
+spec:
+  containers:
+  - name: shell
+    image: busybox:1.36
+{{- end }}
+`
+
+func TestTemplateDocuments(t *testing.T) {
+	docs := templateDocuments(multiDocumentTemplate)
+	if len(docs) != 3 {
+		t.Fatalf("Expected 3 documents, got %d: %q", len(docs), docs)
+	}
+	if strings.Join(docs, "\n") != multiDocumentTemplate {
+		t.Errorf("Expected the documents to join back into the template")
+	}
+	if !strings.HasPrefix(docs[1], "{{- if .Values.deployment.enabled }}\n---") || !strings.HasSuffix(docs[1], "{{- end }}") {
+		t.Errorf("Expected the Deployment to keep its if block, got:\n%s", docs[1])
+	}
+	if !strings.HasSuffix(docs[0], "  - port: 80\n") {
+		t.Errorf("Expected the Service to keep its trailing blank line, got:\n%s", docs[0])
+	}
+	if kinds := []string{getK8sResourceKind(docs[0], nil), getK8sResourceKind(docs[1], nil), getK8sResourceKind(docs[2], nil)}; strings.Join(kinds, ",") != ",Deployment,Pod" {
+		t.Errorf("Expected kinds [ Deployment Pod], got %v", kinds)
+	}
+	t.Log("✓ Templates split into documents with their guards")
+}
+
+func TestProcessTemplates_MultiDocument(t *testing.T) {
+	blocksPath := filepath.Join(t.TempDir(), "inject-blocks.yaml")
+	blocks := `allPods:
+- priorityClassName: platform-default
+allContainers:
+- securityContext:
+    runAsNonRoot: true
+`
+	if err := os.WriteFile(blocksPath, []byte(blocks), 0644); err != nil {
+		t.Fatalf("Failed to write blocks: %v", err)
+	}
+	chartDir := writeTestChart(t, "", map[string]string{"web.yaml": multiDocumentTemplate})
+	useFileStore(t, NewFileStore(false))
+
+	if err := ProcessTemplates(chartDir, nil, blocksPath, nil, "", RenderOptions{}); err != nil {
+		t.Fatalf("ProcessTemplates failed: %v", err)
+	}
+	content, _ := os.ReadFile(filepath.Join(chartDir, "templates", "web.yaml"))
+	docs := templateDocuments(string(content))
+	if len(docs) != 3 {
+		t.Fatalf("Expected 3 documents, got:\n%s", content)
+	}
+	if docs[0] != templateDocuments(multiDocumentTemplate)[0] {
+		t.Errorf("Expected the Service untouched, got:\n%s", docs[0])
+	}
+	for i, want := range []string{"    spec:\n      priorityClassName: platform-default", "spec:\n  priorityClassName: platform-default"} {
+		doc := docs[i+1]
+		if !strings.Contains(doc, want) || strings.Count(doc, "priorityClassName") != 1 {
+			t.Errorf("Expected %q in document %d, got:\n%s", want, i+1, doc)
+		}
+		if strings.Count(doc, "runAsNonRoot: true") != 1 {
+			t.Errorf("Expected the container block once in document %d, got:\n%s", i+1, doc)
+		}
+		if !strings.HasSuffix(strings.TrimSpace(doc), "{{- end }}") {
+			t.Errorf("Expected document %d to end with its guard, got:\n%s", i+1, doc)
+		}
+	}
+	t.Log("✓ Each document injected with the rules of its own kind")
+}
+
+const multiDocumentValuesTemplate = `apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: agent
+spec:
+  template:
+    spec:
+      {{- with .Values.agent.tolerations }}
+      tolerations:
+        {{- toYaml . | nindent 8 }}
+      {{- end }}
+      containers:
+      - name: agent
+        image: agent:1.0
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      {{- with .Values.web.tolerations }}
+      tolerations:
+        {{- toYaml . | nindent 8 }}
+      {{- end }}
+      containers:
+      - name: web
+        image: web:1.0
+`
+
+func TestProcessTemplates_MultiDocumentReferences(t *testing.T) {
+	blocksPath := filepath.Join(t.TempDir(), "inject-blocks.yaml")
+	blocks := "dsPods:\n  match:\n    kinds: [DaemonSet]\n  blocks:\n  - tolerations:\n    - key: ds-only\n      operator: Exists\n"
+	if err := os.WriteFile(blocksPath, []byte(blocks), 0644); err != nil {
+		t.Fatalf("Failed to write blocks: %v", err)
+	}
+	values := "agent:\n  tolerations: []\nweb:\n  tolerations: []\n"
+	chartDir := writeTestChart(t, values, map[string]string{"workloads.yaml": multiDocumentValuesTemplate})
+	useFileStore(t, NewFileStore(false))
+
+	chartRefs, err := AnalyzeValueReferences(filepath.Join(chartDir, "templates"))
+	if err != nil {
+		t.Fatalf("AnalyzeValueReferences failed: %v", err)
+	}
+	path := filepath.Join(chartDir, "templates", "workloads.yaml")
+	for i, want := range []string{"agent.tolerations", "web.tolerations"} {
+		var got []string
+		for _, ref := range documentReferences(chartRefs, path, i, multiDocumentValuesTemplate) {
+			got = append(got, strings.Join(ref.Path, "."))
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("Expected document %d to reference %s, got %v", i, want, got)
+		}
+	}
+
+	if err := ProcessTemplates(chartDir, nil, blocksPath, nil, "", RenderOptions{}); err != nil {
+		t.Fatalf("ProcessTemplates failed: %v", err)
+	}
+	updated, _ := os.ReadFile(filepath.Join(chartDir, "values.yaml"))
+	if !strings.Contains(string(updated), "key: ds-only") || !strings.HasSuffix(string(updated), "web:\n  tolerations: []\n") {
+		t.Errorf("Expected only the DaemonSet tolerations to be injected into values.yaml:\n%s", updated)
+	}
+	if content, _ := os.ReadFile(path); string(content) != multiDocumentValuesTemplate {
+		t.Errorf("Expected the template untouched, got:\n%s", content)
+	}
+	t.Log("✓ Each document matched against the values it references")
+}
+
+const rangeDocumentsTemplate = `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+{{- range .Values.workers }}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .name }}
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .name }}
+spec:
+  template:
+    spec:
+      {{- if .gpu }}
+      nodeSelector:
+        gpu: "true"
+      {{- end }}
+      containers:
+      - name: worker
+        image: worker:1.0
+{{- end }}
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: debug
+spec:
+  containers:
+  - name: shell
+    image: busybox:1.36
+`
+
+func TestTemplateDocuments_RangeSpanningDocuments(t *testing.T) {
+	docs := templateDocuments(rangeDocumentsTemplate)
+	if len(docs) != 3 {
+		t.Fatalf("Expected 3 documents, got %d: %q", len(docs), docs)
+	}
+	if strings.Join(docs, "\n") != rangeDocumentsTemplate {
+		t.Errorf("Expected the documents to join back into the template")
+	}
+	if !strings.HasPrefix(docs[1], "{{- range .Values.workers }}\n---") || !strings.HasSuffix(docs[1], "{{- end }}") {
+		t.Errorf("Expected the range to stay in one document, got:\n%s", docs[1])
+	}
+	for i, doc := range docs {
+		if depths := controlDepths(append(strings.Split(doc, "\n"), "")); depths[len(depths)-1] != 0 {
+			t.Errorf("Expected document %d to close its control structures, got:\n%s", i, doc)
+		}
+		if strings.Count(doc, "{{- end }}") > strings.Count(doc, "{{- if")+strings.Count(doc, "{{- range") {
+			t.Errorf("Expected document %d not to close a structure it does not open, got:\n%s", i, doc)
+		}
+	}
+
+	blocksPath := filepath.Join(t.TempDir(), "inject-blocks.yaml")
+	if err := os.WriteFile(blocksPath, []byte("allPods:\n- priorityClassName: platform-default\n"), 0644); err != nil {
+		t.Fatalf("Failed to write blocks: %v", err)
+	}
+	chartDir := writeTestChart(t, "workers:\n- name: batch\n", map[string]string{"workers.yaml": rangeDocumentsTemplate})
+	useFileStore(t, NewFileStore(false))
+	if err := ProcessTemplates(chartDir, nil, blocksPath, nil, "", RenderOptions{}); err != nil {
+		t.Fatalf("ProcessTemplates failed: %v", err)
+	}
+	content, _ := os.ReadFile(filepath.Join(chartDir, "templates", "workers.yaml"))
+	if !strings.Contains(string(content), "      {{- end }}\n      priorityClassName: platform-default\n      containers:") || strings.Count(string(content), "priorityClassName") != 2 {
+		t.Errorf("Expected the Deployment in the range and the Pod injected, got:\n%s", content)
+	}
+	if _, err := renderChartFromValues(chartDir, RenderOptions{}); err != nil {
+		t.Errorf("Expected the injected template to render: %v", err)
+	}
+	t.Log("✓ Documents emitted by a range kept together")
+}
@@ -0,0 +1,229 @@
+package helm_parser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/distribution/reference"
+	regname "github.com/google/go-containerregistry/pkg/name"
+)
+
+var (
+	// templateActionRe matches a template action such as {{- define "app.image" -}}
+	templateActionRe = regexp.MustCompile(`{{-?\s*(\w+)?\s*("[^"]*")?[^}]*}}`)
+	// imageCandidateRe matches strings that may be image references with a registry domain
+	imageCandidateRe = regexp.MustCompile(`[A-Za-z0-9][A-Za-z0-9._-]*(?::[0-9]+)?/[A-Za-z0-9._/-]+(?::[A-Za-z0-9._-]+)?(?:@sha256:[a-f0-9]{64})?`)
+)
+
+// splitImageSuffix splits an image string into its repository and the ":tag" / "@digest"
+// suffix, keeping the repository exactly as written
+func splitImageSuffix(image string) (string, string) {
+	end := len(image)
+	if at := strings.Index(image, "@"); at >= 0 {
+		end = at
+	}
+	if colon := strings.LastIndex(image[:end], ":"); colon > strings.LastIndex(image[:end], "/") {
+		end = colon
+	}
+	return image[:end], image[end:]
+}
+
+// rewriteImageString maps an image with an optional tag or digest to the local repository
+// using the same mapping as the values.yaml registry rewrite
+func rewriteImageString(image string, newRegDomain string, newRegPath string) (string, bool, error) {
+	repo, suffix := splitImageSuffix(image)
+	newRepo, alreadyTarget, err := rewriteRegistryValue(repo, newRegDomain, newRegPath)
+	if err != nil {
+		return image, false, err
+	}
+	return newRepo + suffix, alreadyTarget, nil
+}
+
+// hasRegistryDomain reports whether the first path component of an image is a registry domain
+func hasRegistryDomain(image string) bool {
+	domain, _, found := strings.Cut(image, "/")
+	return found && (strings.ContainsAny(domain, ".:") || domain == "localhost")
+}
+
+// defineTracker follows {{ define }} blocks through a template, one line at a time
+type defineTracker struct {
+	name  string // Name of the define block we are in, "" outside of one
+	depth int    // Open control structures inside the define block
+}
+
+// update processes the actions on a line and returns the define block the line belongs to
+func (d *defineTracker) update(line string) string {
+	current := d.name
+	for _, m := range templateActionRe.FindAllStringSubmatch(line, -1) {
+		switch m[1] {
+		case "define":
+			d.name = strings.Trim(m[2], `"`)
+			d.depth = 0
+			current = d.name
+		case "if", "range", "with", "block":
+			if d.name != "" {
+				d.depth++
+			}
+		case "end":
+			if d.name == "" {
+				continue
+			}
+			if d.depth == 0 {
+				d.name = ""
+			} else {
+				d.depth--
+			}
+		}
+	}
+	return current
+}
+
+// rewriteTemplateImages rewrites literal image references in a template: image: values
+// without template actions, and image strings inside {{ define }} blocks such as the ones
+// in _helpers.tpl. Inside a define, strings without a tag are only rewritten when the
+// define name mentions an image, so label keys like app.kubernetes.io/name are left alone.
+func rewriteTemplateImages(content string, newRegDomain string, newRegPath string) (string, []string) {
+	lines := strings.Split(content, "\n")
+	var rewritten []string
+	var defines defineTracker
+
+	rewrite := func(line string, start, end int) string {
+		image := line[start:end]
+		newImage, alreadyTarget, err := rewriteImageString(image, newRegDomain, newRegPath)
+		if err != nil || alreadyTarget || newImage == image {
+			return line
+		}
+		Logger.Infof("Updating template image from %s to %s", image, newImage)
+		rewritten = append(rewritten, image)
+		return line[:start] + newImage + line[end:]
+	}
+
+	for i, line := range lines {
+		define := defines.update(line)
+		trimmed := strings.TrimPrefix(strings.TrimSpace(line), "- ")
+
+		// Literal image: value
+		if strings.HasPrefix(trimmed, "image:") && !strings.Contains(trimmed, "{{") {
+			value := strings.TrimSpace(strings.TrimPrefix(trimmed, "image:"))
+			if idx := strings.Index(value, " #"); idx >= 0 {
+				value = strings.TrimSpace(value[:idx])
+			}
+			value = strings.Trim(value, `"'`)
+			if value == "" {
+				continue
+			}
+			if _, err := regname.ParseReference(value); err != nil {
+				continue
+			}
+			// Search after the key, so a value like i is not found inside image:
+			valueStart := strings.Index(line, "image:") + len("image:")
+			start := valueStart + strings.Index(line[valueStart:], value)
+			lines[i] = rewrite(line, start, start+len(value))
+			continue
+		}
+
+		if define == "" {
+			continue
+		}
+		// Image strings inside a define block. Replace from the end so earlier offsets stay valid.
+		matches := imageCandidateRe.FindAllStringIndex(line, -1)
+		for j := len(matches) - 1; j >= 0; j-- {
+			start, end := matches[j][0], matches[j][1]
+			if start > 0 && !strings.ContainsRune(" \t\"'(", rune(line[start-1])) {
+				continue
+			}
+			candidate := line[start:end]
+			if !hasRegistryDomain(candidate) {
+				continue
+			}
+			named, err := reference.ParseNormalizedNamed(candidate)
+			if err != nil {
+				continue
+			}
+			_, tagged := named.(reference.Tagged)
+			_, digested := named.(reference.Digested)
+			if !tagged && !digested && !strings.Contains(strings.ToLower(define), "image") {
+				continue
+			}
+			line = rewrite(line, start, end)
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines, "\n"), rewritten
+}
+
+// UpdateRegistryInTemplates rewrites literal image references in the chart templates and
+// helper defines to the local repository
+func UpdateRegistryInTemplates(chartPath string, newRepo string) error {
+	newRegDomain, newRegPath, err := localRepoParts(newRepo)
+	if err != nil {
+		return err
+	}
+	templateFiles, err := GetTemplateFiles(filepath.Join(chartPath, "templates"))
+	if err != nil {
+		return err
+	}
+	for _, path := range templateFiles {
+		content, err := Files.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read template file %s: %v", path, err)
+		}
+		newContent, rewritten := rewriteTemplateImages(string(content), newRegDomain, newRegPath)
+		if len(rewritten) == 0 {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if err := Files.WriteFile(path, []byte(newContent), info.Mode()); err != nil {
+			return fmt.Errorf("failed to write modified template file %s: %v", path, err)
+		}
+		Logger.Infof("Updated registry of %d image(s) in %s", len(rewritten), path)
+	}
+	return nil
+}
+
+// untracedImages renders the chart and returns the images that still do not point at
+// the local repository, i.e. images the registry rewrite could not trace to an editable source
+func untracedImages(opts Options) ([]string, error) {
+	newRegDomain, newRegPath, err := localRepoParts(opts.LocalRepo)
+	if err != nil {
+		return nil, err
+	}
+	images, err := ListImages(opts)
+	if err != nil {
+		return nil, err
+	}
+	var untraced []string
+	for _, img := range images {
+		if _, alreadyTarget, err := rewriteImageString(img, newRegDomain, newRegPath); err != nil || !alreadyTarget {
+			untraced = append(untraced, img)
+		}
+	}
+	return untraced, nil
+}
+
+// updateRegistry rewrites image registries in values.yaml and the templates, then reports
+// the rendered images that were not rewritten
+func updateRegistry(opts Options) error {
+	if err := UpdateRegistryInValuesFile(opts.ChartPath, opts.LocalRepo); err != nil {
+		Logger.Errorf("failed to update registry name: %v", err)
+		return err
+	}
+	if err := UpdateRegistryInTemplates(opts.ChartPath, opts.LocalRepo); err != nil {
+		Logger.Errorf("failed to update registry in templates: %v", err)
+		return err
+	}
+	untraced, err := untracedImages(opts)
+	if err != nil {
+		return err
+	}
+	for _, img := range untraced {
+		Logger.Warnf("Could not trace image %s back to a values.yaml key or template to rewrite its registry", img)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package helm_parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRewriteTemplateImages(t *testing.T) {
+	content := `{{- define "app.labels" -}}
+app.kubernetes.io/name: {{ .Chart.Name }}
+{{- end }}
+
+{{- define "app.proxyImage" -}}
+{{- if .Values.proxy.image -}}
+{{ .Values.proxy.image }}
+{{- else -}}
+{{ printf "%s:%s" "gcr.io/istio-release/proxyv2" .Chart.AppVersion }}
+{{- end -}}
+{{- end }}
+
+{{- define "app.initImage" -}}
+docker.io/library/busybox:1.36
+{{- end }}
+`
+	got, rewritten := rewriteTemplateImages(content, "registry.example.com", "ext")
+
+	expected := []string{
+		`{{ printf "%s:%s" "registry.example.com/ext/gcr.io/istio-release/proxyv2" .Chart.AppVersion }}`,
+		`registry.example.com/ext/docker.io/busybox:1.36`,
+		// Label keys are not images
+		`app.kubernetes.io/name: {{ .Chart.Name }}`,
+	}
+	lines := strings.Split(got, "\n")
+	for _, line := range expected {
+		if !containsLine(lines, line) {
+			t.Errorf("Expected line %q in:\n%s", line, got)
+		}
+	}
+	if len(rewritten) != 2 {
+		t.Errorf("Expected 2 rewritten images, got %v", rewritten)
+	}
+	t.Log("✓ Image strings in define blocks rewritten")
+}
+
+func TestRewriteTemplateImages_ValueInKey(t *testing.T) {
+	// Image names that also appear in the key are replaced in the value only
+	content := "containers:\n- name: tools\n  image: ma\n- name: shell\n  image: age\n"
+	got, rewritten := rewriteTemplateImages(content, "registry.example.com", "ext")
+
+	lines := strings.Split(got, "\n")
+	for _, line := range []string{"  image: registry.example.com/ext/docker.io/ma", "  image: registry.example.com/ext/docker.io/age"} {
+		if !containsLine(lines, line) {
+			t.Errorf("Expected line %q in:\n%s", line, got)
+		}
+	}
+	if len(rewritten) != 2 {
+		t.Errorf("Expected 2 rewritten images, got %v", rewritten)
+	}
+	t.Log("✓ Literal images rewritten after their key")
+}
+
+func TestUpdateRegistry_TemplateLiterals(t *testing.T) {
+	sidecar := `apiVersion: v1
+kind: Pod
+metadata:
+  name: sidecar
+spec:
+  initContainers:
+  - name: init
+    image: "{{ .Values.initImage }}"
+  containers:
+  - name: sidecar
+    image: "quay.io/team/sidecar:2.0" # pinned upstream
+  - name: helper
+    image: {{ include "app.helperImage" . }}
+`
+	helpers := `{{- define "app.helperImage" -}}
+ghcr.io/team/helper:3.1
+{{- end }}
+`
+	values := "image:\n  repository: docker.io/library/nginx\n  tag: \"1.25\"\ninitImage: example/untraced:1.0\n"
+	chartDir := writeTestChart(t, values, map[string]string{"pod.yaml": phasesTestPod, "sidecar.yaml": sidecar, "_helpers.tpl": helpers})
+	useFileStore(t, NewFileStore(false))
+	opts := Options{ChartPath: chartDir, LocalRepo: "registry.example.com/ext"}
+
+	if err := UpdateRegistry(opts); err != nil {
+		t.Fatalf("UpdateRegistry failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(chartDir, "templates", "sidecar.yaml"))
+	if !strings.Contains(string(data), `image: "registry.example.com/ext/quay.io/team/sidecar:2.0" # pinned upstream`) {
+		t.Errorf("Expected the literal template image to be rewritten:\n%s", data)
+	}
+	data, _ = os.ReadFile(filepath.Join(chartDir, "templates", "_helpers.tpl"))
+	if !strings.Contains(string(data), "registry.example.com/ext/ghcr.io/team/helper:3.1") {
+		t.Errorf("Expected the helper image to be rewritten:\n%s", data)
+	}
+
+	// initImage is a plain key the registry rewrite does not know about
+	untraced, err := untracedImages(opts)
+	if err != nil {
+		t.Fatalf("untracedImages failed: %v", err)
+	}
+	if len(untraced) != 1 || untraced[0] != "example/untraced:1.0" {
+		t.Errorf("Expected only the init image to be untraced, got %v", untraced)
+	}
+	t.Log("✓ Template literals rewritten and untraced images reported")
+}
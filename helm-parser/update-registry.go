@@ -43,6 +43,11 @@ func ExtractImagesFromManifest(manifest string) ([]string, error) {
 		}
 		// Recursively collect images from pod specs
 		collectImagesRecursive(doc, &images)
+		if m, ok := convertMapI2MapS(doc).(map[string]interface{}); ok {
+			for _, c := range customContainers(m) {
+				images = append(images, getImageFromMapS(c))
+			}
+		}
 	}
 
 	// Deduplicate and create a unique list
@@ -133,7 +138,8 @@ func getImageFromMapS(m map[string]interface{}) string {
 }
 
 // Using goroutines and concurrency to check multiple images in parallel.. faster
-func CheckImagesExist(ctx context.Context, images []string, username, password string) (map[string]bool, error) {
+// The keychain resolves credentials per registry; nil means anonymous access.
+func CheckImagesExist(ctx context.Context, images []string, keychain regauthn.Keychain) (map[string]bool, error) {
 	concurrency := 4
 	timeout := 30 * time.Second
 	results := make(map[string]bool, len(images))
@@ -142,12 +148,9 @@ func CheckImagesExist(ctx context.Context, images []string, username, password s
 
 	sem := make(chan struct{}, concurrency)
 
-	// Choose registry authenticator
-	var auth regauthn.Authenticator
-	if username != "" || password != "" {
-		auth = regauthn.FromConfig(regauthn.AuthConfig{Username: username, Password: password})
-	} else {
-		auth = regauthn.Anonymous
+	// Choose registry keychain
+	if keychain == nil {
+		keychain = staticKeychain{}
 	}
 
 	ctx, cancel := context.WithTimeout(ctx, timeout)
@@ -177,7 +180,7 @@ func CheckImagesExist(ctx context.Context, images []string, username, password s
 				return
 			}
 
-			opts := []regremote.Option{regremote.WithAuth(auth), regremote.WithContext(ctx)}
+			opts := []regremote.Option{regremote.WithAuthFromKeychain(keychain), regremote.WithContext(ctx)}
 
 			// Try a HEAD-like check first
 			if _, err := regremote.Head(ref, opts...); err == nil {
@@ -0,0 +1,345 @@
+package helm_parser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template/parse"
+)
+
+// refValue is what a template expression evaluates to, as far as .Values references go
+type refValue struct {
+	root   bool                // The chart's top-level context, e.g. . in a template or $
+	values bool                // A .Values path
+	path   []string            // The .Values path, e.g. [webhook tolerations]
+	dict   map[string]refValue // A dict built in the template, e.g. (dict "value" .Values.x "context" $)
+}
+
+// field returns the value of a field chain, e.g. .Values.webhook on the root context
+func (v refValue) field(names []string) refValue {
+	if len(names) == 0 {
+		return v
+	}
+	switch {
+	case v.root:
+		if names[0] == "Values" {
+			return refValue{values: true, path: slices.Clone(names[1:])}
+		}
+	case v.values:
+		return refValue{values: true, path: append(slices.Clone(v.path), names...)}
+	case v.dict != nil:
+		return v.dict[names[0]].field(names[1:])
+	}
+	return refValue{}
+}
+
+// key identifies a value to remember which templates were already analysed with it
+func (v refValue) key() string {
+	switch {
+	case v.root:
+		return "$"
+	case v.values:
+		return ".Values." + strings.Join(v.path, ".")
+	case v.dict != nil:
+		keys := make([]string, 0, len(v.dict))
+		for k, d := range v.dict {
+			keys = append(keys, k+"="+d.key())
+		}
+		slices.Sort(keys)
+		return "dict(" + strings.Join(keys, ",") + ")"
+	}
+	return ""
+}
+
+// refAnalyzer walks parsed templates and records the .Values paths they read, following
+// include and template calls into the templates defined anywhere in the chart
+type refAnalyzer struct {
+	defines map[string]*parse.Tree // Templates defined with define or block, by name
+	paths   []string               // Paths read, in the order they are found
+	called  map[string]bool        // Template calls already followed, by name and argument
+}
+
+// record remembers a .Values path read by a template
+func (a *refAnalyzer) record(v refValue) refValue {
+	if v.values && len(v.path) > 0 {
+		if p := strings.Join(v.path, "."); !slices.Contains(a.paths, p) {
+			a.paths = append(a.paths, p)
+		}
+	}
+	return v
+}
+
+// callTemplate follows an include or template call with its argument
+func (a *refAnalyzer) callTemplate(name string, arg refValue) {
+	tree, ok := a.defines[name]
+	call := name + "\x00" + arg.key()
+	if !ok || a.called[call] {
+		return
+	}
+	a.called[call] = true
+	a.walk(tree.Root, arg, map[string]refValue{"$": arg})
+}
+
+// walk records the references of a node evaluated with dot and the variables in scope
+func (a *refAnalyzer) walk(node parse.Node, dot refValue, vars map[string]refValue) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			a.walk(child, dot, vars)
+		}
+	case *parse.ActionNode:
+		a.pipe(n.Pipe, dot, vars)
+	case *parse.IfNode:
+		a.pipe(n.Pipe, dot, vars)
+		a.walk(n.List, dot, maps(vars))
+		a.walk(n.ElseList, dot, maps(vars))
+	case *parse.WithNode:
+		// The body is evaluated with the pipeline as dot
+		scope := maps(vars)
+		v := a.pipe(n.Pipe, dot, scope)
+		a.walk(n.List, v, scope)
+		a.walk(n.ElseList, dot, maps(vars))
+	case *parse.RangeNode:
+		// The elements of a list are not tracked
+		scope := maps(vars)
+		a.pipe(n.Pipe, dot, scope)
+		for _, decl := range n.Pipe.Decl {
+			scope[decl.Ident[0]] = refValue{}
+		}
+		a.walk(n.List, refValue{}, scope)
+		a.walk(n.ElseList, dot, maps(vars))
+	case *parse.TemplateNode:
+		var arg refValue
+		if n.Pipe != nil {
+			arg = a.pipe(n.Pipe, dot, vars)
+		}
+		a.callTemplate(n.Name, arg)
+	}
+}
+
+// maps copies the variables in scope for a nested control structure
+func maps(vars map[string]refValue) map[string]refValue {
+	scope := make(map[string]refValue, len(vars))
+	for k, v := range vars {
+		scope[k] = v
+	}
+	return scope
+}
+
+// pipe evaluates a pipeline, declaring or assigning its variables
+func (a *refAnalyzer) pipe(p *parse.PipeNode, dot refValue, vars map[string]refValue) refValue {
+	if p == nil {
+		return refValue{}
+	}
+	var v refValue
+	for i, cmd := range p.Cmds {
+		var piped *refValue
+		if i > 0 {
+			piped = &v
+		}
+		v = a.command(cmd, dot, vars, piped)
+	}
+	for _, decl := range p.Decl {
+		vars[decl.Ident[0]] = v
+	}
+	return v
+}
+
+// command evaluates a command; piped is the result of the previous command of the pipeline
+func (a *refAnalyzer) command(cmd *parse.CommandNode, dot refValue, vars map[string]refValue, piped *refValue) refValue {
+	args := make([]refValue, 0, len(cmd.Args))
+	for _, arg := range cmd.Args[1:] {
+		args = append(args, a.arg(arg, dot, vars))
+	}
+	if piped != nil {
+		args = append(args, *piped)
+	}
+
+	ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+	if !ok {
+		return a.arg(cmd.Args[0], dot, vars)
+	}
+	switch ident.Ident {
+	case "include":
+		if name, ok := cmd.Args[1].(*parse.StringNode); ok && len(args) > 1 {
+			a.callTemplate(name.Text, args[1])
+		}
+	case "dict":
+		d := make(map[string]refValue)
+		for i := 1; i+1 < len(cmd.Args); i += 2 {
+			if k, ok := cmd.Args[i].(*parse.StringNode); ok {
+				d[k.Text] = args[i]
+			}
+		}
+		return refValue{dict: d}
+	case "index":
+		// index .Values "a" "b" is .Values.a.b; a key that is not a literal stops the path
+		if len(cmd.Args) < 2 {
+			return refValue{}
+		}
+		v := args[0]
+		for _, key := range cmd.Args[2:] {
+			k, ok := key.(*parse.StringNode)
+			if !ok {
+				a.record(v)
+				return refValue{}
+			}
+			v = v.field([]string{k.Text})
+		}
+		return a.record(v)
+	case "default", "required", "coalesce", "ternary":
+		// These return one of their arguments, usually the last one
+		if len(args) > 0 {
+			return args[len(args)-1]
+		}
+	}
+	return refValue{}
+}
+
+// arg evaluates an argument of a command
+func (a *refAnalyzer) arg(node parse.Node, dot refValue, vars map[string]refValue) refValue {
+	switch n := node.(type) {
+	case *parse.DotNode:
+		return dot
+	case *parse.FieldNode:
+		return a.record(dot.field(n.Ident))
+	case *parse.VariableNode:
+		return a.record(vars[n.Ident[0]].field(n.Ident[1:]))
+	case *parse.ChainNode:
+		return a.record(a.arg(n.Node, dot, vars).field(n.Field))
+	case *parse.PipeNode:
+		return a.pipe(n, dot, maps(vars))
+	}
+	return refValue{}
+}
+
+// parseTemplate parses a template into trees, adding its defines to treeSet. Functions are
+// not checked since the Helm and Sprig functions are not known here.
+func parseTemplate(name, content string, treeSet map[string]*parse.Tree) error {
+	t := parse.New(name)
+	t.Mode = parse.SkipFuncCheck
+	_, err := t.Parse(content, "", "", treeSet)
+	return err
+}
+
+// AnalyzeValueReferences returns the .Values references of every template of a chart. The
+// templates are parsed, include and template calls are followed into the templates defined
+// in other files (e.g. _helpers.tpl), and variables ($root := .Values), $.Values and
+// index .Values "key" are resolved. Helpers and templates that cannot be parsed are
+// scanned with DetectValueReferences. The documents of a multi-document template also get
+// their own references under documentKey, unless they do not parse on their own, e.g. when
+// they use a variable set in an earlier document.
+func AnalyzeValueReferences(templatesPath string) (map[string][]ValueReference, error) {
+	contents := make(map[string]string)
+	var files []string
+	err := filepath.Walk(templatesPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		content, err := Files.ReadFile(path)
+		if err != nil {
+			return nil // Skip files we can't read
+		}
+		contents[path] = string(content)
+		files = append(files, path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse every file first so defines are known whatever file calls them
+	treeSet := make(map[string]*parse.Tree)
+	parsed := make(map[string]bool)
+	renderedKeys := make(map[string]string)
+	for _, path := range files {
+		if err := parseTemplate(path, contents[path], treeSet); err != nil {
+			Logger.Warnf("Could not parse %s, scanning it line by line: %v", path, err)
+			continue
+		}
+		parsed[path] = true
+		for p, key := range blockValueKeys(strings.Split(contents[path], "\n")) {
+			renderedKeys[p] = key
+		}
+	}
+
+	refs := make(map[string][]ValueReference)
+	for _, path := range files {
+		// Helpers render nothing on their own; their references are kept as written
+		if strings.HasPrefix(filepath.Base(path), "_") {
+			refs[path] = DetectValueReferences(contents[path])
+			continue
+		}
+		docs := templateDocuments(contents[path])
+		if !parsed[path] {
+			refs[path] = DetectValueReferences(contents[path])
+			for i := 0; len(docs) > 1 && i < len(docs); i++ {
+				refs[documentKey(path, i)] = DetectValueReferences(docs[i])
+			}
+			continue
+		}
+		refs[path] = treeReferences(treeSet[path], treeSet, renderedKeys)
+		if len(docs) < 2 {
+			continue
+		}
+		for i, doc := range docs {
+			// Parse into a copy so the document trees stay out of the defines of the chart
+			docTrees := make(map[string]*parse.Tree, len(treeSet)+1)
+			for name, tree := range treeSet {
+				docTrees[name] = tree
+			}
+			name := documentKey(path, i)
+			if err := parseTemplate(name, doc, docTrees); err != nil {
+				continue
+			}
+			refs[name] = treeReferences(docTrees[name], docTrees, renderedKeys)
+		}
+	}
+	return refs, nil
+}
+
+// treeReferences returns the references of a parsed template, following its calls into defines
+func treeReferences(tree *parse.Tree, defines map[string]*parse.Tree, renderedKeys map[string]string) []ValueReference {
+	a := &refAnalyzer{defines: defines, called: make(map[string]bool)}
+	if tree != nil {
+		root := refValue{root: true}
+		a.walk(tree.Root, root, map[string]refValue{"$": root})
+	}
+	var refs []ValueReference
+	for _, p := range a.paths {
+		ref := parseValuePath(p)
+		if key, ok := renderedKeys[p]; ok {
+			ref.Key = key
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// documentKey names document i of a template, e.g. templates/rbac.yaml#1
+func documentKey(path string, i int) string {
+	return fmt.Sprintf("%s#%d", path, i)
+}
+
+// valueReferences returns the references of a template from the chart analysis, or scans
+// the template when the analysis does not cover it
+func valueReferences(chartRefs map[string][]ValueReference, path, content string) []ValueReference {
+	if refs, ok := chartRefs[path]; ok {
+		return refs
+	}
+	return DetectValueReferences(content)
+}
+
+// documentReferences returns the references of document i of a template: its own when the
+// analysis has them, otherwise those of the whole template
+func documentReferences(chartRefs map[string][]ValueReference, path string, i int, content string) []ValueReference {
+	if refs, ok := chartRefs[documentKey(path, i)]; ok {
+		return refs
+	}
+	return valueReferences(chartRefs, path, content)
+}
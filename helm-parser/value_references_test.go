@@ -0,0 +1,117 @@
+package helm_parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const referenceTestHelpers = `{{- define "chart.podScheduling" -}}
+{{- with .Values.tolerations }}
+tolerations:
+  {{- toYaml . | nindent 2 }}
+{{- end }}
+{{- include "chart.affinity" $ }}
+{{- end }}
+
+{{- define "chart.affinity" -}}
+{{- $root := .Values }}
+{{- with $root.controller.affinity }}
+affinity:
+  {{- toYaml . | nindent 2 }}
+{{- end }}
+{{- end }}
+
+{{- define "chart.render" -}}
+{{- tpl (toYaml .value) .context }}
+{{- end }}
+
+{{- define "chart.loop" -}}
+{{- include "chart.loop" . }}
+{{- end }}
+`
+
+const referenceTestDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ include "chart.fullname" . }}
+spec:
+  template:
+    spec:
+      {{- include "chart.podScheduling" . | nindent 6 }}
+      {{- include "chart.loop" . }}
+      nodeSelector:
+        {{- include "chart.render" (dict "value" (index .Values "controller" "nodeSelector") "context" $) | nindent 8 }}
+      {{- range .Values.extraVolumes }}
+      serviceAccountName: {{ $.Values.serviceAccount.name }}
+      {{- end }}
+      containers:
+      - name: app
+        image: {{ .Values.image.repository }}
+`
+
+func TestAnalyzeValueReferences(t *testing.T) {
+	chartDir := writeTestChart(t, "", map[string]string{
+		"_helpers.tpl":    referenceTestHelpers,
+		"deployment.yaml": referenceTestDeployment,
+	})
+	useFileStore(t, NewFileStore(false))
+
+	refs, err := AnalyzeValueReferences(filepath.Join(chartDir, "templates"))
+	if err != nil {
+		t.Fatalf("AnalyzeValueReferences failed: %v", err)
+	}
+	got := make(map[string]string)
+	for _, ref := range refs[filepath.Join(chartDir, "templates", "deployment.yaml")] {
+		got[strings.Join(ref.Path, ".")] = ref.Key
+	}
+	want := map[string]string{
+		"tolerations":             "tolerations",
+		"controller.affinity":     "affinity",
+		"controller.nodeSelector": "nodeSelector",
+		"extraVolumes":            "extraVolumes",
+		"serviceAccount.name":     "name",
+		"image.repository":        "repository",
+	}
+	for path, key := range want {
+		if got[path] != key {
+			t.Errorf("Expected .Values.%s with key %q, got %q (all: %v)", path, key, got[path], got)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("Expected %d references, got %v", len(want), got)
+	}
+	t.Log("✓ References followed through includes, variables, dicts and index")
+}
+
+func TestProcessTemplates_HelperReferences(t *testing.T) {
+	blocksPath := filepath.Join(t.TempDir(), "inject-blocks.yaml")
+	blocks := `allPods:
+- tolerations:
+  - key: platform/dedicated
+    operator: Exists
+allContainers: []
+`
+	if err := os.WriteFile(blocksPath, []byte(blocks), 0644); err != nil {
+		t.Fatalf("Failed to write blocks: %v", err)
+	}
+	chartDir := writeTestChart(t, "tolerations: []\n", map[string]string{
+		"_helpers.tpl":    referenceTestHelpers,
+		"deployment.yaml": referenceTestDeployment,
+	})
+	useFileStore(t, NewFileStore(false))
+
+	if err := ProcessTemplates(chartDir, nil, blocksPath, nil, "", RenderOptions{}); err != nil {
+		t.Fatalf("ProcessTemplates failed: %v", err)
+	}
+	template, _ := os.ReadFile(filepath.Join(chartDir, "templates", "deployment.yaml"))
+	if string(template) != referenceTestDeployment {
+		t.Errorf("Expected tolerations rendered by a helper not to be injected inline, got:\n%s", template)
+	}
+	values, _ := os.ReadFile(filepath.Join(chartDir, "values.yaml"))
+	if !strings.Contains(string(values), "platform/dedicated") {
+		t.Errorf("Expected tolerations injected into values.yaml, got:\n%s", values)
+	}
+	t.Log("✓ Keys set through helpers injected into values instead of inline")
+}
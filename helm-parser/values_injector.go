@@ -1,11 +1,14 @@
 package helm_parser
 
 import (
+	"bytes"
 	"fmt"
-	"os"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
 )
 
 var (
@@ -16,28 +19,26 @@ var (
 )
 
 // InjectIntoValuesFile injects blocks into the values.yaml file
-// It detects which sections are referenced in templates and injects accordingly
-func InjectIntoValuesFile(chartDir string, blocks InjectorBlocks, referencedPaths []ValueReference, criticalDs bool, controlPlane bool, systemCritical string) error {
+// It detects which sections are referenced in templates and injects accordingly.
+// The blocks of categories (by target, see enabledCategories) are injected.
+func InjectIntoValuesFile(chartDir string, blocks InjectorBlocks, referencedPaths []ValueReference, categories map[string][]string) error {
 	//DEBUG
 	//Logger.Info("inside InjectIntoValuesFile")
 	if len(referencedPaths) == 0 {
 		return nil
 	}
+	// Values cannot tell init containers from containers, so only the container categories apply
+	podBlocks := categoryBlocks(blocks, categories[TargetPods])
+	containerBlocks := categoryBlocks(blocks, categories[TargetContainers])
 
 	valuesPath := filepath.Join(chartDir, "values.yaml")
 
 	// Read the values file. This should include previous changes we made in registry updates.
-	content, err := os.ReadFile(valuesPath)
+	content, err := Files.ReadFile(valuesPath)
 	if err != nil {
 		return fmt.Errorf("failed to read values.yaml: %v", err)
 	}
 
-	// Detect if this uses a wrapper pattern (e.g., Istio's _internal_defaults_do_not_set)
-	indentOffset := detectWrapperPattern(string(content))
-	// if indentOffset > 0 {
-	// 	Logger.Infof("Detected wrapper pattern with indent offset: %d", indentOffset)
-	// }
-
 	modifiedContent := string(content)
 	modified := false
 	//DEBUG
@@ -45,65 +46,16 @@ func InjectIntoValuesFile(chartDir string, blocks InjectorBlocks, referencedPath
 
 	// Process each referenced path
 	for _, ref := range referencedPaths {
-		var injectedBlocks []string
+		var injectedBlocks []InjectorBlock
 
 		// Determine which blocks to inject based on the key
 		// First check if it's a pod-level key
 		if slices.Contains(podConfigKeys, ref.Key) {
 			// Pod-level blocks
-			// We need to add new keys as we go, so handle each key specifically
-			switch ref.Key {
-			case "tolerations":
-				injectedBlocks = getPodBlocksByKey(blocks["allPods"], "tolerations")
-				if criticalDs {
-					critDsBlocks := getPodBlocksByKey(blocks["criticalDsPods"], "tolerations")
-					injectedBlocks = append(injectedBlocks, critDsBlocks...)
-				}
-				if controlPlane {
-					cpBlocks := getPodBlocksByKey(blocks["controlPlanePods"], "tolerations")
-					injectedBlocks = append(injectedBlocks, cpBlocks...)
-				}
-			case "affinity":
-				injectedBlocks = getPodBlocksByKey(blocks["allPods"], "affinity")
-				if criticalDs {
-					critDsBlocks := getPodBlocksByKey(blocks["criticalDsPods"], "affinity")
-					injectedBlocks = append(injectedBlocks, critDsBlocks...)
-				}
-				if controlPlane {
-					cpBlocks := getPodBlocksByKey(blocks["controlPlanePods"], "affinity")
-					injectedBlocks = append(injectedBlocks, cpBlocks...)
-				}
-			case "nodeSelector":
-				injectedBlocks = getPodBlocksByKey(blocks["allPods"], "nodeSelector")
-
-			case "priorityClassName":
-				//DEBUG
-				//Logger.Infof("injected blocks before priorityClass: %v", injectedBlocks)
-
-				injectedBlocks = getPodBlocksByKey(blocks["allPods"], "priorityClassName")
-
-				//DEBUG
-				// Logger.Infof("injected blocks after allPods priorityClass: %v", injectedBlocks)
-				// fmt.Println("Press 'Enter' to continue...")
-				// fmt.Scanln()
-
-				// 	Logger.Infof("Processing priorityClassName with systemCritical=%s", systemCritical)
-				// 	switch systemCritical {
-				// 	case "node":
-				// 		injectedBlocks = getBlocksByKey(blocks["systemCriticalNodePods"], "priorityClassName")
-				// 		Logger.Infof("node: found %d blocks", len(injectedBlocks))
-				// 	case "cluster":
-				// 		injectedBlocks = getBlocksByKey(blocks["systemCriticalClusterPods"], "priorityClassName")
-				// 		Logger.Infof("cluster: found %d blocks", len(injectedBlocks))
-				// 	default:
-				// 		injectedBlocks = getBlocksByKey(blocks["systemCriticalDefaultPods"], "priorityClassName")
-				// 		Logger.Infof("default: found %d blocks", len(injectedBlocks))
-				// 		// Non-system-critical pods get no priorityClassName injection
-				// 	}
-			}
+			injectedBlocks = getPodBlocksByKey(podBlocks, ref.Key)
 		} else if slices.Contains(containerConfigKeys, ref.Key) {
 			// Container-level blocks - dynamically check all container blocks
-			injectedBlocks = getContainerBlocksByKey(blocks["allContainers"], ref.Key)
+			injectedBlocks = getContainerBlocksByKey(containerBlocks, ref.Key)
 			// If no blocks found, skip this key
 			if len(injectedBlocks) == 0 {
 				continue
@@ -120,7 +72,7 @@ func InjectIntoValuesFile(chartDir string, blocks InjectorBlocks, referencedPath
 		// Inject the blocks into the values file at the specified path
 
 		if len(injectedBlocks) > 0 {
-			newContent, changed, actuallyInjected := injectBlockIntoValuesPath(modifiedContent, ref, injectedBlocks, indentOffset)
+			newContent, changed, actuallyInjected := injectBlockIntoValuesPath(modifiedContent, ref, injectedBlocks)
 			if changed {
 				modifiedContent = newContent
 				modified = true
@@ -132,7 +84,7 @@ func InjectIntoValuesFile(chartDir string, blocks InjectorBlocks, referencedPath
 	}
 
 	if modified {
-		if err := os.WriteFile(valuesPath, []byte(modifiedContent), 0644); err != nil {
+		if err := Files.WriteFile(valuesPath, []byte(modifiedContent), 0644); err != nil {
 			return fmt.Errorf("failed to write updated values.yaml: %v", err)
 		}
 		Logger.Infof("Updated values.yaml with injected blocks")
@@ -141,309 +93,327 @@ func InjectIntoValuesFile(chartDir string, blocks InjectorBlocks, referencedPath
 	return nil
 }
 
-// injectBlockIntoValuesPath injects blocks into a specific path in values.yaml
-// This works for any nested structure, e.g., ["tolerations"] or ["webhook", "tolerations"]
-// indentOffset handles wrapper patterns like _internal_defaults_do_not_set
-// Returns: (newContent, fileModified, actuallyInjected)
-func injectBlockIntoValuesPath(content string, ref ValueReference, blocks []string, indentOffset int) (string, bool, bool) {
-	lines := strings.Split(content, "\n")
-	var result []string
-	modified := false
-	actuallyInjected := false
+// valuesFrame is one step of a resolved values path: a key of a mapping or an item of a sequence
+type valuesFrame struct {
+	parent *yamlv3.Node // Mapping or sequence holding the step
+	index  int          // Index of the key node in a mapping, or of the item in a sequence
+}
 
-	// Build current path as we traverse the file
-	pathStack := NewPathStack()
+// value returns the node the frame points at
+func (f valuesFrame) value() *yamlv3.Node {
+	if f.parent.Kind == yamlv3.MappingNode {
+		return f.parent.Content[f.index+1]
+	}
+	return f.parent.Content[f.index]
+}
 
-	for i := 0; i < len(lines); i++ {
-		line := lines[i]
-		yl := ParseLine(line)
-		actualIndent := yl.Indent
+// setValue replaces the node the frame points at
+func (f valuesFrame) setValue(node *yamlv3.Node) {
+	if f.parent.Kind == yamlv3.MappingNode {
+		f.parent.Content[f.index+1] = node
+	} else {
+		f.parent.Content[f.index] = node
+	}
+}
 
-		// Skip empty lines and comments - add to result and continue
-		if yl.IsEmpty || yl.IsComment {
-			result = append(result, line)
+// blockValue combines the values of key from every block into one node: lists are
+// concatenated, maps are merged and for scalars the last block wins
+func blockValue(blocks []InjectorBlock, key string) *yamlv3.Node {
+	var combined *yamlv3.Node
+	for _, block := range blocks {
+		root, err := parseValuesNode(block.Block)
+		if err != nil {
+			Logger.Warnf("Skipping invalid %s block: %v", key, err)
 			continue
 		}
-
-		// Check if this is the wrapper line itself - preserve it and skip processing
-		if indentOffset > 0 && actualIndent == 0 && yl.HasColon {
-			if slices.Contains(KnownWrapperKeys, yl.Key) {
-				result = append(result, line)
-				continue
+		_, value := mappingValue(root, key)
+		if value == nil {
+			continue
+		}
+		value = copyNode(value)
+		switch {
+		case combined == nil:
+			combined = value
+		case combined.Kind == yamlv3.SequenceNode && value.Kind == yamlv3.SequenceNode:
+			for _, item := range value.Content {
+				if !containsListItem(key, combined.Content, item) {
+					combined.Content = append(combined.Content, item)
+				}
 			}
+		case combined.Kind == yamlv3.MappingNode && value.Kind == yamlv3.MappingNode:
+			mergeMappingNodes(combined, value)
+		default:
+			combined = value
 		}
+	}
+	return combined
+}
 
-		// Apply virtual indent offset - treat wrapper content as if at higher root
-		indent := actualIndent
-		if indentOffset > 0 && actualIndent >= indentOffset {
-			indent = actualIndent - indentOffset
+// mergeMappingNodes deep merges src into dst; src wins for anything that is not a map
+func mergeMappingNodes(dst, src *yamlv3.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		idx := mappingKeyIndex(dst, src.Content[i].Value)
+		if idx < 0 {
+			dst.Content = append(dst.Content, src.Content[i], src.Content[i+1])
+			continue
+		}
+		if dst.Content[idx+1].Kind == yamlv3.MappingNode && src.Content[i+1].Kind == yamlv3.MappingNode {
+			mergeMappingNodes(dst.Content[idx+1], src.Content[i+1])
+		} else {
+			dst.Content[idx+1] = src.Content[i+1]
 		}
+	}
+}
+
+// wrapperFrame returns the frame of a known wrapper key (e.g. Istio's _internal_defaults_do_not_set)
+// when it is the first key of values.yaml; its value is then treated as the values root
+func wrapperFrame(root *yamlv3.Node) (valuesFrame, bool) {
+	if root.Kind != yamlv3.MappingNode || len(root.Content) < 2 {
+		return valuesFrame{}, false
+	}
+	if slices.Contains(KnownWrapperKeys, root.Content[0].Value) && resolveAlias(root.Content[1]).Kind == yamlv3.MappingNode {
+		return valuesFrame{parent: root, index: 0}, true
+	}
+	return valuesFrame{}, false
+}
+
+// frameEndLine returns the last line (1-based) that belongs to the value of frames[i],
+// i.e. the line before the next sibling, or the end of the enclosing frame
+func frameEndLine(frames []valuesFrame, i int, total int) int {
+	f := frames[i]
+	step := 1
+	if f.parent.Kind == yamlv3.MappingNode {
+		step = 2
+	}
+	if next := f.index + step; next < len(f.parent.Content) {
+		return f.parent.Content[next].Line - 1
+	}
+	if i == 0 {
+		return total
+	}
+	return frameEndLine(frames, i-1, total)
+}
+
+// trimFrameEnd drops trailing blank lines and the comments of the next key (comments at
+// or left of indent) from the end of a line range
+func trimFrameEnd(lines []string, start, end, indent int) int {
+	for end > start {
+		trimmed := strings.TrimSpace(lines[end-1])
+		if trimmed != "" && !(strings.HasPrefix(trimmed, "#") && GetIndentation(lines[end-1]) <= indent) {
+			break
+		}
+		end--
+	}
+	return end
+}
+
+// injectBlockIntoValuesPath injects blocks at a specific path in values.yaml, e.g. ["tolerations"],
+// ["webhook", "tolerations"] or ["gateways", "0", "tolerations"]. The path is resolved on the
+// yaml.v3 node tree, so flow style maps, multi-line strings, anchors and aliases are handled.
+// Only the lines of the edited key are re-encoded; the rest of the file, including comments
+// and key order, is left untouched. Paths under a known wrapper key are resolved relative to it.
+// The merge strategy of the blocks decides how they combine with a value that is already set.
+// Returns: (newContent, fileModified, actuallyInjected)
+func injectBlockIntoValuesPath(content string, ref ValueReference, blocks []InjectorBlock) (string, bool, bool) {
+	root, err := parseValuesNode(content)
+	if err != nil {
+		Logger.Warnf("Could not parse values.yaml to inject %s: %v", ref.Key, err)
+		return content, false, false
+	}
+	injected := blockValue(blocks, ref.Key)
+	if injected == nil || len(ref.Path) == 0 {
+		return content, false, false
+	}
+	strategy := keyStrategy(blocks, injected)
 
-		// Update path stack based on virtual indentation
-		pathStack.PopToIndent(indent)
-
-		// Extract key from line if it's a key:value line
-		if yl.HasColon {
-			// Add to path stack
-			pathStack.Push(indent, yl.Key)
-
-			// Build current path from stack
-			currentPath := pathStack.CurrentPath()
-
-			// Check if we've reached the target path
-			if pathsMatch(currentPath, ref.Path) {
-				// Found it! Now inject
-				isEmpty := yl.Value == "" || yl.Value == "[]" || yl.Value == "{}"
-
-				if isEmpty {
-					// Empty inline value - but check if there's content on subsequent lines
-					// For complex nested structures (not list-based), check for existing content
-
-					if slices.Contains(podConfigKeys, yl.Key) || isComplexNestedBlock(yl.Key, blocks) {
-						j := i + 1
-						hasExistingContent := false
-						for j < len(lines) {
-							nextLine := lines[j]
-							nextIndent := getIndentation(nextLine)
-							nextTrimmed := strings.TrimSpace(nextLine)
-
-							if nextTrimmed == "" || strings.HasPrefix(nextTrimmed, "#") {
-								j++
-								continue
-							}
-
-							if nextIndent <= actualIndent {
-								break
-							}
-
-							// Found content at higher indent - already has content
-							hasExistingContent = true
-							break
-						}
-
-						if hasExistingContent {
-							// Already has content - behavior depends on block type
-
-							if yl.Key == "tolerations" {
-								// For tolerations, collect existing and only add new ones (merge behavior)
-								// append the key line first ("tolerations:", "affinity:", etc.)
-								result = append(result, line)
-								var existingContent []string
-								j = i + 1
-								for j < len(lines) {
-									nextLine := lines[j]
-									nextIndent := getIndentation(nextLine)
-									nextTrimmed := strings.TrimSpace(nextLine)
-									// inject empty lines and comments as-is
-									if nextTrimmed == "" || strings.HasPrefix(nextTrimmed, "#") {
-										result = append(result, nextLine)
-										j++
-										continue
-									}
-									// if the indent is less than or equal to actualIndent, we've reached end of this block
-									if nextIndent <= actualIndent {
-										break
-									}
-
-									result = append(result, nextLine)
-									existingContent = append(existingContent, nextTrimmed)
-									j++
-								}
-
-								// Check if blocks to inject already exist
-								injected := injectBlockLines(blocks, actualIndent+2, yl.Key)
-								for _, injLine := range injected {
-									injTrimmed := strings.TrimSpace(injLine)
-									if !containsLine(existingContent, injTrimmed) {
-										result = append(result, injLine)
-										modified = true
-										actuallyInjected = true
-									}
-								}
-								// Mark as injected even if all blocks already exist
-								actuallyInjected = true
-								i = j - 1
-								continue
-							} else if yl.Key == "affinity" || isComplexNestedBlock(yl.Key, blocks) {
-								// For complex nested structures (affinity, resources, etc.), replace existing content
-								// Skip the existing content
-								j = i + 1
-								for j < len(lines) {
-									nextLine := lines[j]
-									nextIndent := getIndentation(nextLine)
-									nextTrimmed := strings.TrimSpace(nextLine)
-
-									if nextTrimmed == "" || strings.HasPrefix(nextTrimmed, "#") {
-										j++
-										continue
-									}
-									// if the indent is less than or equal to actualIndent, we've reached end of this block
-									if nextIndent <= actualIndent {
-										break
-									}
-									j++
-								}
-
-								// Now inject our new content
-								result = append(result, strings.Repeat(" ", actualIndent)+yl.Key+":")
-								injected := injectBlockLines(blocks, actualIndent+2, yl.Key)
-								result = append(result, injected...)
-								modified = true
-								actuallyInjected = true
-
-								i = j - 1
-								continue
-							}
-						}
-					}
-
-					// Empty value and no existing content - inject our blocks
-					// Use actualIndent for writing back to preserve file structure
-					result = append(result, strings.Repeat(" ", actualIndent)+yl.Key+":")
-					injected := injectBlockLines(blocks, actualIndent+2, yl.Key)
-					result = append(result, injected...)
-					modified = true
-					actuallyInjected = true
-
-					// Skip original value line if it was inline []  or {}
-					if yl.Value == "[]" || yl.Value == "{}" {
-						continue
-					}
-				} else {
-					// Has existing content - check if our content already exists
-
-					// For simple scalar values (like priorityClassName: "value"), replace the entire line
-					if !isComplexNestedBlock(yl.Key, blocks) && !isListBasedBlock(yl.Key, blocks) {
-						Logger.Infof("Found scalar value for key %s:%s, replacing with injected content", yl.Key, yl.Value)
-						// Simple scalar value - replace with injected content
-						injected := injectBlockLines(blocks, actualIndent, yl.Key)
-						if len(injected) > 0 {
-							result = append(result, injected...)
-							modified = true
-							actuallyInjected = true
-							Logger.Infof("Replaced scalar value for key=%s", yl.Key)
-						} else {
-							result = append(result, line)
-						}
-						continue
-					}
-
-					result = append(result, line)
-
-					// For tolerations, check if blocks already exist before appending
-					if yl.Key == "tolerations" {
-						// Collect existing tolerations content
-						var existingContent []string
-						j := i + 1
-						for j < len(lines) {
-							nextLine := lines[j]
-							nextIndent := getIndentation(nextLine)
-							nextTrimmed := strings.TrimSpace(nextLine)
-
-							if nextTrimmed == "" || strings.HasPrefix(nextTrimmed, "#") {
-								result = append(result, nextLine)
-								j++
-								continue
-							}
-
-							// Use actualIndent for comparison to respect file structure
-							if nextIndent <= actualIndent {
-								break
-							}
-
-							result = append(result, nextLine)
-							existingContent = append(existingContent, nextTrimmed)
-							j++
-						}
-
-						//TO BE CONTINUED FROM HERE -------->>>>>>>>>>>>>>>> code duplication
-						// Check if blocks to inject already exist
-						injected := injectBlockLines(blocks, actualIndent+2, yl.Key)
-						for _, injLine := range injected {
-							injTrimmed := strings.TrimSpace(injLine)
-							if !containsLine(existingContent, injTrimmed) {
-								result = append(result, injLine)
-								modified = true
-								actuallyInjected = true
-							}
-						}
-						// Mark as injected even if nothing was added (found existing tolerations)
-						actuallyInjected = true
-						i = j - 1
-						continue
-					} else if yl.Key == "affinity" || isComplexNestedBlock(yl.Key, blocks) {
-						// For complex nested structures, if there's already content, skip injection entirely
-						// These structures are complex - don't try to merge
-						j := i + 1
-						hasExistingContent := false
-						for j < len(lines) {
-							nextLine := lines[j]
-							nextIndent := getIndentation(nextLine)
-							nextTrimmed := strings.TrimSpace(nextLine)
-
-							if nextTrimmed == "" || strings.HasPrefix(nextTrimmed, "#") {
-								result = append(result, nextLine)
-								j++
-								continue
-							}
-
-							if nextIndent <= actualIndent {
-								break
-							}
-
-							result = append(result, nextLine)
-							hasExistingContent = true
-							j++
-						}
-
-						// Only inject if there's no existing content
-						if !hasExistingContent {
-							injected := injectBlockLines(blocks, actualIndent+2, yl.Key)
-							result = append(result, injected...)
-							modified = true
-							actuallyInjected = true
-						}
-						i = j - 1
-						continue
-					}
+	var frames []valuesFrame
+	node := root
+	if wf, ok := wrapperFrame(root); ok {
+		frames = append(frames, wf)
+		node = resolveAlias(wf.value())
+	}
+	baseDepth := len(frames)
+	// splice is the frame whose key/value lines are re-encoded: the target key unless it sits
+	// in a flow collection or below an alias, which must be rewritten from further up
+	splice := -1
+
+	for i, seg := range ref.Path {
+		last := i == len(ref.Path)-1
+		switch node.Kind {
+		case yamlv3.MappingNode:
+			idx := mappingKeyIndex(node, seg)
+			if idx < 0 {
+				if !last {
+					return content, false, false
 				}
-				continue
+				// Missing key: add it to the mapping, starting from any value inherited through <<
+				existing := mergedValue(node, seg)
+				if existing != nil {
+					existing = copyNode(existing)
+				}
+				value, changed := mergeValueNodes(ref.Key, existing, injected, strategy)
+				if !changed {
+					return content, false, true
+				}
+				return addValuesKey(content, root, frames, node, seg, value, len(frames) == baseDepth)
 			}
+			frames = append(frames, valuesFrame{parent: node, index: idx})
+		case yamlv3.SequenceNode:
+			idx, err := strconv.Atoi(seg)
+			if err != nil || idx < 0 || idx >= len(node.Content) || last {
+				return content, false, false
+			}
+			frames = append(frames, valuesFrame{parent: node, index: idx})
+		default:
+			return content, false, false
 		}
 
-		result = append(result, line)
+		f := frames[len(frames)-1]
+		if isFlowNode(f.parent) && splice < 0 {
+			splice = len(frames) - 2
+		}
+		// Editing through an alias would change the anchored original, so work on a copy
+		if f.value().Kind == yamlv3.AliasNode {
+			f.setValue(copyNode(f.value()))
+			if splice < 0 {
+				splice = len(frames) - 1
+			}
+		}
+		node = f.value()
 	}
 
-	// If we didn't find the path and inject, check if it's a root-level key that needs to be added
-	if !actuallyInjected && len(ref.Path) == 1 {
-		// Root-level key doesn't exist - append it at the end
-		key := ref.Path[0]
+	target := frames[len(frames)-1]
+	existing := target.value()
+	existingItems := 0
+	if existing.Kind == yamlv3.SequenceNode {
+		existingItems = len(existing.Content)
+	}
+	value, changed := mergeValueNodes(ref.Key, existing, injected, strategy)
+	if !changed {
+		return content, false, true
+	}
+	target.setValue(value)
+	// Items appended to a block list are added after it, leaving the existing items as written
+	if splice < 0 && value == existing && existing.Kind == yamlv3.SequenceNode && !isFlowNode(existing) && existingItems > 0 &&
+		target.parent.Kind == yamlv3.MappingNode && !isFlowNode(target.parent) {
+		return appendValuesItems(content, frames, existing.Content[existingItems:], existing.Column-1)
+	}
 
-		// Add a blank line before the new section if file doesn't end with blank line
-		if len(result) > 0 && strings.TrimSpace(result[len(result)-1]) != "" {
-			result = append(result, "")
-		}
+	if splice < 0 {
+		splice = len(frames) - 1
+	}
+	// The splice frame must be a key of a block mapping
+	for splice >= 0 && (frames[splice].parent.Kind != yamlv3.MappingNode || isFlowNode(frames[splice].parent)) {
+		splice--
+	}
+	if splice < 0 {
+		return encodeValuesDocument(content, root)
+	}
+	newContent, err := spliceValuesFrame(content, frames, splice)
+	if err != nil {
+		Logger.Warnf("Could not inject %s at %v: %v", ref.Key, ref.Path, err)
+		return content, false, false
+	}
+	return newContent, true, true
+}
 
-		// Add the new key and its blocks
-		// If there's a wrapper pattern, indent the key appropriately
-		keyIndent := indentOffset
+// spliceValuesFrame re-encodes the key/value pair of frames[i] in place of its original lines
+func spliceValuesFrame(content string, frames []valuesFrame, i int) (string, error) {
+	lines := strings.Split(content, "\n")
+	key := frames[i].parent.Content[frames[i].index]
+	start := key.Line
+	keyLine := lines[start-1]
+	keyOffset := runeColumnToByte(keyLine, key.Column)
+	indent := key.Column - 1
+	end := trimFrameEnd(lines, start, frameEndLine(frames, i, len(lines)), indent)
+
+	encoded, err := encodePair(key, frames[i].value(), keyLine[:keyOffset], indent)
+	if err != nil {
+		return content, err
+	}
+	result := append(append(append([]string{}, lines[:start-1]...), encoded...), lines[end:]...)
+	newContent := strings.Join(result, "\n")
+	if _, err := parseValuesNode(newContent); err != nil {
+		return content, fmt.Errorf("injected values do not parse: %v", err)
+	}
+	return newContent, nil
+}
 
-		// Check if this is a single-line scalar value (like "priorityClassName: value")
-		if isSingleLineScalar(blocks, key) {
-			// For single-line scalars, the block already contains the key, so just add it directly
-			injected := injectBlockLines(blocks, keyIndent, key)
-			result = append(result, injected...)
-		} else {
-			// For multi-line blocks, add the key line first, then the content
-			result = append(result, strings.Repeat(" ", keyIndent)+key+":")
-			injected := injectBlockLines(blocks, 2+indentOffset, key)
-			result = append(result, injected...)
+// appendValuesItems inserts list items after the last item of the list value of the last frame
+func appendValuesItems(content string, frames []valuesFrame, items []*yamlv3.Node, itemIndent int) (string, bool, bool) {
+	lines := strings.Split(content, "\n")
+	i := len(frames) - 1
+	key := frames[i].parent.Content[frames[i].index]
+	end := trimFrameEnd(lines, key.Line, frameEndLine(frames, i, len(lines)), key.Column-1)
+
+	encoded, err := encodeNode(&yamlv3.Node{Kind: yamlv3.SequenceNode, Tag: "!!seq", Content: items}, itemIndent)
+	if err != nil {
+		Logger.Warnf("Could not encode %s: %v", key.Value, err)
+		return content, false, false
+	}
+	newContent := strings.Join(slices.Insert(lines, end, encoded...), "\n")
+	if _, err := parseValuesNode(newContent); err != nil {
+		Logger.Warnf("Could not inject %s: injected values do not parse: %v", key.Value, err)
+		return content, false, false
+	}
+	return newContent, true, true
+}
+
+// addValuesKey adds a new key to a mapping, appending it after the mapping's last key.
+// New root-level keys are separated from the previous section by a blank line.
+func addValuesKey(content string, root *yamlv3.Node, frames []valuesFrame, mapping *yamlv3.Node, key string, value *yamlv3.Node, atRoot bool) (string, bool, bool) {
+	keyNode := &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: key}
+	Logger.Infof("Adding new key '%s'", key)
+
+	if len(mapping.Content) == 0 || isFlowNode(mapping) {
+		// Empty or flow style mapping: rewrite it together with its own key
+		mapping.Content = append(mapping.Content, keyNode, value)
+		if len(mapping.Content) == 2 {
+			mapping.Style &^= yamlv3.FlowStyle
 		}
+		if len(frames) == 0 {
+			return encodeValuesDocument(content, root)
+		}
+		newContent, err := spliceValuesFrame(content, frames, len(frames)-1)
+		if err != nil {
+			Logger.Warnf("Could not add %s: %v", key, err)
+			return content, false, false
+		}
+		return newContent, true, true
+	}
+
+	lines := strings.Split(content, "\n")
+	indent := mapping.Content[0].Column - 1
+	end := len(lines)
+	if len(frames) > 0 {
+		end = frameEndLine(frames, len(frames)-1, len(lines))
+		end = trimFrameEnd(lines, frames[len(frames)-1].parent.Content[frames[len(frames)-1].index].Line, end, indent-1)
+	} else {
+		end = trimFrameEnd(lines, 0, end, -1)
+	}
 
-		modified = true
-		actuallyInjected = true
-		Logger.Infof("Added new root-level key '%s' with indentOffset=%d", key, indentOffset)
+	encoded, err := encodePair(keyNode, value, strings.Repeat(" ", indent), indent)
+	if err != nil {
+		Logger.Warnf("Could not add %s: %v", key, err)
+		return content, false, false
+	}
+	if atRoot && end > 0 && strings.TrimSpace(lines[end-1]) != "" {
+		encoded = append([]string{""}, encoded...)
 	}
+	result := append(append(append([]string{}, lines[:end]...), encoded...), lines[end:]...)
+	return strings.Join(result, "\n"), true, true
+}
 
-	return strings.Join(result, "\n"), modified, actuallyInjected
+// encodeValuesDocument re-encodes the whole values document. Only used when the values root
+// itself is written in flow style or is empty, where there are no lines worth preserving.
+func encodeValuesDocument(content string, root *yamlv3.Node) (string, bool, bool) {
+	var buf bytes.Buffer
+	enc := yamlv3.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		Logger.Warnf("Could not encode values.yaml: %v", err)
+		return content, false, false
+	}
+	enc.Close()
+	return buf.String(), true, true
 }
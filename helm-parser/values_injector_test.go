@@ -94,7 +94,7 @@ someOtherKey: value
 	}
 
 	// Inject into values
-	if err := InjectIntoValuesFile(tmpDir, blocks, refs, false, false, ""); err != nil {
+	if err := InjectIntoValuesFile(tmpDir, blocks, refs, defaultCategories()); err != nil {
 		t.Fatalf("InjectIntoValuesFile failed: %v", err)
 	}
 
@@ -149,3 +149,150 @@ someOtherKey: value
 		}
 	}
 }
+
+func TestInjectBlockIntoValuesPath_Structural(t *testing.T) {
+	toleration := "tolerations:\n- key: dedicated\n  operator: Exists\n"
+	tests := []struct {
+		name     string
+		values   string
+		path     []string
+		expected []string
+	}{
+		{
+			name: "flow style map",
+			values: `webhook: {enabled: true, tolerations: []}
+replicas: 1
+`,
+			path:     []string{"webhook", "tolerations"},
+			expected: []string{"webhook: {enabled: true, tolerations: [{key: dedicated, operator: Exists}]}", "replicas: 1"},
+		},
+		{
+			name: "multi-line string before the target",
+			values: `gateway:
+  script: |
+    tolerations: []
+    echo done
+  tolerations: [] # set by the platform
+`,
+			path:     []string{"gateway", "tolerations"},
+			expected: []string{"    tolerations: []", "  tolerations: # set by the platform", "    - key: dedicated"},
+		},
+		{
+			name: "alias is not edited through",
+			values: `defaults: &defaults
+  tolerations: []
+gateway: *defaults
+`,
+			path:     []string{"gateway", "tolerations"},
+			expected: []string{"  tolerations: []", "gateway:", "    - key: dedicated"},
+		},
+		{
+			name:     "tab after key",
+			values:   "webhook:\n  enabled:\ttrue\n  tolerations:\t[]\n",
+			path:     []string{"webhook", "tolerations"},
+			expected: []string{"  enabled:\ttrue", "    - key: dedicated"},
+		},
+		{
+			name: "list of maps",
+			values: `gateways:
+- name: ingress
+  tolerations: []
+- name: egress
+`,
+			path:     []string{"gateways", "0", "tolerations"},
+			expected: []string{"- name: ingress", "    - key: dedicated", "- name: egress"},
+		},
+		{
+			name: "wrapper key",
+			values: `_internal_defaults_do_not_set:
+  # Pilot settings
+  pilot:
+    enabled: true
+`,
+			path:     []string{"pilot", "tolerations"},
+			expected: []string{"  # Pilot settings", "    tolerations:", "      - key: dedicated"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ref := ValueReference{Path: tt.path, Key: "tolerations"}
+			got, modified, injected := injectBlockIntoValuesPath(tt.values, ref, []InjectorBlock{{Block: toleration}})
+			if !modified || !injected {
+				t.Fatalf("Expected the block to be injected, got:\n%s", got)
+			}
+			lines := strings.Split(got, "\n")
+			for _, line := range tt.expected {
+				if !containsLine(lines, line) {
+					t.Errorf("Expected line %q in:\n%s", line, got)
+				}
+			}
+			if _, err := parseValuesNode(got); err != nil {
+				t.Errorf("Result does not parse: %v\n%s", err, got)
+			}
+
+			// Injecting again must not add the same toleration twice
+			again, modified, _ := injectBlockIntoValuesPath(got, ref, []InjectorBlock{{Block: toleration}})
+			if modified || again != got {
+				t.Errorf("Expected no changes on the second pass, got:\n%s", again)
+			}
+			t.Logf("✓ %s injected structurally", tt.name)
+		})
+	}
+}
+
+func TestInjectBlockIntoValuesPath_PreservesComments(t *testing.T) {
+	values := `# Global settings
+global:
+  # Pod tolerations
+  tolerations:
+  - key: existing # keep me
+    operator: Exists
+
+  # Resource settings
+  resources: {}
+`
+	ref := ValueReference{Path: []string{"global", "tolerations"}, Key: "tolerations"}
+	got, modified, _ := injectBlockIntoValuesPath(values, ref, []InjectorBlock{{Block: "tolerations:\n- key: dedicated\n  operator: Exists\n"}})
+	if !modified {
+		t.Fatalf("Expected values to be modified")
+	}
+	lines := strings.Split(got, "\n")
+	// The existing items keep their indentation, the new ones follow it
+	for _, line := range []string{"# Global settings", "  # Pod tolerations", "  - key: existing # keep me", "  - key: dedicated", "", "  # Resource settings", "  resources: {}"} {
+		if !containsLine(lines, line) {
+			t.Errorf("Expected line %q in:\n%s", line, got)
+		}
+	}
+	if strings.Index(got, "key: existing") > strings.Index(got, "key: dedicated") {
+		t.Errorf("Expected existing tolerations to stay first:\n%s", got)
+	}
+	t.Log("✓ Comments and key order preserved")
+}
+
+func TestInjectBlockIntoValuesPath_TrailingNewline(t *testing.T) {
+	block := []InjectorBlock{{Block: "tolerations:\n- key: dedicated\n  operator: Exists\n"}}
+	ref := ValueReference{Path: []string{"tolerations"}, Key: "tolerations"}
+	tests := map[string]string{
+		"new key":     "replicas: 1",
+		"new key eol": "replicas: 1\n",
+		"append":      "tolerations:\n  - key: existing\n    operator: Exists",
+		"append eol":  "tolerations:\n  - key: existing\n    operator: Exists\n",
+	}
+	for name, values := range tests {
+		got, modified, _ := injectBlockIntoValuesPath(values, ref, block)
+		if !modified {
+			t.Fatalf("%s: expected values to be modified", name)
+		}
+		if strings.HasSuffix(got, "\n") != strings.HasSuffix(values, "\n") {
+			t.Errorf("%s: expected the trailing newline of the file to be kept, got %q", name, got)
+		}
+		if !strings.Contains(values, "tolerations") {
+			continue
+		}
+		if !strings.HasPrefix(got, strings.TrimSuffix(values, "\n")+"\n  - key: dedicated\n    operator: Exists") {
+			t.Errorf("%s: expected only the new item appended, got:\n%s", name, got)
+		}
+	}
+	t.Log("✓ Trailing newline kept and only new items written")
+}
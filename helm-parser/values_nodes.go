@@ -0,0 +1,294 @@
+package helm_parser
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// parseValuesNode parses values.yaml into a yaml.v3 node tree. Unlike yaml.v2 maps the
+// nodes keep their line/column positions, styles and comments, so callers can edit the
+// original text in place without reformatting the rest of the file.
+func parseValuesNode(content string) (*yamlv3.Node, error) {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal([]byte(content), &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == yamlv3.DocumentNode && len(doc.Content) > 0 {
+		return doc.Content[0], nil
+	}
+	// Empty document
+	return &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}, nil
+}
+
+// resolveAlias follows an alias node to the node it refers to
+func resolveAlias(node *yamlv3.Node) *yamlv3.Node {
+	for node != nil && node.Kind == yamlv3.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+// mappingValue returns the key and value nodes for key in a mapping node, or nil if absent
+func mappingValue(mapping *yamlv3.Node, key string) (*yamlv3.Node, *yamlv3.Node) {
+	mapping = resolveAlias(mapping)
+	if mapping == nil || mapping.Kind != yamlv3.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i], mapping.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// scalarValue returns the value of key in a mapping if it is a scalar
+func scalarValue(mapping *yamlv3.Node, key string) (*yamlv3.Node, bool) {
+	_, value := mappingValue(mapping, key)
+	value = resolveAlias(value)
+	if value == nil || value.Kind != yamlv3.ScalarNode {
+		return nil, false
+	}
+	return value, true
+}
+
+// walkMappings calls fn for every mapping node in the tree together with its path.
+// Sequence items are addressed by their index, e.g. ["gateways", "0"].
+func walkMappings(node *yamlv3.Node, path []string, fn func(path []string, mapping *yamlv3.Node)) {
+	switch node.Kind {
+	case yamlv3.MappingNode:
+		fn(path, node)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			childPath := append(append([]string{}, path...), node.Content[i].Value)
+			walkMappings(node.Content[i+1], childPath, fn)
+		}
+	case yamlv3.SequenceNode:
+		for i, item := range node.Content {
+			childPath := append(append([]string{}, path...), strconv.Itoa(i))
+			walkMappings(item, childPath, fn)
+		}
+	}
+	// Aliases are not followed so shared anchors are only visited once
+}
+
+// runeColumnToByte converts a 1-based rune column on a line to a byte offset
+func runeColumnToByte(line string, column int) int {
+	offset := 0
+	for i := 1; i < column && offset < len(line); i++ {
+		_, size := utf8.DecodeRuneInString(line[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// scalarEnd returns the byte offset just after the scalar token starting at start
+func scalarEnd(line string, start int, style yamlv3.Style) (int, error) {
+	switch {
+	case style&yamlv3.DoubleQuotedStyle != 0:
+		for i := start + 1; i < len(line); i++ {
+			if line[i] == '\\' {
+				i++
+				continue
+			}
+			if line[i] == '"' {
+				return i + 1, nil
+			}
+		}
+		return 0, fmt.Errorf("unterminated double-quoted scalar")
+	case style&yamlv3.SingleQuotedStyle != 0:
+		for i := start + 1; i < len(line); i++ {
+			if line[i] == '\'' {
+				if i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					continue
+				}
+				return i + 1, nil
+			}
+		}
+		return 0, fmt.Errorf("unterminated single-quoted scalar")
+	case style&(yamlv3.LiteralStyle|yamlv3.FoldedStyle) != 0:
+		return 0, fmt.Errorf("block scalars cannot be replaced in place")
+	}
+	// Plain scalar: ends at a comment or the end of the line
+	end := len(line)
+	if idx := strings.Index(line[start:], " #"); idx >= 0 {
+		end = start + idx
+	}
+	// In flow collections a plain scalar also ends at , ] or }
+	if idx := strings.IndexAny(line[start:end], ",]}"); idx >= 0 {
+		end = start + idx
+	}
+	return start + len(strings.TrimRight(line[start:end], " \t")), nil
+}
+
+// quoteScalar formats value in the given scalar style
+func quoteScalar(value string, style yamlv3.Style) string {
+	switch {
+	case style&yamlv3.DoubleQuotedStyle != 0:
+		return strconv.Quote(value)
+	case style&yamlv3.SingleQuotedStyle != 0:
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+	if value == "" {
+		return `""`
+	}
+	return value
+}
+
+// replaceScalarAt replaces the single-line scalar node in lines with value, keeping its
+// quoting style and anything else on the line (keys, trailing comments)
+func replaceScalarAt(lines []string, node *yamlv3.Node, value string) error {
+	if node.Line < 1 || node.Line > len(lines) {
+		return fmt.Errorf("scalar position %d is outside the file", node.Line)
+	}
+	line := lines[node.Line-1]
+	start := runeColumnToByte(line, node.Column)
+	end, err := scalarEnd(line, start, node.Style)
+	if err != nil {
+		return fmt.Errorf("line %d: %v", node.Line, err)
+	}
+	lines[node.Line-1] = line[:start] + quoteScalar(value, node.Style) + line[end:]
+	return nil
+}
+
+// mappingKeyIndex returns the index of key's key node in a mapping's content, or -1
+func mappingKeyIndex(mapping *yamlv3.Node, key string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergedValue returns the value of key inherited through YAML merge keys (<<: *base)
+func mergedValue(mapping *yamlv3.Node, key string) *yamlv3.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != "<<" || mapping.Content[i].Tag != "!!merge" {
+			continue
+		}
+		sources := []*yamlv3.Node{mapping.Content[i+1]}
+		if resolveAlias(sources[0]).Kind == yamlv3.SequenceNode {
+			sources = resolveAlias(sources[0]).Content
+		}
+		for _, src := range sources {
+			if _, value := mappingValue(src, key); value != nil {
+				return value
+			}
+		}
+	}
+	return nil
+}
+
+// copyNode deep copies a node tree, resolving aliases and dropping anchors so the copy can
+// be edited without changing the anchored original
+func copyNode(node *yamlv3.Node) *yamlv3.Node {
+	node = resolveAlias(node)
+	if node == nil {
+		return nil
+	}
+	c := *node
+	c.Anchor = ""
+	c.Content = make([]*yamlv3.Node, len(node.Content))
+	for i, child := range node.Content {
+		c.Content[i] = copyNode(child)
+	}
+	return &c
+}
+
+// isEmptyNode reports whether a value is null, an empty string or an empty list or map
+func isEmptyNode(node *yamlv3.Node) bool {
+	node = resolveAlias(node)
+	switch {
+	case node == nil:
+		return true
+	case node.Kind == yamlv3.ScalarNode:
+		// An empty string is unset for Helm, e.g. {{- if .Values.priorityClassName }}
+		return node.Tag == "!!null" || (node.Tag == "!!str" && node.Value == "")
+	case node.Kind == yamlv3.SequenceNode || node.Kind == yamlv3.MappingNode:
+		return len(node.Content) == 0
+	}
+	return false
+}
+
+// isNullNode reports whether a value is an explicit null
+func isNullNode(node *yamlv3.Node) bool {
+	node = resolveAlias(node)
+	return node != nil && node.Kind == yamlv3.ScalarNode && node.Tag == "!!null"
+}
+
+// nodesEqual reports whether two nodes hold the same data, ignoring style and comments
+func nodesEqual(a, b *yamlv3.Node) bool {
+	var va, vb interface{}
+	if err := a.Decode(&va); err != nil {
+		return false
+	}
+	if err := b.Decode(&vb); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// isFlowNode reports whether a collection is written in flow style ({...} or [...])
+func isFlowNode(node *yamlv3.Node) bool {
+	return node.Style&yamlv3.FlowStyle != 0
+}
+
+// encodePair encodes a single key/value pair in block style. The first line is prefixed with
+// firstPrefix and the following lines are indented by indent spaces.
+func encodePair(key, value *yamlv3.Node, firstPrefix string, indent int) ([]string, error) {
+	k := *key
+	// The head comment stays in place above the key line
+	k.HeadComment = ""
+	if value.LineComment != "" && value.Kind != yamlv3.ScalarNode && !isFlowNode(value) {
+		// A block collection cannot carry a line comment, keep it on the key line instead
+		v := *value
+		k.LineComment, v.LineComment = v.LineComment, ""
+		value = &v
+	}
+	var buf bytes.Buffer
+	enc := yamlv3.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&yamlv3.Node{Kind: yamlv3.MappingNode, Content: []*yamlv3.Node{&k, value}}); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	for i, line := range lines {
+		switch {
+		case i == 0:
+			lines[i] = firstPrefix + line
+		case line != "":
+			lines[i] = strings.Repeat(" ", indent) + line
+		}
+	}
+	return lines, nil
+}
+
+// encodeNode encodes a node on its own and indents every non-empty line by indent
+func encodeNode(node *yamlv3.Node, indent int) ([]string, error) {
+	var buf bytes.Buffer
+	enc := yamlv3.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = strings.Repeat(" ", indent) + line
+		}
+	}
+	return lines, nil
+}
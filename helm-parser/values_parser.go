@@ -13,11 +13,14 @@ type ValueReference struct {
 
 // DetectValueReferences scans template files to detect which .Values keys are referenced
 // Returns a list of ValueReference with full paths (e.g., .Values.webhook.tolerations)
+// A path rendered as the value of a pod or container key is keyed by that key, e.g.
+// .Values.global.defaultTolerations rendered under tolerations: has the key tolerations.
 func DetectValueReferences(templateContent string) []ValueReference {
 	var references []ValueReference
 	seen := make(map[string]bool) // Track duplicates
 
 	lines := strings.Split(templateContent, "\n")
+	renderedKeys := blockValueKeys(lines)
 	for _, line := range lines {
 		trimmed := strings.TrimSpace(line)
 
@@ -30,6 +33,9 @@ func DetectValueReferences(templateContent string) []ValueReference {
 				if keyPath != "" && !seen[keyPath] {
 					seen[keyPath] = true
 					ref := parseValuePath(keyPath)
+					if key, ok := renderedKeys[keyPath]; ok {
+						ref.Key = key
+					}
 					if ref.Key != "" {
 						references = append(references, ref)
 					}
@@ -72,36 +78,3 @@ func parseValuePath(pathStr string) ValueReference {
 		Key:  key,
 	}
 }
-
-// detectWrapperPattern detects if values.yaml uses a wrapper pattern
-// like Istio's _internal_defaults_do_not_set and returns the indent offset
-func detectWrapperPattern(content string) int {
-	lines := strings.Split(content, "\n")
-
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-
-		// Skip empty lines and comments
-		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
-			continue
-		}
-
-		// Check if first non-comment key is a wrapper pattern
-		if strings.Contains(trimmed, ":") {
-			indent := GetIndentation(line)
-			if indent == 0 {
-				key := strings.TrimSpace(strings.Split(trimmed, ":")[0])
-				// Check against known wrapper patterns
-				for _, wrapperKey := range KnownWrapperKeys {
-					if key == wrapperKey {
-						// Return the expected indent of children (typically 2)
-						return 2
-					}
-				}
-			}
-			break
-		}
-	}
-
-	return 0
-}
@@ -0,0 +1,162 @@
+package helm_parser
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// WorkloadKind describes where a resource kind keeps its pod templates
+type WorkloadKind struct {
+	APIVersion string   `yaml:"apiVersion,omitempty"` // Only match templates with this apiVersion, e.g. argoproj.io/v1alpha1
+	PodSpecs   []string `yaml:"podSpecs"`             // Dot paths of the pod specs, e.g. spec.template.spec
+	Containers []string `yaml:"containers,omitempty"` // Dot paths of container lists outside the pod specs
+}
+
+// UnmarshalYAML accepts a single pod spec path as shorthand, e.g. "Rollout: spec.template.spec"
+func (k *WorkloadKind) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var podSpec string
+	if err := unmarshal(&podSpec); err == nil {
+		k.PodSpecs = []string{podSpec}
+		return nil
+	}
+	type plain WorkloadKind
+	return unmarshal((*plain)(k))
+}
+
+// builtinWorkloadKinds are the Kubernetes kinds with a pod template
+var builtinWorkloadKinds = map[string]WorkloadKind{
+	"Deployment":  {PodSpecs: []string{"spec.template.spec"}},
+	"StatefulSet": {PodSpecs: []string{"spec.template.spec"}},
+	"DaemonSet":   {PodSpecs: []string{"spec.template.spec"}},
+	"ReplicaSet":  {PodSpecs: []string{"spec.template.spec"}},
+	"Job":         {PodSpecs: []string{"spec.template.spec"}},
+	"CronJob":     {PodSpecs: []string{"spec.jobTemplate.spec.template.spec"}},
+	"Pod":         {PodSpecs: []string{"spec"}},
+}
+
+// WorkloadKinds holds the additional kinds loaded from --workload-kinds, e.g. operator CRDs
+var WorkloadKinds map[string]WorkloadKind
+
+// LoadWorkloadKinds reads a workload kinds file. An empty path returns nil.
+//
+//	kinds:
+//	  Rollout: spec.template.spec
+//	  Prometheus:
+//	    apiVersion: monitoring.coreos.com/v1
+//	    podSpecs: [spec]
+//	  FlinkDeployment:
+//	    podSpecs: [spec.podTemplate.spec, spec.jobManager.podTemplate.spec, spec.taskManager.podTemplate.spec]
+func LoadWorkloadKinds(kindsPath string) (map[string]WorkloadKind, error) {
+	if kindsPath == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(kindsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workload kinds file: %v", err)
+	}
+	var file struct {
+		Kinds map[string]WorkloadKind `yaml:"kinds"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", kindsPath, err)
+	}
+	for name, kind := range file.Kinds {
+		if len(kind.PodSpecs) == 0 && len(kind.Containers) == 0 {
+			return nil, fmt.Errorf("kind %s in %s needs podSpecs or containers", name, kindsPath)
+		}
+		for _, p := range append(slices.Clone(kind.PodSpecs), kind.Containers...) {
+			if p == "" || slices.Contains(strings.Split(p, "."), "") {
+				return nil, fmt.Errorf("kind %s in %s has an invalid path %q", name, kindsPath, p)
+			}
+		}
+	}
+	return file.Kinds, nil
+}
+
+// lookupWorkloadKind returns the registered or built-in kind with the given name.
+// Registered kinds take precedence so they can refine a built-in one. A templated kind
+// named by the kinds it may render as, e.g. Deployment|Pod, has the paths of all of them.
+func lookupWorkloadKind(name string) (WorkloadKind, bool) {
+	if names := strings.Split(name, "|"); len(names) > 1 {
+		var union WorkloadKind
+		for _, n := range names {
+			kind, ok := lookupWorkloadKind(n)
+			if !ok {
+				return WorkloadKind{}, false
+			}
+			for _, p := range kind.PodSpecs {
+				if !slices.Contains(union.PodSpecs, p) {
+					union.PodSpecs = append(union.PodSpecs, p)
+				}
+			}
+			for _, p := range kind.Containers {
+				if !slices.Contains(union.Containers, p) {
+					union.Containers = append(union.Containers, p)
+				}
+			}
+		}
+		return union, true
+	}
+	if kind, ok := WorkloadKinds[name]; ok {
+		return kind, true
+	}
+	kind, ok := builtinWorkloadKinds[name]
+	return kind, ok
+}
+
+// isPodSpecPath reports whether path is one of the pod specs of the kind
+func (k WorkloadKind) isPodSpecPath(path []string) bool {
+	return slices.Contains(k.PodSpecs, strings.Join(path, "."))
+}
+
+// containerList returns the list a container path (e.g. spec.template.spec.initContainers)
+// stands for: containers, initContainers or ephemeralContainers, or "" if it holds no containers.
+// The container paths of the kind hold regular containers.
+func (k WorkloadKind) containerList(path []string) string {
+	if len(path) == 0 {
+		return ""
+	}
+	if slices.Contains(k.Containers, strings.Join(path, ".")) {
+		return "containers"
+	}
+	switch list := path[len(path)-1]; list {
+	case "containers", "initContainers", "ephemeralContainers":
+		if k.isPodSpecPath(path[:len(path)-1]) {
+			return list
+		}
+	}
+	return ""
+}
+
+// customContainers returns the containers at the container paths of the kind of a rendered
+// document, which a walk for containers and initContainers keys does not find
+func customContainers(doc map[string]interface{}) []map[string]interface{} {
+	name, _ := doc["kind"].(string)
+	kind, ok := WorkloadKinds[name]
+	if !ok {
+		return nil
+	}
+	var containers []map[string]interface{}
+	for _, p := range kind.Containers {
+		// Already found by the walk
+		if strings.HasSuffix(p, ".containers") || strings.HasSuffix(p, ".initContainers") {
+			continue
+		}
+		var node interface{} = doc
+		for _, key := range strings.Split(p, ".") {
+			m, _ := node.(map[string]interface{})
+			node = m[key]
+		}
+		items, _ := node.([]interface{})
+		for _, item := range items {
+			if c, ok := item.(map[string]interface{}); ok {
+				containers = append(containers, c)
+			}
+		}
+	}
+	return containers
+}
@@ -0,0 +1,159 @@
+package helm_parser
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const workloadKindsTestFile = `kinds:
+  Rollout:
+    apiVersion: argoproj.io/v1alpha1
+    podSpecs: [spec.template.spec]
+  Kafka:
+    podSpecs: [spec.kafka.template.pod]
+    containers: [spec.kafka.sidecars]
+  Prometheus: spec
+`
+
+// useWorkloadKinds loads a workload kinds file for the duration of a test
+func useWorkloadKinds(t *testing.T, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "workload-kinds.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write workload kinds: %v", err)
+	}
+	kinds, err := LoadWorkloadKinds(path)
+	if err != nil {
+		t.Fatalf("Failed to load workload kinds: %v", err)
+	}
+	previous := WorkloadKinds
+	WorkloadKinds = kinds
+	t.Cleanup(func() { WorkloadKinds = previous })
+}
+
+func TestLoadWorkloadKinds(t *testing.T) {
+	useWorkloadKinds(t, workloadKindsTestFile)
+	if got := WorkloadKinds["Prometheus"].PodSpecs; !slices.Equal(got, []string{"spec"}) {
+		t.Errorf("Expected the shorthand to set the pod spec, got %v", got)
+	}
+
+	tests := map[string]string{
+		"no paths":      "kinds:\n  Rollout: {apiVersion: argoproj.io/v1alpha1}\n",
+		"empty key":     "kinds:\n  Rollout: spec..spec\n",
+		"unknown field": "kinds:\n  Rollout: {podSpec: spec.template.spec}\n",
+	}
+	for name, content := range tests {
+		path := filepath.Join(t.TempDir(), "workload-kinds.yaml")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write workload kinds: %v", err)
+		}
+		if _, err := LoadWorkloadKinds(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	t.Log("✓ Workload kinds loaded and validated")
+}
+
+func TestGetK8sResourceKind_WorkloadKinds(t *testing.T) {
+	useWorkloadKinds(t, workloadKindsTestFile)
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"apiVersion: argoproj.io/v1alpha1\nkind: Rollout\n", "Rollout"},
+		{"apiVersion: example.com/v1\nkind: Rollout\n", ""},
+		{"apiVersion: {{ .Values.apiVersion }}\nkind: Rollout\n", "Rollout"},
+		{"apiVersion: kafka.strimzi.io/v1beta2\nkind: Kafka\n", "Kafka"},
+		{"apiVersion: example.com/v1\nkind: Flink\n", ""},
+	}
+	for _, tt := range tests {
+		if got := getK8sResourceKind(tt.content, nil); got != tt.want {
+			t.Errorf("Expected kind %q for:\n%s\ngot %q", tt.want, tt.content, got)
+		}
+	}
+	t.Log("✓ Registered kinds recognised by name and apiVersion")
+}
+
+func TestInjectInline_WorkloadKinds(t *testing.T) {
+	useWorkloadKinds(t, workloadKindsTestFile)
+	blocks := loadTestBlocks(t, `allPods:
+- priorityClassName: platform-default
+allContainers:
+- env:
+  - name: CLUSTER
+    value: prod
+`)
+
+	input := `apiVersion: kafka.strimzi.io/v1beta2
+kind: Kafka
+metadata:
+  name: events
+spec:
+  kafka:
+    replicas: 3
+    template:
+      pod:
+        securityContext:
+          runAsNonRoot: true
+    sidecars:
+    - name: exporter
+      image: exporter:1.0
+  zookeeper:
+    replicas: 3`
+
+	result, err := injectInlinePodSpec(input, blocks, "Kafka")
+	if err == nil {
+		result, err = injectInlineContainerSpecWithBlocks(result, blocks)
+	}
+	if err != nil {
+		t.Fatalf("Inline injection failed: %v", err)
+	}
+
+	lines := strings.Split(result, "\n")
+	for _, line := range []string{"        priorityClassName: platform-default", "      env:", "        - name: CLUSTER"} {
+		if !containsLine(lines, line) {
+			t.Errorf("Expected line %q in:\n%s", line, result)
+		}
+	}
+	if strings.Count(result, "priorityClassName") != 1 {
+		t.Errorf("Expected the pod blocks only under the pod template:\n%s", result)
+	}
+	t.Log("✓ Blocks injected at the paths of a registered kind")
+}
+
+func TestExtractImagesFromManifest_WorkloadKinds(t *testing.T) {
+	useWorkloadKinds(t, workloadKindsTestFile)
+	manifest := `apiVersion: kafka.strimzi.io/v1beta2
+kind: Kafka
+metadata:
+  name: events
+spec:
+  kafka:
+    sidecars:
+    - name: exporter
+      image: repo/exporter:1.0
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Rollout
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: repo/web:2.0
+`
+	images, err := ExtractImagesFromManifest(manifest)
+	if err != nil {
+		t.Fatalf("ExtractImagesFromManifest failed: %v", err)
+	}
+	slices.Sort(images)
+	if !slices.Equal(images, []string{"repo/exporter:1.0", "repo/web:2.0"}) {
+		t.Errorf("Unexpected images %v", images)
+	}
+	t.Log("✓ Images found at the container paths of a registered kind")
+}
@@ -1,6 +1,7 @@
 package helm_parser
 
 import (
+	"slices"
 	"strings"
 )
 
@@ -207,3 +208,50 @@ func (ps *PathStack) Depth() int {
 func (ps *PathStack) Clear() {
 	ps.levels = ps.levels[:0]
 }
+
+// ListItemKey is the path element of a list item in LinePath
+const ListItemKey = "-"
+
+// LinePath returns the keys leading to the key on a line, e.g. ["spec", "template", "spec"].
+// Each list item on the way is a ListItemKey element. Blank, comment and Helm template
+// lines are skipped; the path stops at a "---" document separator.
+func LinePath(lines []string, index int) []string {
+	key, _, _ := ExtractKeyValue(strings.TrimPrefix(strings.TrimSpace(lines[index]), "- "))
+	path := []string{key}
+	indent := GetIndentation(lines[index])
+	inItem := IsListItem(lines[index])
+	if inItem {
+		path = append(path, ListItemKey)
+	}
+
+	for i := index - 1; i >= 0 && (indent > 0 || inItem); i-- {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "---" {
+			break
+		}
+		if IsEmptyOrComment(lines[i]) || strings.HasPrefix(trimmed, "{{") {
+			continue
+		}
+		lineIndent := GetIndentation(lines[i])
+		// A parent is less indented, except the key of a compact list ("key:\n- item")
+		if lineIndent > indent || (lineIndent == indent && (!inItem || IsListItem(lines[i]))) {
+			continue
+		}
+		key, _, _ := ExtractKeyValue(strings.TrimPrefix(trimmed, "- "))
+		if !IsListItem(lines[i]) {
+			path = append(path, key)
+			indent, inItem = lineIndent, false
+			continue
+		}
+		// The first key of a list item is the parent when nested deeper than the item's
+		// keys, or of a compact list at their indentation
+		if indent > lineIndent+2 || (inItem && indent == lineIndent+2) {
+			path = append(path, key)
+		}
+		path = append(path, ListItemKey)
+		indent, inItem = lineIndent, true
+	}
+
+	slices.Reverse(path)
+	return path
+}
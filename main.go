@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"time"
 
 	helm_parser "helm-parser/helm-parser"
 
@@ -20,11 +23,37 @@ var (
 	templatesDir   string
 	localRepo      string
 	customYaml     string
+	profiles       []string
 	criticalDs     bool
 	controlPlane   bool
 	systemCritical string
 	dryRun         bool
 	verbose        bool
+	pinDigests     bool
+
+	registryUsername      string
+	registryPasswordStdin bool
+	registryCredentials   string
+	registryRules         string
+	workloadKinds         string
+	mirrorConcurrency     int
+	mirrorRetries         int
+	verifyLock            bool
+	explainImages         bool
+
+	releaseName string
+	namespace   string
+	kubeVersion string
+	apiVersions []string
+	isInstall   bool
+	isUpgrade   bool
+	valueFiles  []string
+	setValues   []string
+	setStrings  []string
+	matrix      bool
+
+	// registryPassword is read from stdin when --registry-password-stdin is set
+	registryPassword string
 )
 
 var rootCmd = &cobra.Command{
@@ -32,28 +61,220 @@ var rootCmd = &cobra.Command{
 	Short: "Helm chart parser and modifier",
 	Long: `A tool to parse Helm charts, inject custom blocks, and update container registries.
 It can inject pod-level and container-level configurations into Helm templates or values.yaml files.`,
+	SilenceUsage: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		rules, err := helm_parser.LoadRegistryRules(registryRules)
+		if err != nil {
+			return err
+		}
+		helm_parser.RewriteRules = rules
+		kinds, err := helm_parser.LoadWorkloadKinds(workloadKinds)
+		if err != nil {
+			return err
+		}
+		helm_parser.WorkloadKinds = kinds
+		return readRegistryPassword()
+	},
+}
+
+var processCmd = &cobra.Command{
+	Use:   "process",
+	Short: "Run every phase: backup, registry rewrite, image check, injection and render",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return helm_parser.ProcessChart(options())
+	},
+}
+
+var imagesCmd = &cobra.Command{
+	Use:   "images",
+	Short: "List the container images the rendered chart deploys",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if explainImages {
+			provenance, err := helm_parser.ExplainImages(chartDir, options().Render)
+			if err != nil {
+				return err
+			}
+			helm_parser.WriteImageProvenance(cmd.OutOrStdout(), provenance)
+			return nil
+		}
+		images, err := helm_parser.ListImages(options())
+		if err != nil {
+			return err
+		}
+		for _, img := range images {
+			fmt.Fprintln(cmd.OutOrStdout(), img)
+		}
+		return nil
+	},
+}
+
+var registryCmd = &cobra.Command{
+	Use:   "registry",
+	Short: "Rewrite image registries in values.yaml and the templates to the local repository",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return helm_parser.UpdateRegistry(options())
+	},
+}
+
+var injectCmd = &cobra.Command{
+	Use:   "inject",
+	Short: "Inject the custom blocks into values.yaml and the chart templates",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return helm_parser.InjectBlocks(options())
+	},
+}
+
+var renderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Render the chart locally and print the manifest",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		manifest, err := helm_parser.RenderChart(options())
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(cmd.OutOrStdout(), manifest)
+		return nil
+	},
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Render the chart and check that every image exists in its registry",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// The lock check does not fail on missing or unreachable images, so CI without access
+		// to every registry can run it on its own
+		if verifyLock {
+			return helm_parser.VerifyImageLock(options())
+		}
+		return helm_parser.VerifyChart(options())
+	},
+}
+
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Write " + helm_parser.ImageLockFile + " listing every image the chart deploys",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return helm_parser.WriteImageLock(options())
+	},
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore values.yaml and the templates from the backups taken before the first modification",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return helm_parser.RestoreChart(options())
+	},
+}
+
+var mirrorCmd = &cobra.Command{
+	Use:   "mirror",
+	Short: "Copy images missing from the local repository from their upstream registries",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return helm_parser.ProcessChart(chartDir, localRepo, customYaml, criticalDs, controlPlane, systemCritical, dryRun, verbose)
+		mirrorOpts := helm_parser.MirrorOptions{
+			Concurrency: mirrorConcurrency,
+			Retries:     mirrorRetries,
+			RetryDelay:  2 * time.Second,
+		}
+		return helm_parser.MirrorChart(options(), mirrorOpts, cmd.OutOrStdout())
 	},
 }
 
+// readRegistryPassword reads the registry password from stdin, like docker login --password-stdin
+func readRegistryPassword() error {
+	if !registryPasswordStdin {
+		return nil
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read registry password from stdin: %v", err)
+	}
+	registryPassword = strings.TrimRight(string(data), "\r\n")
+	return nil
+}
+
+// options builds the shared chart processing options from the command line flags
+func options() helm_parser.Options {
+	// --critical-ds and --control-plane are kept as shorthands for their profiles
+	enabled := append([]string{}, profiles...)
+	if criticalDs {
+		enabled = append(enabled, "criticalDs")
+	}
+	if controlPlane {
+		enabled = append(enabled, "controlPlane")
+	}
+	return helm_parser.Options{
+		ChartPath:      chartDir,
+		LocalRepo:      localRepo,
+		CustomYaml:     customYaml,
+		Profiles:       enabled,
+		SystemCritical: systemCritical,
+		DryRun:         dryRun,
+		Verbose:        verbose,
+		PinDigests:     pinDigests,
+		RegistryAuth: helm_parser.RegistryAuthOptions{
+			Username:        registryUsername,
+			Password:        registryPassword,
+			CredentialsFile: registryCredentials,
+		},
+		Render: helm_parser.RenderOptions{
+			ReleaseName: releaseName,
+			Namespace:   namespace,
+			KubeVersion: kubeVersion,
+			APIVersions: apiVersions,
+			IsInstall:   isInstall,
+			IsUpgrade:   isUpgrade,
+			ValueFiles:  valueFiles,
+			Set:         setValues,
+			SetString:   setStrings,
+			Matrix:      matrix,
+		},
+	}
+}
+
 func init() {
-	rootCmd.Flags().StringVar(&localRepo, "local-repo", LOCAL_REPO, "Local repository prefix for images")
-	rootCmd.Flags().StringVar(&chartDir, "chart-dir", CHART_DIR, "Path to the Helm chart directory")
-	rootCmd.Flags().StringVar(&templatesDir, "templates-dir", TEMPLATES_DIR, "Path to the templates directory within the chart")
-	rootCmd.Flags().StringVar(&customYaml, "custom-yaml", "inject-blocks.yaml", "Path to a custom YAML file with injection blocks")
-	rootCmd.Flags().BoolVar(&criticalDs, "critical-ds", false, "Enable critical DaemonSet processing (adds criticalDsPods blocks)")
-	rootCmd.Flags().BoolVar(&controlPlane, "control-plane", false, "Enable control plane processing (adds controlPlanePods blocks)")
-	rootCmd.Flags().StringVar(&systemCritical, "system-critical", "", "Specify system critical component")
-	rootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Enable dry run mode (show changes without modifying files)")
-	rootCmd.Flags().BoolVar(&verbose, "verbose", false, "Enable verbose logging")
+	rootCmd.PersistentFlags().StringVar(&localRepo, "local-repo", LOCAL_REPO, "Local repository prefix for images")
+	rootCmd.PersistentFlags().StringVar(&chartDir, "chart-dir", CHART_DIR, "Path to the Helm chart directory")
+	rootCmd.PersistentFlags().StringVar(&templatesDir, "templates-dir", TEMPLATES_DIR, "Path to the templates directory within the chart")
+	rootCmd.PersistentFlags().StringVar(&customYaml, "custom-yaml", "inject-blocks.yaml", "Path to a custom YAML file with injection blocks")
+	rootCmd.PersistentFlags().StringArrayVar(&profiles, "profile", nil, "Enable a profile of the injection blocks, e.g. controlPlane (repeatable)")
+	rootCmd.PersistentFlags().BoolVar(&criticalDs, "critical-ds", false, "Enable critical DaemonSet processing (adds criticalDsPods blocks)")
+	rootCmd.PersistentFlags().BoolVar(&controlPlane, "control-plane", false, "Enable control plane processing (adds controlPlanePods blocks)")
+	rootCmd.PersistentFlags().MarkDeprecated("critical-ds", "use --profile criticalDs")
+	rootCmd.PersistentFlags().MarkDeprecated("control-plane", "use --profile controlPlane")
+	rootCmd.PersistentFlags().StringVar(&systemCritical, "system-critical", "", "Set the priorityClassName of every pod from the systemCritical*Pods blocks: node, cluster or default")
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Stage all changes in memory, print a unified diff of every file that would change and exit non-zero if changes are pending")
+	rootCmd.PersistentFlags().BoolVar(&pinDigests, "pin-digests", false, "Resolve every rendered image to its sha256 digest and pin it in values.yaml or the template image string")
+	rootCmd.PersistentFlags().BoolVar(&verbose, "verbose", false, "Enable verbose logging")
+	rootCmd.PersistentFlags().StringVar(&registryUsername, "registry-username", "", "Username for the local repository registry (default $"+helm_parser.RegistryUsernameEnv+")")
+	rootCmd.PersistentFlags().BoolVar(&registryPasswordStdin, "registry-password-stdin", false, "Read the local repository registry password from stdin (default $"+helm_parser.RegistryPasswordEnv+")")
+	rootCmd.PersistentFlags().StringVar(&registryRules, "registry-rules", "", "Path to a YAML file with ordered registry rewrite rules (default maps every image to --local-repo)")
+	rootCmd.PersistentFlags().StringVar(&workloadKinds, "workload-kinds", "", "Path to a YAML file registering more workload kinds (e.g. operator CRDs) with their pod spec and container paths")
+	rootCmd.PersistentFlags().StringVar(&registryCredentials, "registry-credentials", "", "Path to a YAML file with per-registry credentials")
+	rootCmd.PersistentFlags().StringVar(&releaseName, "release-name", "test", "Release name the chart is rendered as")
+	rootCmd.PersistentFlags().StringVarP(&namespace, "namespace", "n", "default", "Namespace the chart is rendered for")
+	rootCmd.PersistentFlags().StringVar(&kubeVersion, "kube-version", "", "Kubernetes version for .Capabilities.KubeVersion, e.g. v1.31.0 (default Helm's)")
+	rootCmd.PersistentFlags().StringSliceVarP(&apiVersions, "api-versions", "a", nil, "Extra API versions for .Capabilities.APIVersions, e.g. monitoring.coreos.com/v1/ServiceMonitor (repeatable)")
+	rootCmd.PersistentFlags().BoolVar(&isInstall, "is-install", false, "Render with .Release.IsInstall set")
+	rootCmd.PersistentFlags().BoolVar(&isUpgrade, "is-upgrade", false, "Render with .Release.IsUpgrade set")
+	rootCmd.PersistentFlags().StringArrayVarP(&valueFiles, "values", "f", nil, "Values file or URL layered over values.yaml when rendering, e.g. an environment overlay (repeatable)")
+	rootCmd.PersistentFlags().StringArrayVar(&setValues, "set", nil, "Set a value when rendering, e.g. image.tag=1.2 (repeatable)")
+	rootCmd.PersistentFlags().StringArrayVar(&setStrings, "set-string", nil, "Set a string value when rendering (repeatable)")
+	rootCmd.PersistentFlags().BoolVar(&matrix, "values-matrix", false, "Render each --values file on its own and check the images and blocks of every overlay")
 
 	// Mark required flags if needed
 	// rootCmd.MarkFlagRequired("chart-dir")
-	//deprecated. specify in allPods only
+	// Values of --system-critical, see the systemCritical*Pods categories
 	rootCmd.RegisterFlagCompletionFunc("system-critical", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
-		return []string{"node", "cluster", ""}, cobra.ShellCompDirectiveNoFileComp
+		return []string{"node", "cluster", "default"}, cobra.ShellCompDirectiveNoFileComp
 	})
+
+	mirrorCmd.Flags().IntVar(&mirrorConcurrency, "concurrency", 4, "Number of images to copy in parallel")
+	mirrorCmd.Flags().IntVar(&mirrorRetries, "retries", 3, "Number of retries per image after a failed copy")
+
+	imagesCmd.Flags().BoolVar(&explainImages, "explain", false, "Show the values paths and containers that produce each image, for every values overlay")
+	verifyCmd.Flags().BoolVar(&verifyLock, "lock", false, "Check the rendered images against "+helm_parser.ImageLockFile+" instead of the registry")
+
+	rootCmd.AddCommand(processCmd, imagesCmd, registryCmd, injectCmd, renderCmd, verifyCmd, restoreCmd, mirrorCmd, lockCmd)
 }
 
 func main() {